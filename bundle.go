@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+
+	"gitre/gitre"
+)
+
+// bundle create <file> <rev-range>... | bundle verify <file>
+func bundle(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: gitre bundle create <file> <rev-range>... | gitre bundle verify <file>")
+	}
+	switch args[0] {
+	case "create":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: gitre bundle create <file> <rev-range>...")
+		}
+		return bundleCreate(userPath(args[1]), args[2:])
+	case "verify":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: gitre bundle verify <file>")
+		}
+		return bundleVerify(userPath(args[1]))
+	}
+	return fmt.Errorf("unknown bundle subcommand: %s", args[0])
+}
+
+// revs are refs to include, ^<rev> or <rev>.. excluding what the receiver already has, or --all for every branch and tag
+func bundleCreate(file string, revs []string) error {
+	refs := map[string]string{}
+	var excluded []string
+	include := func(name string) error {
+		ref, hash, err := namedRef(name)
+		if err != nil {
+			return err
+		}
+		refs[ref] = hash
+		return nil
+	}
+	exclude := func(rev string) error {
+		hash, err := repo.ResolveRev(rev)
+		if err != nil {
+			return err
+		}
+		excluded = append(excluded, hash)
+		return nil
+	}
+	for _, rev := range revs {
+		var err error
+		switch {
+		case rev == "--all":
+			var all map[string]string
+			if all, err = branchesAndTags(); err == nil {
+				maps.Copy(refs, all)
+			}
+		case strings.HasPrefix(rev, "^"):
+			err = exclude(rev[1:])
+		case strings.Contains(rev, ".."):
+			from, to, _ := strings.Cut(rev, "..")
+			if from == "" || to == "" {
+				return fmt.Errorf("both ends of the range %s are needed", rev)
+			}
+			if err = exclude(from); err == nil {
+				err = include(to)
+			}
+		case strings.HasPrefix(rev, "-"):
+			return fmt.Errorf("unknown option for bundle create: %s", rev)
+		default:
+			err = include(rev)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(refs) == 0 {
+		return fmt.Errorf("refusing to create an empty bundle")
+	}
+
+	// the current branch is the default one when it is bundled, the first bundled branch otherwise
+	head, _, err := repo.ReadHead()
+	if err != nil {
+		return err
+	}
+	if refs[head] == "" {
+		head = ""
+		for _, name := range gitre.SortedRefs(refs) {
+			if strings.HasPrefix(name, "refs/heads/") {
+				head = name
+				break
+			}
+		}
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+	if err := repo.WriteBundle(f, refs, head, excluded); err != nil {
+		f.Close()
+		os.Remove(file)
+		return err
+	}
+	return f.Close()
+}
+
+// ref a revision given by name stands for: a branch, a tag or the branch HEAD points at
+func namedRef(name string) (string, string, error) {
+	if name == "HEAD" {
+		head, hash, err := repo.ReadHead()
+		if err != nil {
+			return "", "", err
+		}
+		if head == "" || hash == "" {
+			return "", "", fmt.Errorf("HEAD is not on a branch with commits, name the branch to bundle")
+		}
+		return head, hash, nil
+	}
+	candidates := []string{"refs/heads/" + name, "refs/tags/" + name}
+	if strings.HasPrefix(name, "refs/") {
+		candidates = []string{name}
+	}
+	for _, ref := range candidates {
+		hash, err := repo.ReadRef(ref)
+		if err != nil {
+			return "", "", err
+		}
+		if hash != "" {
+			return ref, hash, nil
+		}
+	}
+	return "", "", fmt.Errorf("'%s' is not a branch or tag", name)
+}
+
+// every branch and tag
+func branchesAndTags() (map[string]string, error) {
+	refs, err := repo.ListRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	for name := range refs {
+		if !strings.HasPrefix(name, "refs/heads/") && !strings.HasPrefix(name, "refs/tags/") {
+			delete(refs, name)
+		}
+	}
+	return refs, nil
+}
+
+func bundleVerify(file string) error {
+	b, err := gitre.OpenBundle(file)
+	if err != nil {
+		return err
+	}
+	if missing := b.MissingPrerequisites(repo); len(missing) > 0 {
+		return fmt.Errorf("repository lacks these prerequisite commits:\n\t%s", strings.Join(missing, "\n\t"))
+	}
+	fmt.Printf("The bundle contains %d refs:\n", len(b.Refs))
+	for _, name := range gitre.SortedRefs(b.Refs) {
+		fmt.Printf("%s %s\n", b.Refs[name], name)
+	}
+	if len(b.Prerequisites) == 0 {
+		fmt.Println("The bundle records a complete history.")
+	} else {
+		fmt.Printf("The bundle requires these %d commits:\n", len(b.Prerequisites))
+		for _, hash := range b.Prerequisites {
+			fmt.Println(hash)
+		}
+	}
+	fmt.Printf("%s is okay\n", file)
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// check-ignore [-v] <paths>, reports whether any path is ignored
+func checkIgnore(args []string) (bool, error) {
+	verbose := false
+	var paths []string
+	for _, arg := range args {
+		if arg == "-v" || arg == "--verbose" {
+			verbose = true
+		} else {
+			paths = append(paths, userPath(arg))
+		}
+	}
+	if len(paths) == 0 {
+		return false, fmt.Errorf("no path specified")
+	}
+
+	ignores := repo.Ignores()
+	anyIgnored := false
+	for _, p := range paths {
+		isDir := false
+		if info, err := os.Stat(p); err == nil {
+			isDir = info.IsDir()
+		}
+		rule := ignores.Match(p, isDir)
+		if rule == nil || (!verbose && rule.Negate) {
+			continue
+		}
+		if !rule.Negate {
+			anyIgnored = true
+		}
+		if verbose {
+			fmt.Printf("%s:%d:%s\t%s\n", rule.Source, rule.Line, rule.Pattern, p)
+		} else {
+			fmt.Println(p)
+		}
+	}
+	return anyIgnored, nil
+}
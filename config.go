@@ -0,0 +1,27 @@
+package main
+
+import "fmt"
+
+// config <key> [<value>] or config --unset <key>
+func config(args []string) error {
+	cfg, err := repo.ReadConfig()
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(args) == 2 && args[0] == "--unset":
+		cfg.Unset(args[1])
+		return cfg.Write()
+	case len(args) == 1:
+		value := cfg.Get(args[0])
+		if value == "" {
+			return fmt.Errorf("key not set: %s", args[0])
+		}
+		fmt.Println(value)
+		return nil
+	case len(args) == 2:
+		cfg.Set(args[0], args[1])
+		return cfg.Write()
+	}
+	return fmt.Errorf("usage: gitre config <key> [<value>] | --unset <key>")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gitre/gitre"
+)
+
+// diff [--cached] [--name-status] [-M[<n>]|--no-renames] [-C[<n>]] [<rev> [<rev>]] [-- <paths>]
+func diff(args []string) error {
+	cached, nameStatus := false, false
+	opts := repo.DefaultRenameOptions()
+	var revs, paths []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--cached" || arg == "--staged":
+			cached = true
+		case arg == "--name-status":
+			nameStatus = true
+		case arg == "--no-renames":
+			opts.Renames, opts.Copies = false, false
+		case strings.HasPrefix(arg, "-M") || strings.HasPrefix(arg, "--find-renames"):
+			opts.Renames = true
+			if err := parseThreshold(arg, &opts); err != nil {
+				return err
+			}
+		case strings.HasPrefix(arg, "-C") || strings.HasPrefix(arg, "--find-copies"):
+			opts.Renames, opts.Copies = true, true
+			if err := parseThreshold(arg, &opts); err != nil {
+				return err
+			}
+		case arg == "--":
+			paths = append(paths, userPaths(args[i+1:])...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option for diff: %s", arg)
+		default:
+			revs = append(revs, arg)
+		}
+	}
+
+	oldEntries, newEntries, err := diffSides(cached, revs, &opts)
+	if err != nil {
+		return err
+	}
+	if len(paths) > 0 {
+		gitre.FilterEntries(oldEntries, paths)
+		gitre.FilterEntries(newEntries, paths)
+	}
+
+	for _, c := range repo.DiffTrees(oldEntries, newEntries, opts) {
+		if nameStatus {
+			printNameStatus(c)
+			continue
+		}
+		if err := printPatch(c, opts.NewFromDisk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// picks the two sides to compare: index and working tree, a commit and the index (--cached),
+// a commit and the working tree, or two commits
+func diffSides(cached bool, revs []string, opts *gitre.RenameOptions) (map[string]gitre.IndexEntry, map[string]gitre.IndexEntry, error) {
+	treeOf := func(rev string) (map[string]gitre.IndexEntry, error) {
+		hash, err := repo.ResolveRev(rev)
+		if err != nil {
+			if rev == "HEAD" {
+				return map[string]gitre.IndexEntry{}, nil
+			}
+			return nil, err
+		}
+		return repo.ReadCommitTree(hash)
+	}
+
+	if len(revs) > 2 || len(revs) == 2 && cached {
+		return nil, nil, fmt.Errorf("too many revisions given to diff")
+	}
+	if len(revs) == 2 {
+		oldEntries, err := treeOf(revs[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		newEntries, err := treeOf(revs[1])
+		return oldEntries, newEntries, err
+	}
+
+	idx, err := repo.ReadIndex()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load index: %w", err)
+	}
+	indexEntries := map[string]gitre.IndexEntry{}
+	for _, e := range idx.Entries {
+		indexEntries[e.Path] = e
+	}
+
+	if cached {
+		rev := "HEAD"
+		if len(revs) == 1 {
+			rev = revs[0]
+		}
+		oldEntries, err := treeOf(rev)
+		return oldEntries, indexEntries, err
+	}
+
+	worktree, err := repo.WorktreeEntries(idx)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts.NewFromDisk = true
+	if len(revs) == 1 {
+		oldEntries, err := treeOf(revs[0])
+		return oldEntries, worktree, err
+	}
+	return indexEntries, worktree, nil
+}
+
+func parseThreshold(arg string, opts *gitre.RenameOptions) error {
+	value := strings.TrimLeft(arg, "-MC")
+	if i := strings.Index(arg, "="); i >= 0 {
+		value = arg[i+1:]
+	} else if strings.HasPrefix(arg, "--") {
+		value = ""
+	}
+	if value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+	if err != nil || n < 0 || n > 100 {
+		return fmt.Errorf("invalid similarity threshold: %s", arg)
+	}
+	opts.Threshold = n
+	return nil
+}
+
+func printNameStatus(c gitre.FileChange) {
+	switch c.Status {
+	case "R", "C":
+		fmt.Printf("%s%03d\t%s\t%s\n", c.Status, c.Score, c.OldPath, c.NewPath)
+	default:
+		fmt.Printf("%s\t%s\n", c.Status, c.NewPath)
+	}
+}
+
+func printPatch(c gitre.FileChange, newFromDisk bool) error {
+	fmt.Printf("diff --gitre a/%s b/%s\n", c.OldPath, c.NewPath)
+	switch c.Status {
+	case "A":
+		fmt.Printf("new file mode %s\n", gitre.FormatMode(c.New.Mode))
+	case "D":
+		fmt.Printf("deleted file mode %s\n", gitre.FormatMode(c.Old.Mode))
+	case "R", "C":
+		verb := map[string]string{"R": "rename", "C": "copy"}[c.Status]
+		fmt.Printf("similarity index %d%%\n%s from %s\n%s to %s\n", c.Score, verb, c.OldPath, verb, c.NewPath)
+	}
+	if c.Status == "M" && gitre.NormalizeMode(c.Old.Mode) != gitre.NormalizeMode(c.New.Mode) {
+		fmt.Printf("old mode %s\nnew mode %s\n", gitre.FormatMode(c.Old.Mode), gitre.FormatMode(c.New.Mode))
+	}
+	if c.Old.Hash == c.New.Hash {
+		return nil
+	}
+
+	var oldData, newData []byte
+	var err error
+	if c.Status != "A" {
+		if oldData, err = repo.EntryContent(c.Old, false); err != nil {
+			return err
+		}
+	}
+	if c.Status != "D" {
+		if newData, err = repo.EntryContent(c.New, newFromDisk); err != nil {
+			return err
+		}
+	}
+	if bytes.IndexByte(oldData, 0) >= 0 || bytes.IndexByte(newData, 0) >= 0 {
+		fmt.Printf("Binary files differ\n")
+		return nil
+	}
+
+	oldName, newName := "a/"+c.OldPath, "b/"+c.NewPath
+	if c.Status == "A" {
+		oldName = "/dev/null"
+	}
+	if c.Status == "D" {
+		newName = "/dev/null"
+	}
+	fmt.Printf("--- %s\n+++ %s\n", oldName, newName)
+	fmt.Print(gitre.UnifiedDiff(gitre.SplitLines(oldData), gitre.SplitLines(newData), 3))
+	return nil
+}
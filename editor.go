@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// editor command from $GITRE_EDITOR, core.editor or $EDITOR, falling back to vi
+func editorCommand() string {
+	if editor := os.Getenv("GITRE_EDITOR"); editor != "" {
+		return editor
+	}
+	if cfg, err := repo.ReadConfig(); err == nil {
+		if editor := cfg.Get("core.editor"); editor != "" {
+			return editor
+		}
+	}
+	if editor := os.Getenv("EDITOR"); editor != "" {
+		return editor
+	}
+	return "vi"
+}
+
+// opens file in the user's editor and waits for it to exit; the editor may carry arguments
+func runEditor(file string) error {
+	editor := editorCommand()
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, file)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor '%s' failed: %w", editor, err)
+	}
+	return nil
+}
+
+// lets the user edit text in file, returning the result without comment lines
+func editText(file string, text string) (string, error) {
+	if err := os.WriteFile(file, []byte(text), 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	if err := runEditor(file); err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	return cleanMessage(string(data), true), nil
+}
+
+// drops trailing whitespace and surrounding blank lines, ending the text with a newline; comment lines are
+// dropped too for text that went through an editor template
+func cleanMessage(text string, stripComments bool) string {
+	var lines []string
+	for line := range strings.SplitSeq(text, "\n") {
+		if stripComments && strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, strings.TrimRight(line, " \t\r"))
+	}
+	cleaned := strings.Trim(strings.Join(lines, "\n"), "\n")
+	if cleaned == "" {
+		return ""
+	}
+	return cleaned + "\n"
+}
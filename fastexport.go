@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+
+	"gitre/gitre"
+)
+
+// fast-export --all | fast-export <ref>..., writes the history as a git fast-import stream to stdout
+func fastExport(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: gitre fast-export --all | gitre fast-export <ref>...")
+	}
+	refs := map[string]string{}
+	for _, arg := range args {
+		switch {
+		case arg == "--all":
+			all, err := branchesAndTags()
+			if err != nil {
+				return err
+			}
+			maps.Copy(refs, all)
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option for fast-export: %s", arg)
+		default:
+			ref, hash, err := namedRef(arg)
+			if err != nil {
+				return err
+			}
+			refs[ref] = hash
+		}
+	}
+	ident, err := gitIdent()
+	if err != nil {
+		return err
+	}
+	return repo.FastExport(os.Stdout, refs, ident)
+}
+
+// fast-import [--force], reads a git fast-import stream from stdin; refs only move forward unless forced
+func fastImport(args []string) error {
+	force := false
+	for _, arg := range args {
+		switch arg {
+		case "-f", "--force":
+			force = true
+		default:
+			return fmt.Errorf("unknown option for fast-import: %s", arg)
+		}
+	}
+	result, err := repo.FastImport(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	current, headHash, err := repo.ReadHead()
+	if err != nil {
+		return err
+	}
+	var rejected []string
+	for _, name := range gitre.SortedRefs(result.Refs) {
+		hash := result.Refs[name]
+		old, err := repo.ReadRef(name)
+		if err != nil {
+			return err
+		}
+		if hash == "" || hash == old {
+			continue
+		}
+		if fastForward, err := repo.IsAncestor(old, hash); err != nil {
+			return err
+		} else if !fastForward && !force {
+			rejected = append(rejected, name)
+			continue
+		}
+		// the checked out branch takes the working tree along
+		if name == current && !repo.IsBare() {
+			if err := moveWorkTree(headHash, hash, false); err != nil {
+				return err
+			}
+		}
+		if err := repo.UpdateRef(name, hash); err != nil {
+			return fmt.Errorf("failed to update %s: %w", name, err)
+		}
+		fmt.Printf(" * %s -> %s\n", name, shortHash(hash))
+	}
+	fmt.Printf("imported %d blobs and %d commits\n", result.Blobs, result.Commits)
+	if len(rejected) > 0 {
+		return fmt.Errorf("not updating %s, the new commits do not contain the old ones (use --force)", strings.Join(rejected, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package gitre
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// bundle file, a ref advertisement followed by a pack:
+//
+//	"# gitre bundle v1\n"
+//	"-<hash>\n" per prerequisite commit the receiving repository must already have
+//	"HEAD <ref>\n" when the bundle has a default branch
+//	"<hash> <ref>\n" per ref
+//	"\n" and the pack stream
+const bundleSignature = "# gitre bundle v1"
+
+type Bundle struct {
+	Path          string
+	Refs          map[string]string
+	Head          string
+	Prerequisites []string
+}
+
+// WriteBundle writes refs and every object they reach to w, leaving out what the prerequisites already reach
+func (r *Repository) WriteBundle(w io.Writer, refs map[string]string, head string, prerequisites []string) error {
+	var wants []string
+	for _, name := range SortedRefs(refs) {
+		wants = append(wants, refs[name])
+	}
+	objects, err := r.MissingObjects(wants, prerequisites)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, bundleSignature)
+	for _, hash := range prerequisites {
+		fmt.Fprintf(bw, "-%s\n", hash)
+	}
+	if head != "" {
+		fmt.Fprintf(bw, "HEAD %s\n", head)
+	}
+	for _, name := range SortedRefs(refs) {
+		fmt.Fprintf(bw, "%s %s\n", refs[name], name)
+	}
+	fmt.Fprintln(bw)
+	if err := r.WritePack(bw, objects); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// IsBundle reports whether path is a bundle file
+func IsBundle(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	line, _ := bufio.NewReader(f).ReadString('\n')
+	return strings.TrimSuffix(line, "\n") == bundleSignature
+}
+
+// OpenBundle reads the refs and prerequisites of a bundle file
+func OpenBundle(path string) (*Bundle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer f.Close()
+	return readBundleHeader(path, bufio.NewReader(f))
+}
+
+func readBundleHeader(path string, br *bufio.Reader) (*Bundle, error) {
+	if line, _ := br.ReadString('\n'); strings.TrimSuffix(line, "\n") != bundleSignature {
+		return nil, fmt.Errorf("'%s' is not a gitre bundle", path)
+	}
+	b := &Bundle{Path: path, Refs: map[string]string{}}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle %s: truncated header", path)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if err := CheckRemoteRefs(b.Refs, b.Head); err != nil {
+				return nil, fmt.Errorf("invalid bundle %s: %w", path, err)
+			}
+			return b, nil
+		}
+		if hash, ok := strings.CutPrefix(line, "-"); ok {
+			if !IsObjectHash(hash) {
+				return nil, fmt.Errorf("invalid bundle %s: bad prerequisite %q", path, hash)
+			}
+			b.Prerequisites = append(b.Prerequisites, hash)
+			continue
+		}
+		first, second, ok := strings.Cut(line, " ")
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("invalid bundle %s: bad header line %q", path, line)
+		case first == "HEAD":
+			b.Head = second
+		default:
+			b.Refs[second] = first
+		}
+	}
+}
+
+// MissingPrerequisites lists the prerequisite commits r does not have
+func (b *Bundle) MissingPrerequisites(r *Repository) []string {
+	var missing []string
+	for _, hash := range b.Prerequisites {
+		if !r.Objects.Has(hash) {
+			missing = append(missing, hash)
+		}
+	}
+	return missing
+}
+
+// Unbundle stores the objects of the bundle in r once its prerequisites are there, returns how many it held
+func (b *Bundle) Unbundle(r *Repository) (int, error) {
+	if missing := b.MissingPrerequisites(r); len(missing) > 0 {
+		return 0, fmt.Errorf("repository lacks these prerequisite commits:\n\t%s", strings.Join(missing, "\n\t"))
+	}
+	f, err := os.Open(b.Path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	if _, err := readBundleHeader(b.Path, br); err != nil {
+		return 0, err
+	}
+	return ReadPack(br, r.Objects)
+}
+
+// BundleTransport reads from a bundle file as if it were a remote
+type BundleTransport struct {
+	Bundle *Bundle
+}
+
+func (t *BundleTransport) Refs() (map[string]string, string, error) {
+	return t.Bundle.Refs, t.Bundle.Head, nil
+}
+
+func (t *BundleTransport) Fetch(dst *Repository, wants []string) (int, error) {
+	for _, want := range wants {
+		if !dst.Objects.Has(want) {
+			return t.Bundle.Unbundle(dst)
+		}
+	}
+	return 0, nil
+}
+
+func (t *BundleTransport) Push(src *Repository, updates []RefUpdate) error {
+	return fmt.Errorf("cannot push to bundle %s", t.Bundle.Path)
+}
@@ -0,0 +1,40 @@
+package gitre
+
+import "fmt"
+
+// writes the tree of the index, returns its hash and the entries it was built from
+func (r *Repository) WriteIndexTree() (string, []IndexEntry, error) {
+	entries, err := r.LoadIndex()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to load index: %w", err)
+	}
+	tree, err := r.WriteTree(BuildTree(entries))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to write tree objects: %w", err)
+	}
+	return tree, entries, nil
+}
+
+// commits the index on top of HEAD and moves HEAD to the new commit
+func (r *Repository) Commit(message string) (string, error) {
+	tree, _, err := r.WriteIndexTree()
+	if err != nil {
+		return "", err
+	}
+	_, headHash, err := r.ReadHead()
+	if err != nil {
+		return "", err
+	}
+	var parents []string
+	if headHash != "" {
+		parents = []string{headHash}
+	}
+	commitHash, err := r.WriteCommit(tree, parents, message)
+	if err != nil {
+		return "", err
+	}
+	if err := r.UpdateHead(commitHash); err != nil {
+		return "", fmt.Errorf("failed to update ref: %w", err)
+	}
+	return commitHash, nil
+}
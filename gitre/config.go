@@ -0,0 +1,168 @@
+package gitre
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+type ConfigSection struct {
+	Name       string
+	Subsection string
+	Keys       []string
+	Values     map[string]string
+}
+
+// .gitre/config in the ini style of git: [section] or [section "subsection"] followed by key = value lines
+type Config struct {
+	Sections []*ConfigSection
+	path     string
+}
+
+func (r *Repository) ReadConfig() (*Config, error) {
+	cfg := &Config{path: r.Path("config")}
+	data, err := os.ReadFile(cfg.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var current *ConfigSection
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			header, ok := strings.CutSuffix(line, "]")
+			if !ok {
+				return nil, fmt.Errorf("invalid config line %d: %s", i+1, line)
+			}
+			name, sub, _ := strings.Cut(header[1:], " ")
+			current = cfg.section(strings.ToLower(name), strings.Trim(sub, `"`), true)
+			continue
+		}
+		if current == nil {
+			return nil, fmt.Errorf("config line %d is outside of a section: %s", i+1, line)
+		}
+		key, value, _ := strings.Cut(line, "=")
+		current.set(strings.ToLower(strings.TrimSpace(key)), strings.Trim(strings.TrimSpace(value), `"`))
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) Write() error {
+	var b strings.Builder
+	for _, s := range cfg.Sections {
+		if len(s.Keys) == 0 {
+			continue
+		}
+		if s.Subsection != "" {
+			fmt.Fprintf(&b, "[%s \"%s\"]\n", s.Name, s.Subsection)
+		} else {
+			fmt.Fprintf(&b, "[%s]\n", s.Name)
+		}
+		for _, k := range s.Keys {
+			fmt.Fprintf(&b, "\t%s = %s\n", k, s.Values[k])
+		}
+	}
+	return os.WriteFile(cfg.path, []byte(b.String()), 0644)
+}
+
+// value of section.key or section.subsection.key, empty when unset
+func (cfg *Config) Get(key string) string {
+	name, sub, k := splitConfigKey(key)
+	if s := cfg.section(name, sub, false); s != nil {
+		return s.Values[k]
+	}
+	return ""
+}
+
+func (cfg *Config) Set(key string, value string) {
+	name, sub, k := splitConfigKey(key)
+	cfg.section(name, sub, true).set(k, value)
+}
+
+func (cfg *Config) Unset(key string) {
+	name, sub, k := splitConfigKey(key)
+	s := cfg.section(name, sub, false)
+	if s == nil {
+		return
+	}
+	delete(s.Values, k)
+	for i, existing := range s.Keys {
+		if existing == k {
+			s.Keys = append(s.Keys[:i], s.Keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// subsections of a section in file order, the names of all remotes for "remote"
+func (cfg *Config) Subsections(name string) []string {
+	var subs []string
+	for _, s := range cfg.Sections {
+		if s.Name == name && s.Subsection != "" && len(s.Keys) > 0 {
+			subs = append(subs, s.Subsection)
+		}
+	}
+	return subs
+}
+
+// drops a whole section, reports whether it existed
+func (cfg *Config) RemoveSection(name string, sub string) bool {
+	for i, s := range cfg.Sections {
+		if s.Name == name && s.Subsection == sub {
+			cfg.Sections = append(cfg.Sections[:i], cfg.Sections[i+1:]...)
+			return len(s.Keys) > 0
+		}
+	}
+	return false
+}
+
+// renames the subsection of a section, reports whether it existed
+func (cfg *Config) RenameSection(name string, sub string, newSub string) bool {
+	s := cfg.section(name, sub, false)
+	if s == nil {
+		return false
+	}
+	s.Subsection = newSub
+	return true
+}
+
+func (cfg *Config) section(name string, sub string, create bool) *ConfigSection {
+	for _, s := range cfg.Sections {
+		if s.Name == name && s.Subsection == sub {
+			return s
+		}
+	}
+	if !create {
+		return nil
+	}
+	s := &ConfigSection{Name: name, Subsection: sub, Values: map[string]string{}}
+	cfg.Sections = append(cfg.Sections, s)
+	return s
+}
+
+func (s *ConfigSection) set(key string, value string) {
+	if _, ok := s.Values[key]; !ok {
+		s.Keys = append(s.Keys, key)
+	}
+	s.Values[key] = value
+}
+
+// splits section.key and section.sub.section.key, the subsection may contain dots
+func splitConfigKey(key string) (string, string, string) {
+	first := strings.Index(key, ".")
+	last := strings.LastIndex(key, ".")
+	if first < 0 {
+		return "", "", strings.ToLower(key)
+	}
+	name := strings.ToLower(key[:first])
+	if first == last {
+		return name, "", strings.ToLower(key[last+1:])
+	}
+	return name, key[first+1 : last], strings.ToLower(key[last+1:])
+}
@@ -0,0 +1,182 @@
+package gitre
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tracked files as they are on disk, hashed without storing them
+func (r *Repository) WorktreeEntries(idx *Index) (map[string]IndexEntry, error) {
+	racyTime := r.IndexMtime()
+	entries := map[string]IndexEntry{}
+	for _, e := range idx.Entries {
+		changed, _, err := r.CompareWorktree(e, racyTime)
+		if err != nil {
+			continue
+		}
+		if changed {
+			data, info, err := r.ReadWorktreeFile(e.Path)
+			if err != nil {
+				return nil, err
+			}
+			hash, _ := HashObject(data, "blob")
+			e = EntryFromInfo(e.Path, hash, info)
+		}
+		entries[e.Path] = e
+	}
+	return entries, nil
+}
+
+func FilterEntries(entries map[string]IndexEntry, paths []string) {
+	for path := range entries {
+		if !MatchesAnyPath(path, paths) {
+			delete(entries, path)
+		}
+	}
+}
+
+func SplitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+type DiffOp struct {
+	Kind byte // ' ', '-' or '+'
+	Line string
+}
+
+// shortest edit script between a and b (Myers); the trace keeps for each step d only the diagonals -d..d it
+// can reach, so it grows with the square of the edit distance rather than with the inputs
+func DiffLines(a []string, b []string) []DiffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	v := make([]int, 2*max+2)
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		done := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[max+k-1] < v[max+k+1] {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[max+k] = x
+			if x >= n && y >= m {
+				done = true
+				break
+			}
+		}
+		// trace[d][k+d] is the furthest x on diagonal k after step d
+		trace = append(trace, append([]int(nil), v[max-d:max+d+1]...))
+		if done {
+			break
+		}
+	}
+
+	var ops []DiffOp
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev, off := trace[d-1], d-1
+		k := x - y
+		var prevK int
+		if k == -d || k != d && prev[off+k-1] < prev[off+k+1] {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := prev[off+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, DiffOp{' ', a[x]})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, DiffOp{'+', b[y]})
+		} else {
+			x--
+			ops = append(ops, DiffOp{'-', a[x]})
+		}
+	}
+	// the lines both start with
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, DiffOp{' ', a[x]})
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// renders the edit script of a and b as unified diff hunks with the given context
+func UnifiedDiff(a []string, b []string, context int) string {
+	ops := DiffLines(a, b)
+	var out strings.Builder
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == ' ' {
+			i++
+			continue
+		}
+		start := max(i-context, 0)
+		end := i
+		// extend the hunk while the next change is within reach of the context
+		for j := i; j < len(ops); j++ {
+			if ops[j].Kind != ' ' {
+				end = j
+			} else if j-end > 2*context {
+				break
+			}
+		}
+		end = min(end+context+1, len(ops))
+
+		oldStart, newStart := 1, 1
+		for _, op := range ops[:start] {
+			if op.Kind != '+' {
+				oldStart++
+			}
+			if op.Kind != '-' {
+				newStart++
+			}
+		}
+		oldCount, newCount := 0, 0
+		var body strings.Builder
+		for _, op := range ops[start:end] {
+			if op.Kind != '+' {
+				oldCount++
+			}
+			if op.Kind != '-' {
+				newCount++
+			}
+			body.WriteByte(op.Kind)
+			body.WriteString(op.Line)
+			if !strings.HasSuffix(op.Line, "\n") {
+				body.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		out.WriteString(body.String())
+		i = end
+	}
+	return out.String()
+}
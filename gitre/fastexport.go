@@ -0,0 +1,186 @@
+package gitre
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// FastExport writes the history of refs as a git fast-import stream, every commit once with the blobs it adds;
+// commits without an author get ident
+func (r *Repository) FastExport(w io.Writer, refs map[string]string, ident string) error {
+	bw := bufio.NewWriter(w)
+	marks := map[string]int{}
+	mark := func(hash string) int {
+		marks[hash] = len(marks) + 1
+		return marks[hash]
+	}
+
+	// branches go first so a tag on their history is a reset to a commit already written; gitre has no tag
+	// objects, so tags are exported as the lightweight tags they are
+	names := SortedRefs(refs)
+	sort.SliceStable(names, func(i, j int) bool {
+		return !strings.HasPrefix(names[i], "refs/tags/") && strings.HasPrefix(names[j], "refs/tags/")
+	})
+	for _, name := range names {
+		tip := refs[name]
+		// a ref whose commit went out under another ref only needs to be pointed at it
+		if n, ok := marks[tip]; ok {
+			fmt.Fprintf(bw, "reset %s\nfrom :%d\n\n", name, n)
+			continue
+		}
+		commits, err := r.topoCommits(tip, marks)
+		if err != nil {
+			return err
+		}
+		for _, hash := range commits {
+			c, err := r.ReadCommit(hash)
+			if err != nil {
+				return err
+			}
+			parentTree := map[string]IndexEntry{}
+			if len(c.Parents) > 0 {
+				if parentTree, err = r.ReadCommitTree(c.Parents[0]); err != nil {
+					return err
+				}
+			}
+			tree, err := r.ReadTree(c.Tree)
+			if err != nil {
+				return err
+			}
+
+			var deleted, modified []string
+			for path := range parentTree {
+				if _, ok := tree[path]; !ok {
+					deleted = append(deleted, path)
+				}
+			}
+			for path, e := range tree {
+				if p, ok := parentTree[path]; !ok || p.Hash != e.Hash || NormalizeMode(p.Mode) != NormalizeMode(e.Mode) {
+					modified = append(modified, path)
+				}
+			}
+			sort.Strings(deleted)
+			sort.Strings(modified)
+
+			for _, path := range modified {
+				blob := tree[path].Hash
+				if _, ok := marks[blob]; ok {
+					continue
+				}
+				data, err := r.ExtractObject([]byte(blob))
+				if err != nil {
+					return fmt.Errorf("error extracting blob %s: %w", blob, err)
+				}
+				fmt.Fprintf(bw, "blob\nmark :%d\ndata %d\n", mark(blob), len(data))
+				bw.Write(data)
+				bw.WriteString("\n")
+			}
+
+			if len(c.Parents) == 0 {
+				fmt.Fprintf(bw, "reset %s\n", name)
+			}
+			author, committer := c.Author, c.Committer
+			if author == "" {
+				author = ident
+			}
+			if committer == "" {
+				committer = author
+			}
+			fmt.Fprintf(bw, "commit %s\nmark :%d\nauthor %s\ncommitter %s\ndata %d\n%s\n",
+				name, mark(hash), author, committer, len(c.Message), c.Message)
+			for i, p := range c.Parents {
+				command := "merge"
+				if i == 0 {
+					command = "from"
+				}
+				fmt.Fprintf(bw, "%s :%d\n", command, marks[p])
+			}
+			// deletions first so a file can be replaced by a directory of the same name
+			for _, path := range deleted {
+				fmt.Fprintf(bw, "D %s\n", quoteFastPath(path))
+			}
+			for _, path := range modified {
+				e := tree[path]
+				fmt.Fprintf(bw, "M %s :%d %s\n", FormatMode(e.Mode), marks[e.Hash], quoteFastPath(path))
+			}
+			bw.WriteString("\n")
+		}
+	}
+	return bw.Flush()
+}
+
+// commits reachable from tip that are not marked yet, parents before children
+func (r *Repository) topoCommits(tip string, marks map[string]int) ([]string, error) {
+	var order []string
+	expanded := map[string]bool{}
+	added := map[string]bool{}
+	stack := []string{tip}
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		if _, ok := marks[hash]; ok || added[hash] {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if !expanded[hash] {
+			expanded[hash] = true
+			c, err := r.ReadCommit(hash)
+			if err != nil {
+				return nil, err
+			}
+			for i := len(c.Parents) - 1; i >= 0; i-- {
+				stack = append(stack, c.Parents[i])
+			}
+			continue
+		}
+		stack = stack[:len(stack)-1]
+		added[hash] = true
+		order = append(order, hash)
+	}
+	return order, nil
+}
+
+// paths are quoted C style, as git does, when they hold a quote, a backslash, control characters or non-ASCII
+// bytes; bytes without a short escape are written as three octal digits
+func quoteFastPath(path string) string {
+	quote := strings.HasPrefix(path, `"`)
+	for i := 0; i < len(path) && !quote; i++ {
+		quote = path[i] < 0x20 || path[i] >= 0x7f || path[i] == '"' || path[i] == '\\'
+	}
+	if !quote {
+		return path
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\v':
+			b.WriteString(`\v`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				fmt.Fprintf(&b, "\\%03o", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
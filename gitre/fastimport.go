@@ -0,0 +1,492 @@
+package gitre
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// FastImportResult is what a fast-import stream created; refs are left for the caller to write
+type FastImportResult struct {
+	// final commit of every ref the stream set, empty for a ref reset without a commit
+	Refs    map[string]string
+	Blobs   int
+	Commits int
+}
+
+type fastImporter struct {
+	r       *Repository
+	br      *bufio.Reader
+	pending *string
+	lineNo  int
+	marks   map[string]string
+	result  *FastImportResult
+}
+
+// FastImport reads a git fast-import stream of blob, commit, tag and reset commands and stores its objects;
+// annotated tags become plain tags as gitre has no tag objects
+func (r *Repository) FastImport(rd io.Reader) (*FastImportResult, error) {
+	fi := &fastImporter{
+		r:      r,
+		br:     bufio.NewReader(rd),
+		marks:  map[string]string{},
+		result: &FastImportResult{Refs: map[string]string{}},
+	}
+	for {
+		line, ok, err := fi.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || line == "done" {
+			return fi.result, nil
+		}
+		command, arg, _ := strings.Cut(line, " ")
+		switch command {
+		case "commit", "reset":
+			err = checkFastRef(arg)
+		case "tag":
+			err = checkFastRef("refs/tags/" + arg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("fast-import line %d: %w", fi.lineNo, err)
+		}
+		switch command {
+		case "blob":
+			err = fi.blob()
+		case "commit":
+			err = fi.commit(arg)
+		case "tag":
+			err = fi.tag(arg)
+		case "reset":
+			err = fi.reset(arg)
+		case "", "feature", "option", "progress", "checkpoint":
+		default:
+			err = fmt.Errorf("unsupported command %q", line)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("fast-import line %d: %w", fi.lineNo, err)
+		}
+	}
+}
+
+// next line without its newline, comments skipped; ok is false at the end of the stream
+func (fi *fastImporter) next() (string, bool, error) {
+	if fi.pending != nil {
+		line := *fi.pending
+		fi.pending = nil
+		return line, true, nil
+	}
+	for {
+		line, err := fi.br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return "", false, nil
+		}
+		if err != nil && err != io.EOF {
+			return "", false, err
+		}
+		fi.lineNo++
+		line = strings.TrimSuffix(line, "\n")
+		if !strings.HasPrefix(line, "#") {
+			return line, true, nil
+		}
+	}
+}
+
+// consumes the next line when it is the given command, returning its argument
+func (fi *fastImporter) optional(command string) (string, bool, error) {
+	line, ok, err := fi.next()
+	if err != nil || !ok {
+		return "", false, err
+	}
+	if arg, found := strings.CutPrefix(line, command+" "); found {
+		return arg, true, nil
+	}
+	fi.pending = &line
+	return "", false, nil
+}
+
+// "data <count>" followed by exactly that many bytes, or "data <<<delimiter>" followed by lines up to the delimiter
+func (fi *fastImporter) data() ([]byte, error) {
+	line, _, err := fi.next()
+	if err != nil {
+		return nil, err
+	}
+	arg, ok := strings.CutPrefix(line, "data ")
+	if !ok {
+		return nil, fmt.Errorf("expected data, got %q", line)
+	}
+	if delimiter, ok := strings.CutPrefix(arg, "<<"); ok {
+		var b strings.Builder
+		for {
+			line, err := fi.br.ReadString('\n')
+			fi.lineNo++
+			if strings.TrimSuffix(line, "\n") == delimiter {
+				return []byte(b.String()), nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("data is missing its delimiter %s", delimiter)
+			}
+			b.WriteString(line)
+		}
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 0 || n > maxPackObjectSize {
+		return nil, fmt.Errorf("invalid data length %q", arg)
+	}
+	// the buffer grows with the data actually read rather than the announced length
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, fi.br, int64(n)); err != nil {
+		return nil, fmt.Errorf("data is shorter than %d bytes", n)
+	}
+	data := buf.Bytes()
+	fi.lineNo += bytes.Count(data, []byte("\n"))
+	// the newline after the data is optional
+	if b, err := fi.br.Peek(1); err == nil && b[0] == '\n' {
+		fi.br.ReadByte()
+		fi.lineNo++
+	}
+	return data, nil
+}
+
+// optional mark and original-oid lines that may open a command
+func (fi *fastImporter) header() (string, error) {
+	mark, _, err := fi.optional("mark")
+	if err != nil {
+		return "", err
+	}
+	if _, _, err := fi.optional("original-oid"); err != nil {
+		return "", err
+	}
+	return mark, nil
+}
+
+func (fi *fastImporter) blob() error {
+	mark, err := fi.header()
+	if err != nil {
+		return err
+	}
+	data, err := fi.data()
+	if err != nil {
+		return err
+	}
+	hash, err := fi.r.HashStore(data, "blob")
+	if err != nil {
+		return err
+	}
+	if mark != "" {
+		fi.marks[mark] = hash
+	}
+	fi.result.Blobs++
+	return nil
+}
+
+// a commit given as :<mark>, a ref set earlier in the stream or any revision of the repository
+func (fi *fastImporter) resolve(commitish string) (string, error) {
+	if strings.HasPrefix(commitish, ":") {
+		hash, ok := fi.marks[commitish]
+		if !ok {
+			return "", fmt.Errorf("mark %s is not defined", commitish)
+		}
+		return hash, nil
+	}
+	for _, name := range []string{commitish, "refs/heads/" + commitish, "refs/tags/" + commitish} {
+		if hash, ok := fi.result.Refs[name]; ok && hash != "" {
+			return hash, nil
+		}
+	}
+	return fi.r.ResolveRev(commitish)
+}
+
+// current commit of a ref, as left by the stream or else in the repository
+func (fi *fastImporter) refTip(name string) (string, error) {
+	if hash, ok := fi.result.Refs[name]; ok {
+		return hash, nil
+	}
+	return fi.r.ReadRef(name)
+}
+
+func (fi *fastImporter) commit(ref string) error {
+	mark, err := fi.header()
+	if err != nil {
+		return err
+	}
+	c := &Commit{}
+	if c.Author, _, err = fi.optional("author"); err != nil {
+		return err
+	}
+	var ok bool
+	if c.Committer, ok, err = fi.optional("committer"); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("commit to %s has no committer", ref)
+	}
+	if c.Author == "" {
+		c.Author = c.Committer
+	}
+	if _, _, err := fi.optional("encoding"); err != nil {
+		return err
+	}
+	message, err := fi.data()
+	if err != nil {
+		return err
+	}
+	c.Message = string(message)
+
+	parent, hasFrom, err := fi.optional("from")
+	if err != nil {
+		return err
+	}
+	if hasFrom {
+		if parent, err = fi.resolve(parent); err != nil {
+			return err
+		}
+	} else if parent, err = fi.refTip(ref); err != nil {
+		return err
+	}
+	if parent != "" {
+		c.Parents = append(c.Parents, parent)
+	}
+	for {
+		merge, ok, err := fi.optional("merge")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		hash, err := fi.resolve(merge)
+		if err != nil {
+			return err
+		}
+		c.Parents = append(c.Parents, hash)
+	}
+
+	files, err := fi.r.ReadCommitTree(parent)
+	if err != nil {
+		return err
+	}
+	if err := fi.fileChanges(files); err != nil {
+		return err
+	}
+	var entries []IndexEntry
+	for _, e := range files {
+		entries = append(entries, e)
+	}
+	if c.Tree, err = fi.r.WriteTree(BuildTree(entries)); err != nil {
+		return fmt.Errorf("failed to write tree objects: %w", err)
+	}
+	hash, err := fi.r.WriteCommitObject(c)
+	if err != nil {
+		return err
+	}
+	if mark != "" {
+		fi.marks[mark] = hash
+	}
+	fi.result.Refs[ref] = hash
+	fi.result.Commits++
+	return nil
+}
+
+// M, D, C, R and deleteall lines up to the end of the commit
+func (fi *fastImporter) fileChanges(files map[string]IndexEntry) error {
+	for {
+		line, ok, err := fi.next()
+		if err != nil {
+			return err
+		}
+		if !ok || line == "" {
+			return nil
+		}
+		command, arg, _ := strings.Cut(line, " ")
+		switch command {
+		case "M":
+			fields := strings.SplitN(arg, " ", 3)
+			if len(fields) != 3 {
+				return fmt.Errorf("invalid file change %q", line)
+			}
+			mode, err := fastImportMode(fields[0])
+			if err != nil {
+				return err
+			}
+			path, _, err := unquoteFastPath(fields[2], true)
+			if err != nil {
+				return err
+			}
+			hash, err := fi.blobRef(fields[1])
+			if err != nil {
+				return err
+			}
+			placeFastPath(files, IndexEntry{Path: path, Hash: hash, Mode: mode})
+		case "D":
+			path, _, err := unquoteFastPath(arg, true)
+			if err != nil {
+				return err
+			}
+			removeFastPath(files, path)
+		case "C", "R":
+			src, rest, err := unquoteFastPath(arg, false)
+			if err != nil {
+				return err
+			}
+			dst, _, err := unquoteFastPath(strings.TrimPrefix(rest, " "), true)
+			if err != nil {
+				return err
+			}
+			copied := map[string]IndexEntry{}
+			for path, e := range files {
+				if path == src || strings.HasPrefix(path, src+"/") {
+					e.Path = dst + strings.TrimPrefix(path, src)
+					copied[e.Path] = e
+				}
+			}
+			if len(copied) == 0 {
+				return fmt.Errorf("path %s not in the tree", src)
+			}
+			if command == "R" {
+				removeFastPath(files, src)
+			}
+			removeFastPath(files, dst)
+			for _, e := range copied {
+				placeFastPath(files, e)
+			}
+		case "deleteall":
+			clear(files)
+		default:
+			// the next command follows a commit without an empty line
+			fi.pending = &line
+			return nil
+		}
+	}
+}
+
+// blob of an M line: a mark, a blob hash, or inline with the data following the line
+func (fi *fastImporter) blobRef(dataref string) (string, error) {
+	switch {
+	case dataref == "inline":
+		data, err := fi.data()
+		if err != nil {
+			return "", err
+		}
+		fi.result.Blobs++
+		return fi.r.HashStore(data, "blob")
+	case strings.HasPrefix(dataref, ":"):
+		return fi.resolve(dataref)
+	case fi.r.Objects.Has(dataref):
+		return dataref, nil
+	}
+	return "", fmt.Errorf("blob %s not found", dataref)
+}
+
+func (fi *fastImporter) tag(name string) error {
+	if _, err := fi.header(); err != nil {
+		return err
+	}
+	from, ok, err := fi.optional("from")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("tag %s has no from", name)
+	}
+	hash, err := fi.resolve(from)
+	if err != nil {
+		return err
+	}
+	if _, _, err := fi.optional("original-oid"); err != nil {
+		return err
+	}
+	if _, _, err := fi.optional("tagger"); err != nil {
+		return err
+	}
+	if _, err := fi.data(); err != nil {
+		return err
+	}
+	fi.result.Refs["refs/tags/"+name] = hash
+	return nil
+}
+
+func (fi *fastImporter) reset(ref string) error {
+	from, ok, err := fi.optional("from")
+	if err != nil {
+		return err
+	}
+	hash := ""
+	if ok {
+		if hash, err = fi.resolve(from); err != nil {
+			return err
+		}
+	}
+	fi.result.Refs[ref] = hash
+	return nil
+}
+
+func fastImportMode(field string) (int64, error) {
+	mode, err := strconv.ParseInt(field, 8, 64)
+	switch {
+	case err != nil:
+		return 0, fmt.Errorf("invalid mode %q", field)
+	case mode == 0160000:
+		return 0, fmt.Errorf("submodules are not supported")
+	case mode == 0644 || mode == ModeRegular:
+		return ModeRegular, nil
+	case mode == 0755 || mode == ModeExecutable:
+		return ModeExecutable, nil
+	case mode == ModeSymlink:
+		return ModeSymlink, nil
+	}
+	return 0, fmt.Errorf("unsupported mode %q", field)
+}
+
+// the refs a stream updates are branches and tags under refs/
+func checkFastRef(name string) error {
+	if err := CheckRefName(name); err != nil || !strings.HasPrefix(name, "refs/") {
+		return fmt.Errorf("invalid ref name %q", name)
+	}
+	return nil
+}
+
+// a path, C style quoted or else running to the end of the line, or to the first space for the source of C and
+// R; rest is what follows it
+func unquoteFastPath(s string, toEnd bool) (string, string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		if s == "" {
+			return "", "", fmt.Errorf("missing path")
+		}
+		if toEnd {
+			return s, "", CheckPath(s)
+		}
+		path, rest, _ := strings.Cut(s, " ")
+		return path, rest, CheckPath(path)
+	}
+	quoted, err := strconv.QuotedPrefix(s)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid quoted path %s", s)
+	}
+	path, err := strconv.Unquote(quoted)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid quoted path %s", s)
+	}
+	return path, s[len(quoted):], CheckPath(path)
+}
+
+// drops a file or a whole directory
+func removeFastPath(files map[string]IndexEntry, path string) {
+	for p := range files {
+		if p == path || strings.HasPrefix(p, path+"/") {
+			delete(files, p)
+		}
+	}
+}
+
+// adds a file, replacing a directory at its path and files where its parent directories go
+func placeFastPath(files map[string]IndexEntry, e IndexEntry) {
+	removeFastPath(files, e.Path)
+	for dir := e.Path; strings.Contains(dir, "/"); {
+		dir = dir[:strings.LastIndex(dir, "/")]
+		delete(files, dir)
+	}
+	files[e.Path] = e
+}
@@ -0,0 +1,425 @@
+package gitre
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GitMap pairs gitre objects with the git objects they were imported from or exported to, kept in
+// .gitre/git-map as "<sha256> <sha1>" lines so later imports and exports only convert new objects
+type GitMap struct {
+	ToGit   map[string]string
+	FromGit map[string]string
+	path    string
+	added   []string
+}
+
+func (r *Repository) ReadGitMap() (*GitMap, error) {
+	m := &GitMap{ToGit: map[string]string{}, FromGit: map[string]string{}, path: r.Path("git-map")}
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, fmt.Errorf("failed to read git-map: %w", err)
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		ours, theirs, ok := strings.Cut(line, " ")
+		if !ok || !IsObjectHash(ours) || len(theirs) != 40 || !IsHex(theirs) {
+			return nil, fmt.Errorf("invalid git-map line %d: %q", i+1, line)
+		}
+		m.ToGit[ours], m.FromGit[theirs] = theirs, ours
+	}
+	return m, nil
+}
+
+func (m *GitMap) set(ours string, theirs string) {
+	if m.ToGit[ours] == theirs && m.FromGit[theirs] == ours {
+		return
+	}
+	m.ToGit[ours], m.FromGit[theirs] = theirs, ours
+	m.added = append(m.added, ours+" "+theirs+"\n")
+}
+
+// Write appends the pairs added since the map was read
+func (m *GitMap) Write() error {
+	if len(m.added) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(m.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write git-map: %w", err)
+	}
+	if _, err := f.WriteString(strings.Join(m.added, "")); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write git-map: %w", err)
+	}
+	m.added = nil
+	return f.Close()
+}
+
+// GitRefs lists the branches and tags of a git directory, loose refs overriding packed-refs, and the branch HEAD
+// points at
+func GitRefs(gitDir string) (map[string]string, string, error) {
+	refs := map[string]string{}
+	if data, err := os.ReadFile(filepath.Join(gitDir, "packed-refs")); err == nil {
+		for line := range strings.SplitSeq(string(data), "\n") {
+			hash, name, ok := strings.Cut(line, " ")
+			if ok && !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "^") {
+				refs[name] = hash
+			}
+		}
+	}
+	for _, dir := range []string{"refs/heads", "refs/tags"} {
+		root := filepath.Join(gitDir, filepath.FromSlash(dir))
+		err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(gitDir, path)
+			refs[filepath.ToSlash(rel)] = strings.TrimSpace(string(data))
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, "", fmt.Errorf("failed to read git refs: %w", err)
+		}
+	}
+	head := ""
+	if data, err := os.ReadFile(filepath.Join(gitDir, "HEAD")); err == nil {
+		head, _ = strings.CutPrefix(strings.TrimSpace(string(data)), "ref: ")
+	}
+	return refs, head, nil
+}
+
+func readGitObject(gitDir string, hash string) (string, []byte, error) {
+	if len(hash) != 40 || !IsHex(hash) {
+		return "", nil, fmt.Errorf("invalid git object name %q", hash)
+	}
+	f, err := os.Open(filepath.Join(gitDir, "objects", hash[:2], hash[2:]))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil, fmt.Errorf("git object %s is not a loose object, unpack the repository first with 'git unpack-objects'", hash)
+		}
+		return "", nil, err
+	}
+	defer f.Close()
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		return "", nil, fmt.Errorf("corrupt git object %s: %w", hash, err)
+	}
+	defer zr.Close()
+	content, err := io.ReadAll(zr)
+	if err != nil {
+		return "", nil, fmt.Errorf("corrupt git object %s: %w", hash, err)
+	}
+	if sum := sha1.Sum(content); hex.EncodeToString(sum[:]) != hash {
+		return "", nil, fmt.Errorf("git object %s does not match its hash", hash)
+	}
+	header, data, ok := bytes.Cut(content, []byte{0})
+	if !ok {
+		return "", nil, fmt.Errorf("corrupt git object %s: no header", hash)
+	}
+	objType, _, _ := strings.Cut(string(header), " ")
+	return objType, data, nil
+}
+
+func writeGitObject(gitDir string, objType string, data []byte) (string, error) {
+	content := append([]byte(fmt.Sprintf("%s %d\x00", objType, len(data))), data...)
+	sum := sha1.Sum(content)
+	hash := hex.EncodeToString(sum[:])
+	path := filepath.Join(gitDir, "objects", hash[:2], hash[2:])
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(content)
+	zw.Close()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to write git object: %w", err)
+	}
+	if err := os.WriteFile(path, compressed.Bytes(), 0444); err != nil {
+		return "", fmt.Errorf("failed to write git object: %w", err)
+	}
+	return hash, nil
+}
+
+// ImportGit converts the objects reachable from the given git object names, recording them in m; annotated
+// tags are peeled to the object they point at. Returns the gitre hash for each name and how many objects
+// were converted
+func (r *Repository) ImportGit(gitDir string, hashes []string, m *GitMap) (map[string]string, int, error) {
+	converted := 0
+	var convert func(hash string) (string, error)
+	convert = func(hash string) (string, error) {
+		if ours, ok := m.FromGit[hash]; ok && r.Objects.Has(ours) {
+			return ours, nil
+		}
+		objType, data, err := readGitObject(gitDir, hash)
+		if err != nil {
+			return "", err
+		}
+		var ours string
+		switch objType {
+		case "blob":
+			ours, err = r.HashStore(data, "blob")
+		case "tree":
+			ours, err = r.importGitTree(hash, data, convert)
+		case "commit":
+			ours, err = r.importGitCommit(data, convert)
+		case "tag":
+			target, _, _ := strings.Cut(strings.TrimPrefix(string(data), "object "), "\n")
+			return convert(target)
+		default:
+			return "", fmt.Errorf("git object %s has unknown type %s", hash, objType)
+		}
+		if err != nil {
+			return "", err
+		}
+		m.set(ours, hash)
+		converted++
+		return ours, nil
+	}
+
+	result := map[string]string{}
+	for _, hash := range hashes {
+		ours, err := convert(hash)
+		if err != nil {
+			return nil, converted, err
+		}
+		result[hash] = ours
+	}
+	return result, converted, nil
+}
+
+// git trees are "<mode> <name>\0<20 byte hash>" entries
+func (r *Repository) importGitTree(hash string, data []byte, convert func(string) (string, error)) (string, error) {
+	type entry struct{ mode, kind, hash, name string }
+	var entries []entry
+	for len(data) > 0 {
+		header, rest, ok := bytes.Cut(data, []byte{0})
+		if !ok || len(rest) < 20 {
+			return "", fmt.Errorf("corrupt git tree %s", hash)
+		}
+		modeField, name, _ := strings.Cut(string(header), " ")
+		child := hex.EncodeToString(rest[:20])
+		data = rest[20:]
+		// one path component, as git fsck requires, that the line based tree format can hold
+		if strings.ContainsAny(name, "/\n") || CheckPath(name) != nil {
+			return "", fmt.Errorf("corrupt git tree %s: invalid entry name %q", hash, name)
+		}
+
+		mode, err := strconv.ParseInt(modeField, 8, 64)
+		if err != nil {
+			return "", fmt.Errorf("corrupt git tree %s: invalid mode %q", hash, modeField)
+		}
+		kind := "blob"
+		switch {
+		case mode == 0160000:
+			return "", fmt.Errorf("submodule %s in git tree %s is not supported", name, hash)
+		case mode == ModeDir:
+			kind = "tree"
+		case mode&0111 != 0 && mode != ModeSymlink:
+			mode = ModeExecutable
+		case mode != ModeSymlink:
+			mode = ModeRegular
+		}
+		ours, err := convert(child)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, entry{FormatMode(mode), kind, ours, name})
+	}
+	// same order as WriteTree so an imported tree hashes like one built from the index
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	var lines []string
+	for _, e := range entries {
+		lines = append(lines, fmt.Sprintf("%s %s %s %s", e.mode, e.kind, e.hash, e.name))
+	}
+	return r.HashStore([]byte(strings.Join(lines, "\n")), "tree")
+}
+
+// keeps tree, parents, author, committer and message; other headers such as signatures cannot survive the rewrite
+func (r *Repository) importGitCommit(data []byte, convert func(string) (string, error)) (string, error) {
+	headers, message, _ := strings.Cut(string(data), "\n\n")
+	c := &Commit{Message: message}
+	for line := range strings.SplitSeq(headers, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		var err error
+		switch key {
+		case "tree":
+			c.Tree, err = convert(value)
+		case "parent":
+			var parent string
+			if parent, err = convert(value); err == nil {
+				c.Parents = append(c.Parents, parent)
+			}
+		case "author":
+			c.Author = value
+		case "committer":
+			c.Committer = value
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return r.WriteCommitObject(c)
+}
+
+// ExportGit writes the objects reachable from the given commits to the object database of a git directory,
+// recording them in m; commits without an author get ident. Returns the git hash for each commit and how many
+// objects were written
+func (r *Repository) ExportGit(gitDir string, hashes []string, ident string, m *GitMap) (map[string]string, int, error) {
+	written := 0
+	var convert func(hash string) (string, error)
+	convert = func(hash string) (string, error) {
+		if theirs, ok := m.ToGit[hash]; ok {
+			if _, err := os.Stat(filepath.Join(gitDir, "objects", theirs[:2], theirs[2:])); err == nil {
+				return theirs, nil
+			}
+		}
+		objType, data, err := r.ReadObject(hash)
+		if err != nil {
+			return "", fmt.Errorf("error extracting object %s: %w", hash, err)
+		}
+		switch objType {
+		case "tree":
+			data, err = r.exportGitTree(hash, data, convert)
+		case "commit":
+			data, err = exportGitCommit(ParseCommit(data), ident, convert)
+		}
+		if err != nil {
+			return "", err
+		}
+		theirs, err := writeGitObject(gitDir, objType, data)
+		if err != nil {
+			return "", err
+		}
+		m.set(hash, theirs)
+		written++
+		return theirs, nil
+	}
+
+	result := map[string]string{}
+	for _, hash := range hashes {
+		theirs, err := convert(hash)
+		if err != nil {
+			return nil, written, err
+		}
+		result[hash] = theirs
+	}
+	return result, written, nil
+}
+
+func (r *Repository) exportGitTree(hash string, data []byte, convert func(string) (string, error)) ([]byte, error) {
+	type entry struct {
+		mode, name string
+		hash       []byte
+		dir        bool
+	}
+	var entries []entry
+	for line := range strings.SplitSeq(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid tree entry in %s: %q", hash, line)
+		}
+		mode, err := parseMode(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid tree entry in %s: %w", hash, err)
+		}
+		theirs, err := convert(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		raw, _ := hex.DecodeString(theirs)
+		entries = append(entries, entry{FormatMode(mode), fields[3], raw, fields[1] == "tree"})
+	}
+	// git orders a directory as if its name ended with a slash
+	sortName := func(e entry) string {
+		if e.dir {
+			return e.name + "/"
+		}
+		return e.name
+	}
+	sort.Slice(entries, func(i, j int) bool { return sortName(entries[i]) < sortName(entries[j]) })
+	var b bytes.Buffer
+	for _, e := range entries {
+		fmt.Fprintf(&b, "%s %s\x00", e.mode, e.name)
+		b.Write(e.hash)
+	}
+	return b.Bytes(), nil
+}
+
+func exportGitCommit(c *Commit, ident string, convert func(string) (string, error)) ([]byte, error) {
+	var b strings.Builder
+	tree, err := convert(c.Tree)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&b, "tree %s\n", tree)
+	for _, p := range c.Parents {
+		parent, err := convert(p)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "parent %s\n", parent)
+	}
+	author, committer := c.Author, c.Committer
+	if author == "" {
+		author = ident
+	}
+	if committer == "" {
+		committer = author
+	}
+	fmt.Fprintf(&b, "author %s\ncommitter %s\n\n%s", author, committer, c.Message)
+	return []byte(b.String()), nil
+}
+
+// InitGit creates an empty bare git repository at gitDir unless one is there
+func InitGit(gitDir string) error {
+	if _, err := os.Stat(filepath.Join(gitDir, "HEAD")); err == nil {
+		return nil
+	}
+	for _, dir := range []string{"objects", "refs/heads", "refs/tags"} {
+		if err := os.MkdirAll(filepath.Join(gitDir, filepath.FromSlash(dir)), 0755); err != nil {
+			return fmt.Errorf("failed to create git repository: %w", err)
+		}
+	}
+	files := map[string]string{
+		"HEAD":   "ref: refs/heads/main\n",
+		"config": "[core]\n\trepositoryformatversion = 0\n\tfilemode = true\n\tbare = true\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(gitDir, name), []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to create git repository: %w", err)
+		}
+	}
+	return nil
+}
+
+// WriteGitRef points a ref of a git directory at hash, replacing any packed value
+func WriteGitRef(gitDir string, name string, hash string) error {
+	path := filepath.Join(gitDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to write git ref %s: %w", name, err)
+	}
+	return os.WriteFile(path, []byte(hash+"\n"), 0644)
+}
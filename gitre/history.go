@@ -0,0 +1,72 @@
+package gitre
+
+// all commits reachable from hash, hash included
+func (r *Repository) Ancestors(hash string) (map[string]bool, error) {
+	seen := map[string]bool{}
+	queue := []string{hash}
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		if h == "" || seen[h] {
+			continue
+		}
+		seen[h] = true
+		c, err := r.ReadCommit(h)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, c.Parents...)
+	}
+	return seen, nil
+}
+
+// commits reachable from local but not upstream, and the other way around
+func (r *Repository) AheadBehind(local string, upstream string) (int, int, error) {
+	fromLocal, err := r.Ancestors(local)
+	if err != nil {
+		return 0, 0, err
+	}
+	fromUpstream, err := r.Ancestors(upstream)
+	if err != nil {
+		return 0, 0, err
+	}
+	ahead, behind := 0, 0
+	for h := range fromLocal {
+		if !fromUpstream[h] {
+			ahead++
+		}
+	}
+	for h := range fromUpstream {
+		if !fromLocal[h] {
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+// nearest common ancestor of a and b, found by walking a breadth first; empty when unrelated
+func (r *Repository) MergeBase(a string, b string) (string, error) {
+	fromB, err := r.Ancestors(b)
+	if err != nil {
+		return "", err
+	}
+	seen := map[string]bool{}
+	queue := []string{a}
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		if h == "" || seen[h] {
+			continue
+		}
+		if fromB[h] {
+			return h, nil
+		}
+		seen[h] = true
+		c, err := r.ReadCommit(h)
+		if err != nil {
+			return "", err
+		}
+		queue = append(queue, c.Parents...)
+	}
+	return "", nil
+}
@@ -0,0 +1,278 @@
+package gitre
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// smart HTTP protocol, every url is relative to the repository:
+//
+//	GET  info/refs     ref advertisement: "HEAD <branch>" then "<hash> <ref>" lines
+//	POST upload-pack   "want <hash>" and "have <hash>" lines, answered with a pack of what the client lacks
+//	POST receive-pack  "update <old> <new> <ref>" lines, an empty line and a pack; answered with "ok"
+const packContentType = "application/x-gitre-pack"
+
+// HTTPServer serves the repository at Root, or every repository below it by its relative path; pushes are
+// refused unless AllowPush is set, the server does no authentication of its own
+type HTTPServer struct {
+	Root      string
+	AllowPush bool
+	mu        sync.Mutex // serializes pushes so ref updates see each other
+}
+
+func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	urlPath := path.Clean("/" + req.URL.Path)
+	var repoPath, service string
+	for _, suffix := range []string{"/info/refs", "/upload-pack", "/receive-pack"} {
+		if prefix, ok := strings.CutSuffix(urlPath, suffix); ok {
+			repoPath, service = prefix, suffix[1:]
+			break
+		}
+	}
+	if service == "" {
+		http.NotFound(w, req)
+		return
+	}
+	r, err := OpenRemote(filepath.Join(s.Root, filepath.FromSlash(repoPath)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case service == "info/refs" && req.Method == http.MethodGet:
+		refs, head, err := (&LocalTransport{Repo: r}).Refs()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "HEAD %s\n", head)
+		for _, name := range SortedRefs(refs) {
+			fmt.Fprintf(w, "%s %s\n", refs[name], name)
+		}
+	case service == "upload-pack" && req.Method == http.MethodPost:
+		refs, _, err := (&LocalTransport{Repo: r}).Refs()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// only the advertised tips can be asked for, not any object known by its hash
+		tips := map[string]bool{}
+		for _, hash := range refs {
+			tips[hash] = true
+		}
+		var wants, haves []string
+		scanner := bufio.NewScanner(req.Body)
+		for scanner.Scan() {
+			kind, hash, _ := strings.Cut(scanner.Text(), " ")
+			switch kind {
+			case "want":
+				if !tips[hash] {
+					http.Error(w, fmt.Sprintf("%s is not an advertised ref", hash), http.StatusBadRequest)
+					return
+				}
+				wants = append(wants, hash)
+			case "have":
+				haves = append(haves, hash)
+			}
+		}
+		objects, err := r.MissingObjects(wants, haves)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", packContentType)
+		if err := r.WritePack(w, objects); err != nil {
+			// the status is already sent, dropping the connection keeps the client from taking a partial pack
+			panic(http.ErrAbortHandler)
+		}
+	case service == "receive-pack" && req.Method == http.MethodPost && !s.AllowPush:
+		http.Error(w, "pushing is disabled on this server", http.StatusForbidden)
+	case service == "receive-pack" && req.Method == http.MethodPost:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err := receivePack(r, req.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func receivePack(r *Repository, body io.Reader) error {
+	br := bufio.NewReader(body)
+	var updates []RefUpdate
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("invalid push request")
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 4 || fields[0] != "update" {
+			return fmt.Errorf("invalid push request line %q", line)
+		}
+		if err := CheckRefName(fields[3]); err != nil {
+			return err
+		}
+		updates = append(updates, RefUpdate{Name: fields[3], Old: unzero(fields[1]), New: unzero(fields[2])})
+	}
+	if _, err := ReadPack(br, r.Objects); err != nil {
+		return err
+	}
+	return r.ApplyRefUpdates(updates)
+}
+
+func unzero(hash string) string {
+	if hash == ZeroHash {
+		return ""
+	}
+	return hash
+}
+
+func zeroed(hash string) string {
+	if hash == "" {
+		return ZeroHash
+	}
+	return hash
+}
+
+// HTTPTransport talks to a repository served by HTTPServer
+type HTTPTransport struct {
+	URL    string
+	Client *http.Client
+}
+
+func (t *HTTPTransport) client() *http.Client {
+	if t.Client != nil {
+		return t.Client
+	}
+	return http.DefaultClient
+}
+
+func (t *HTTPTransport) endpoint(service string) string {
+	return strings.TrimSuffix(t.URL, "/") + "/" + service
+}
+
+// sends a request and fails on any status but 200, the server's message becoming the error
+func (t *HTTPTransport) do(method string, service string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, t.endpoint(service), body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", t.URL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", t.URL, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+func (t *HTTPTransport) Refs() (map[string]string, string, error) {
+	resp, err := t.do(http.MethodGet, "info/refs", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	refs := map[string]string{}
+	head := ""
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		first, second, ok := strings.Cut(scanner.Text(), " ")
+		switch {
+		case !ok:
+			return nil, "", fmt.Errorf("invalid ref advertisement from %s", t.URL)
+		case first == "HEAD":
+			head = second
+		default:
+			refs[second] = first
+		}
+	}
+	return refs, head, scanner.Err()
+}
+
+func (t *HTTPTransport) Fetch(dst *Repository, wants []string) (int, error) {
+	var missing []string
+	for _, want := range wants {
+		if !dst.Objects.Has(want) {
+			missing = append(missing, want)
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+	local, err := dst.ListRefs("refs/")
+	if err != nil {
+		return 0, err
+	}
+	var request bytes.Buffer
+	for _, want := range missing {
+		fmt.Fprintf(&request, "want %s\n", want)
+	}
+	for _, name := range SortedRefs(local) {
+		fmt.Fprintf(&request, "have %s\n", local[name])
+	}
+	resp, err := t.do(http.MethodPost, "upload-pack", &request)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return ReadPack(resp.Body, dst.Objects)
+}
+
+func (t *HTTPTransport) Push(src *Repository, updates []RefUpdate) error {
+	remote, _, err := t.Refs()
+	if err != nil {
+		return err
+	}
+	var wants, haves []string
+	for _, u := range updates {
+		if u.New != "" {
+			wants = append(wants, u.New)
+		}
+	}
+	for _, hash := range remote {
+		haves = append(haves, hash)
+	}
+	objects, err := src.MissingObjects(wants, haves)
+	if err != nil {
+		return err
+	}
+
+	var request bytes.Buffer
+	for _, u := range updates {
+		fmt.Fprintf(&request, "update %s %s %s\n", zeroed(u.Old), zeroed(u.New), u.Name)
+	}
+	request.WriteString("\n")
+	if err := src.WritePack(&request, objects); err != nil {
+		return err
+	}
+	resp, err := t.do(http.MethodPost, "receive-pack", &request)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// IsURL reports whether a remote is reached over HTTP rather than the filesystem
+func IsURL(remote string) bool {
+	return strings.HasPrefix(remote, "http://") || strings.HasPrefix(remote, "https://")
+}
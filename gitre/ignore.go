@@ -0,0 +1,245 @@
+package gitre
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type IgnoreRule struct {
+	Pattern  string // line as written in its source
+	Source   string // file the rule was read from
+	Line     int
+	Base     string // directory the rule is relative to, "" for the repo root
+	Negate   bool   // "!" rule that re-includes what earlier rules excluded
+	glob     string
+	dirOnly  bool
+	anchored bool
+}
+
+// ignore rules of the repo, nested .gitreignore files are loaded as directories are visited
+type Ignores struct {
+	rules  []IgnoreRule
+	loaded map[string]bool
+	root   string
+}
+
+// Ignores reads the builtin rules, .gitre/info/exclude and the root .gitreignore
+func (r *Repository) Ignores() *Ignores {
+	ignores := &Ignores{loaded: map[string]bool{}, root: r.WorkTree}
+	ignores.rules = append(ignores.rules, parseIgnoreLine(".git", "<builtin>", 0, ""))
+	ignores.addFile(r.Path("info", "exclude"), "")
+	ignores.loadDir("")
+	return ignores
+}
+
+// NoIgnores is a rule set that ignores nothing but the repository directory itself
+func NoIgnores() *Ignores {
+	return &Ignores{loaded: map[string]bool{"": true}}
+}
+
+// reads the .gitreignore of dir (slash separated, "" for the root) once
+func (ig *Ignores) loadDir(dir string) {
+	if ig.loaded[dir] {
+		return
+	}
+	ig.loaded[dir] = true
+	if dir == "" {
+		ig.addFile(".gitreignore", "")
+		return
+	}
+	ig.addFile(path.Join(dir, ".gitreignore"), dir)
+}
+
+// reads rules from file, given relative to the top of the working tree or absolute
+func (ig *Ignores) addFile(file string, base string) {
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(ig.root, file)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return
+	}
+	if rel, err := filepath.Rel(ig.root, file); err == nil {
+		file = rel
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ig.rules = append(ig.rules, parseIgnoreLine(line, filepath.ToSlash(file), i+1, base))
+	}
+}
+
+func parseIgnoreLine(line string, source string, lineNo int, base string) IgnoreRule {
+	rule := IgnoreRule{Pattern: line, Source: source, Line: lineNo, Base: base}
+	glob := line
+	if strings.HasPrefix(glob, "!") {
+		rule.Negate = true
+		glob = glob[1:]
+	} else if strings.HasPrefix(glob, `\!`) || strings.HasPrefix(glob, `\#`) {
+		glob = glob[1:]
+	}
+	if strings.HasSuffix(glob, "/") {
+		rule.dirOnly = true
+		glob = strings.TrimRight(glob, "/")
+	}
+	if strings.Contains(glob, "/") {
+		rule.anchored = true
+		glob = strings.TrimPrefix(glob, "/")
+	}
+	rule.glob = glob
+	return rule
+}
+
+// reports whether rule applies to the slash separated path relative to the repo root
+func (r *IgnoreRule) matches(p string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	rel := p
+	if r.Base != "" {
+		var ok bool
+		if rel, ok = strings.CutPrefix(p, r.Base+"/"); !ok {
+			return false
+		}
+	}
+	if !r.anchored {
+		match, _ := path.Match(r.glob, path.Base(rel))
+		return match
+	}
+	return matchSegments(strings.Split(r.glob, "/"), strings.Split(rel, "/"))
+}
+
+// glob matching per path segment where a "**" segment spans any number of segments
+func matchSegments(pattern []string, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return len(parts) > 0
+			}
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if match, _ := path.Match(pattern[0], parts[0]); !match {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// finds the rule deciding p, including rules that exclude one of its parent directories
+func (ig *Ignores) Match(p string, isDir bool) *IgnoreRule {
+	p = CleanPath(p)
+	parts := strings.Split(p, "/")
+	for i := range parts {
+		if parts[i] == ".gitre" {
+			return &IgnoreRule{Pattern: ".gitre", Source: "<builtin>", glob: ".gitre"}
+		}
+		dir := strings.Join(parts[:i], "/")
+		ig.loadDir(dir)
+		current := strings.Join(parts[:i+1], "/")
+		last := i == len(parts)-1
+		rule := ig.lastMatch(current, !last || isDir)
+		if rule != nil && (last || !rule.Negate) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (ig *Ignores) lastMatch(p string, isDir bool) *IgnoreRule {
+	for i := len(ig.rules) - 1; i >= 0; i-- {
+		if ig.rules[i].matches(p, isDir) {
+			return &ig.rules[i]
+		}
+	}
+	return nil
+}
+
+// reports whether p is excluded
+func (ig *Ignores) Ignored(p string, isDir bool) bool {
+	rule := ig.Match(p, isDir)
+	return rule != nil && !rule.Negate
+}
+
+// TraverseDir lists the files below dir that are not ignored, dir is relative to the top of the working tree
+func (r *Repository) TraverseDir(dir string, ignores *Ignores) ([]string, error) {
+	return r.WalkDir(dir, ignores, nil)
+}
+
+// WalkDir is like TraverseDir, also collecting ignored files and directories (with a trailing slash) when ignored is not nil
+func (r *Repository) WalkDir(dir string, ignores *Ignores, ignored *[]string) ([]string, error) {
+	var list []string
+	files, err := os.ReadDir(r.abs(dir))
+	if err != nil {
+		return list, fmt.Errorf("failed to read dir: %w", err)
+	}
+	ignores.loadParents(CleanPath(dir))
+
+	for _, file := range files {
+		name := file.Name()
+		rawPath := filepath.Join(dir, name)
+		slashPath := CleanPath(rawPath)
+		// the directory itself was already let through, so only the entry's own rules matter
+		if name == ".gitre" {
+			continue
+		}
+		if rule := ignores.lastMatch(slashPath, file.IsDir()); rule != nil && !rule.Negate {
+			if ignored != nil {
+				if file.IsDir() {
+					slashPath += "/"
+				}
+				*ignored = append(*ignored, slashPath)
+			}
+			continue
+		}
+
+		if file.IsDir() {
+			subFiles, _ := r.WalkDir(rawPath, ignores, ignored)
+			list = append(list, subFiles...)
+		} else {
+			list = append(list, slashPath)
+		}
+	}
+
+	return list, nil
+}
+
+// loads the .gitreignore files of dir and all directories above it
+func (ig *Ignores) loadParents(dir string) {
+	if dir == "." {
+		return
+	}
+	parts := strings.Split(dir, "/")
+	for i := range parts {
+		ig.loadDir(strings.Join(parts[:i+1], "/"))
+	}
+}
+
+// CleanPath turns a path into the slash separated form used by the index
+func CleanPath(p string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(p)), "./")
+}
+
+// MatchesAnyPath reports whether path equals one of paths or lies below one of them
+func MatchesAnyPath(path string, paths []string) bool {
+	for _, p := range paths {
+		p = CleanPath(p)
+		if p == "." || path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
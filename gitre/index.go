@@ -0,0 +1,206 @@
+package gitre
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// binary index layout, all integers big endian:
+//
+//	"GIDX" | version uint32 | entry count uint32
+//	per entry: mtime, mtime nsec, ctime, ctime nsec int64 | inode uint64 | mode uint32 | size int64
+//	           | 32 byte hash | path length uint32 | path
+//	sha256 of everything above
+const (
+	indexMagic   = "GIDX"
+	indexVersion = 1
+	// an entry with an empty path
+	indexMinEntrySize = 4*8 + 8 + 4 + 8 + sha256.Size + 4
+)
+
+// staging area kept sorted by path
+type Index struct {
+	Entries []IndexEntry
+	path    string
+}
+
+// reads the index, json indexes from older versions are upgraded on the next write
+func (r *Repository) ReadIndex() (*Index, error) {
+	indexPath := r.Path("index")
+
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Index{path: indexPath}, nil
+		}
+		return nil, fmt.Errorf("could not read index: %w", err)
+	}
+
+	var entries []IndexEntry
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) == 0:
+	case trimmed[0] == '[':
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, fmt.Errorf("could not parse index JSON: %w", err)
+		}
+	default:
+		if entries, err = decodeIndex(data); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range entries {
+		entries[i].Mode = NormalizeMode(entries[i].Mode)
+	}
+	idx := &Index{Entries: entries, path: indexPath}
+	idx.sort()
+	return idx, nil
+}
+
+// writes the index through a lock file so readers never see a partial index
+func (idx *Index) Write() error {
+	idx.sort()
+	data, err := encodeIndex(idx.Entries)
+	if err != nil {
+		return err
+	}
+	indexPath := idx.path
+	lockPath := indexPath + ".lock"
+	if err := os.WriteFile(lockPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	if err := os.Rename(lockPath, indexPath); err != nil {
+		os.Remove(lockPath)
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return nil
+}
+
+func (idx *Index) sort() {
+	sort.SliceStable(idx.Entries, func(i, j int) bool { return idx.Entries[i].Path < idx.Entries[j].Path })
+}
+
+// binary search for path, returns its position or where it would be inserted
+func (idx *Index) Find(path string) (int, bool) {
+	i := sort.Search(len(idx.Entries), func(i int) bool { return idx.Entries[i].Path >= path })
+	return i, i < len(idx.Entries) && idx.Entries[i].Path == path
+}
+
+func (idx *Index) Get(path string) (IndexEntry, bool) {
+	i, ok := idx.Find(path)
+	if !ok {
+		return IndexEntry{}, false
+	}
+	return idx.Entries[i], true
+}
+
+// inserts or replaces the entry for e.Path
+func (idx *Index) Set(e IndexEntry) {
+	i, ok := idx.Find(e.Path)
+	if ok {
+		idx.Entries[i] = e
+		return
+	}
+	idx.Entries = append(idx.Entries, IndexEntry{})
+	copy(idx.Entries[i+1:], idx.Entries[i:])
+	idx.Entries[i] = e
+}
+
+func (idx *Index) Remove(path string) bool {
+	i, ok := idx.Find(path)
+	if ok {
+		idx.Entries = append(idx.Entries[:i], idx.Entries[i+1:]...)
+	}
+	return ok
+}
+
+// reads objects from staging
+func (r *Repository) LoadIndex() ([]IndexEntry, error) {
+	idx, err := r.ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+	return idx.Entries, nil
+}
+
+// writes entries back to staging
+func (r *Repository) WriteIndex(entries []IndexEntry) error {
+	return (&Index{Entries: entries, path: r.Path("index")}).Write()
+}
+
+func encodeIndex(entries []IndexEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(indexMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(indexVersion))
+	binary.Write(&buf, binary.BigEndian, uint32(len(entries)))
+	for _, e := range entries {
+		hash, err := hex.DecodeString(e.Hash)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid hash for index entry %s: %q", e.Path, e.Hash)
+		}
+		binary.Write(&buf, binary.BigEndian, []int64{e.Mtime, e.MtimeNsec, e.Ctime, e.CtimeNsec})
+		binary.Write(&buf, binary.BigEndian, e.Inode)
+		binary.Write(&buf, binary.BigEndian, uint32(e.Mode))
+		binary.Write(&buf, binary.BigEndian, e.Size)
+		buf.Write(hash)
+		binary.Write(&buf, binary.BigEndian, uint32(len(e.Path)))
+		buf.WriteString(e.Path)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.Bytes(), nil
+}
+
+func decodeIndex(data []byte) ([]IndexEntry, error) {
+	if len(data) < len(indexMagic)+8+sha256.Size || string(data[:len(indexMagic)]) != indexMagic {
+		return nil, fmt.Errorf("invalid index: bad header")
+	}
+	body, trailer := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if sum := sha256.Sum256(body); !bytes.Equal(sum[:], trailer) {
+		return nil, fmt.Errorf("invalid index: checksum mismatch")
+	}
+
+	r := bytes.NewReader(body[len(indexMagic):])
+	var version, count uint32
+	binary.Read(r, binary.BigEndian, &version)
+	if version != indexVersion {
+		return nil, fmt.Errorf("unsupported index version %d", version)
+	}
+	binary.Read(r, binary.BigEndian, &count)
+	// the count is only trusted as far as the data could hold that many entries
+	if int64(count) > int64(r.Len()/indexMinEntrySize) {
+		return nil, fmt.Errorf("invalid index: %d entries do not fit in %d bytes", count, r.Len())
+	}
+
+	entries := make([]IndexEntry, 0, count)
+	for range count {
+		var e IndexEntry
+		var times [4]int64
+		var mode, pathLen uint32
+		hash := make([]byte, sha256.Size)
+		binary.Read(r, binary.BigEndian, &times)
+		binary.Read(r, binary.BigEndian, &e.Inode)
+		binary.Read(r, binary.BigEndian, &mode)
+		binary.Read(r, binary.BigEndian, &e.Size)
+		r.Read(hash)
+		if err := binary.Read(r, binary.BigEndian, &pathLen); err != nil || int(pathLen) > r.Len() {
+			return nil, fmt.Errorf("invalid index: truncated entry")
+		}
+		path := make([]byte, pathLen)
+		r.Read(path)
+
+		e.Mtime, e.MtimeNsec, e.Ctime, e.CtimeNsec = times[0], times[1], times[2], times[3]
+		e.Mode = int64(mode)
+		e.Hash = hex.EncodeToString(hash)
+		e.Path = string(path)
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,58 @@
+package gitre
+
+import "fmt"
+
+// walks the first parents from hash, newest first, handing visit each commit with its raw content
+func (r *Repository) Log(hash string, visit func(hash string, c *Commit, raw []byte) error) error {
+	for hash != "" {
+		content, err := r.ExtractObject([]byte(hash))
+		if err != nil {
+			return fmt.Errorf("error extracting object %s: %w", hash, err)
+		}
+		c := ParseCommit(content)
+		if err := visit(hash, c, content); err != nil {
+			return err
+		}
+		hash = ""
+		if len(c.Parents) > 0 {
+			hash = c.Parents[0]
+		}
+	}
+	return nil
+}
+
+// reports whether commit c changed path relative to its parent and, when following, the path it was renamed from
+func (r *Repository) TouchesPath(c *Commit, parentHash string, path string, follow bool) (bool, string, error) {
+	current, err := r.ReadTree(c.Tree)
+	if err != nil {
+		return false, "", err
+	}
+	parent, err := r.ReadCommitTree(parentHash)
+	if err != nil {
+		return false, "", err
+	}
+	FilterEntries(current, []string{path})
+	_, existed := parent[path]
+	_, exists := current[path]
+
+	if follow && exists && !existed {
+		opts := r.DefaultRenameOptions()
+		opts.Renames = true
+		for _, change := range r.DiffTrees(parent, current, opts) {
+			if change.NewPath == path && (change.Status == "R" || change.Status == "C") {
+				return true, change.OldPath, nil
+			}
+		}
+	}
+
+	FilterEntries(parent, []string{path})
+	if len(current) != len(parent) {
+		return true, "", nil
+	}
+	for p, e := range current {
+		if prev, ok := parent[p]; !ok || prev.Hash != e.Hash || prev.Mode != e.Mode {
+			return true, "", nil
+		}
+	}
+	return false, "", nil
+}
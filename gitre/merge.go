@@ -0,0 +1,280 @@
+package gitre
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// outcome of a three-way tree merge, conflicted paths hold their content with conflict markers
+type MergeResult struct {
+	Entries   map[string]IndexEntry
+	Conflicts []string
+}
+
+// merges the changes from base to theirs into ours; renames on either side are followed so
+// content changes land on the renamed path
+func (r *Repository) MergeTrees(base, ours, theirs map[string]IndexEntry, oursLabel string, theirsLabel string) (*MergeResult, error) {
+	result := &MergeResult{Entries: map[string]IndexEntry{}}
+
+	opts := r.DefaultRenameOptions()
+	opts.Copies = false
+	renamedByTheirs := r.renamedPaths(base, theirs, opts)
+	renamedByOurs := r.renamedPaths(base, ours, opts)
+
+	type triple struct{ base, ours, theirs *IndexEntry }
+	triples := map[string]triple{}
+	consumedBase, consumedOurs, consumedTheirs := map[string]bool{}, map[string]bool{}, map[string]bool{}
+	ptr := func(entries map[string]IndexEntry, path string) *IndexEntry {
+		if e, ok := entries[path]; ok {
+			return &e
+		}
+		return nil
+	}
+
+	for from, to := range renamedByTheirs {
+		if _, ok := ours[from]; !ok || renamedByOurs[from] != "" {
+			continue
+		}
+		triples[to] = triple{ptr(base, from), ptr(ours, from), ptr(theirs, to)}
+		consumedBase[from], consumedOurs[from], consumedTheirs[to] = true, true, true
+	}
+	for from, to := range renamedByOurs {
+		if _, ok := theirs[from]; !ok || renamedByTheirs[from] != "" {
+			continue
+		}
+		triples[to] = triple{ptr(base, from), ptr(ours, to), ptr(theirs, from)}
+		consumedBase[from], consumedOurs[to], consumedTheirs[from] = true, true, true
+	}
+
+	paths := map[string]bool{}
+	for _, side := range []map[string]IndexEntry{base, ours, theirs} {
+		for path := range side {
+			paths[path] = true
+		}
+	}
+	for path := range paths {
+		if _, ok := triples[path]; ok {
+			continue
+		}
+		t := triple{}
+		if !consumedBase[path] {
+			t.base = ptr(base, path)
+		}
+		if !consumedOurs[path] {
+			t.ours = ptr(ours, path)
+		}
+		if !consumedTheirs[path] {
+			t.theirs = ptr(theirs, path)
+		}
+		if t.base != nil || t.ours != nil || t.theirs != nil {
+			triples[path] = t
+		}
+	}
+
+	for path, t := range triples {
+		merged, conflict, err := r.mergeEntry(path, t.base, t.ours, t.theirs, oursLabel, theirsLabel)
+		if err != nil {
+			return nil, err
+		}
+		if merged != nil {
+			merged.Path = path
+			result.Entries[path] = *merged
+		}
+		if conflict {
+			result.Conflicts = append(result.Conflicts, path)
+		}
+	}
+	sort.Strings(result.Conflicts)
+	return result, nil
+}
+
+// old path -> new path for every rename from base to side
+func (r *Repository) renamedPaths(base map[string]IndexEntry, side map[string]IndexEntry, opts RenameOptions) map[string]string {
+	renames := map[string]string{}
+	if !opts.Renames {
+		return renames
+	}
+	for _, c := range r.DiffTrees(base, side, opts) {
+		if c.Status == "R" {
+			renames[c.OldPath] = c.NewPath
+		}
+	}
+	return renames
+}
+
+func SameEntry(a *IndexEntry, b *IndexEntry) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Hash == b.Hash && NormalizeMode(a.Mode) == NormalizeMode(b.Mode)
+}
+
+// three-way merge of one path, nil result means the path is deleted
+func (r *Repository) mergeEntry(path string, base, ours, theirs *IndexEntry, oursLabel string, theirsLabel string) (*IndexEntry, bool, error) {
+	switch {
+	case SameEntry(ours, theirs), SameEntry(base, theirs):
+		return ours, false, nil
+	case SameEntry(base, ours):
+		return theirs, false, nil
+	case ours == nil:
+		// deleted on our side, modified on theirs: keep their content for the user to decide
+		return theirs, true, nil
+	case theirs == nil:
+		return ours, true, nil
+	}
+
+	var baseData []byte
+	if base != nil {
+		data, err := r.ExtractObject([]byte(base.Hash))
+		if err != nil {
+			return nil, false, err
+		}
+		baseData = data
+	}
+	oursData, err := r.ExtractObject([]byte(ours.Hash))
+	if err != nil {
+		return nil, false, err
+	}
+	theirsData, err := r.ExtractObject([]byte(theirs.Hash))
+	if err != nil {
+		return nil, false, err
+	}
+
+	mode := ours.Mode
+	if base != nil && NormalizeMode(ours.Mode) == NormalizeMode(base.Mode) {
+		mode = theirs.Mode
+	}
+	if NormalizeMode(mode) == ModeSymlink || bytes.IndexByte(oursData, 0) >= 0 || bytes.IndexByte(theirsData, 0) >= 0 {
+		return ours, true, nil
+	}
+
+	merged, conflict := MergeLines(SplitLines(baseData), SplitLines(oursData), SplitLines(theirsData), oursLabel, theirsLabel)
+	hash, err := r.HashStore([]byte(merged), "blob")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to store merged %s: %w", path, err)
+	}
+	return &IndexEntry{Path: path, Hash: hash, Mode: mode}, conflict, nil
+}
+
+// for every line of a, the index of the matching line in b or -1
+func matchLines(a []string, b []string) []int {
+	match := make([]int, len(a))
+	i, j := 0, 0
+	for _, op := range DiffLines(a, b) {
+		switch op.Kind {
+		case ' ':
+			match[i] = j
+			i++
+			j++
+		case '-':
+			match[i] = -1
+			i++
+		case '+':
+			j++
+		}
+	}
+	return match
+}
+
+// line based three-way merge (diff3), conflicting hunks are wrapped in conflict markers
+func MergeLines(base, ours, theirs []string, oursLabel string, theirsLabel string) (string, bool) {
+	matchOurs := matchLines(base, ours)
+	matchTheirs := matchLines(base, theirs)
+
+	var out strings.Builder
+	conflict := false
+	i, j, k := 0, 0, 0
+	emitChunk := func(baseEnd, oursEnd, theirsEnd int) {
+		b, o, t := base[i:baseEnd], ours[j:oursEnd], theirs[k:theirsEnd]
+		switch {
+		case equalLines(o, t), equalLines(b, t):
+			writeLines(&out, o)
+		case equalLines(b, o):
+			writeLines(&out, t)
+		default:
+			conflict = true
+			out.WriteString("<<<<<<< " + oursLabel + "\n")
+			writeLines(&out, o)
+			out.WriteString("=======\n")
+			writeLines(&out, t)
+			out.WriteString(">>>>>>> " + theirsLabel + "\n")
+		}
+	}
+
+	for b := 0; b < len(base); b++ {
+		if matchOurs[b] < j || matchTheirs[b] < k {
+			continue
+		}
+		// base line b is kept on both sides: everything before it forms one chunk
+		emitChunk(b, matchOurs[b], matchTheirs[b])
+		out.WriteString(base[b])
+		i, j, k = b+1, matchOurs[b]+1, matchTheirs[b]+1
+	}
+	emitChunk(len(base), len(ours), len(theirs))
+	return out.String(), conflict
+}
+
+func equalLines(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writes lines, terminating a last line without newline so markers stay on their own line
+func writeLines(out *strings.Builder, lines []string) {
+	for _, l := range lines {
+		out.WriteString(l)
+		if !strings.HasSuffix(l, "\n") {
+			out.WriteString("\n")
+		}
+	}
+}
+
+const conflictsFile = "MERGE_CONFLICTS"
+
+// paths left with conflict markers by the last merge, one per line
+func (r *Repository) ReadConflicts() ([]string, error) {
+	data, err := os.ReadFile(r.Path(conflictsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read conflicts: %w", err)
+	}
+	return strings.Fields(string(data)), nil
+}
+
+func (r *Repository) WriteConflicts(paths []string) error {
+	file := r.Path(conflictsFile)
+	if len(paths) == 0 {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove conflicts: %w", err)
+		}
+		return nil
+	}
+	return os.WriteFile(file, []byte(strings.Join(paths, "\n")+"\n"), 0644)
+}
+
+// marks conflicts under paths as resolved, called when they are staged or removed
+func (r *Repository) ResolveConflicts(paths []string) error {
+	conflicts, err := r.ReadConflicts()
+	if err != nil || len(conflicts) == 0 {
+		return err
+	}
+	var remaining []string
+	for _, c := range conflicts {
+		if !MatchesAnyPath(c, paths) {
+			remaining = append(remaining, c)
+		}
+	}
+	return r.WriteConflicts(remaining)
+}
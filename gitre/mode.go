@@ -0,0 +1,83 @@
+package gitre
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// normalized modes stored in trees and the index, same values as git
+const (
+	ModeRegular    int64 = 0100644
+	ModeExecutable int64 = 0100755
+	ModeSymlink    int64 = 0120000
+	ModeDir        int64 = 0040000
+)
+
+func ModeFromInfo(info os.FileInfo) int64 {
+	return NormalizeMode(int64(info.Mode()))
+}
+
+// maps raw os.FileMode values written by older versions onto the normalized modes
+func NormalizeMode(m int64) int64 {
+	switch m {
+	case ModeRegular, ModeExecutable, ModeSymlink, ModeDir:
+		return m
+	}
+	fm := os.FileMode(m)
+	switch {
+	case fm&os.ModeSymlink != 0:
+		return ModeSymlink
+	case fm.IsDir() || m == 500:
+		return ModeDir
+	case fm.Perm()&0111 != 0:
+		return ModeExecutable
+	}
+	return ModeRegular
+}
+
+func FormatMode(m int64) string {
+	return strconv.FormatInt(NormalizeMode(m), 8)
+}
+
+// parses a tree mode, older trees stored the raw os.FileMode in decimal
+func parseMode(s string) (int64, error) {
+	if len(s) <= 3 {
+		m, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid mode %q", s)
+		}
+		return NormalizeMode(m), nil
+	}
+	m, err := strconv.ParseInt(s, 8, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mode %q", s)
+	}
+	return NormalizeMode(m), nil
+}
+
+// permission bits to create a file of mode m with
+func filePerm(m int64) os.FileMode {
+	if NormalizeMode(m) == ModeExecutable {
+		return 0755
+	}
+	return 0644
+}
+
+// content of a working tree file as it is stored in a blob, symlinks hold their target path
+func (r *Repository) ReadWorktreeFile(path string) ([]byte, os.FileInfo, error) {
+	path = r.abs(path)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []byte(target), info, nil
+	}
+	data, err := os.ReadFile(path)
+	return data, info, err
+}
@@ -0,0 +1,250 @@
+package gitre
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+type IndexEntry struct {
+	Path      string `json:"path"`
+	Hash      string `json:"hash"`
+	Mode      int64  `json:"mode"`
+	Size      int64  `json:"size"`
+	Mtime     int64  `json:"mtime"`
+	MtimeNsec int64  `json:"mtime_nsec,omitempty"`
+	Ctime     int64  `json:"ctime,omitempty"`
+	CtimeNsec int64  `json:"ctime_nsec,omitempty"`
+	Inode     uint64 `json:"inode,omitempty"`
+}
+
+// hashes and stores file, returns nil when its entry in idx is still clean; idx is only read so calls may run in parallel
+func (r *Repository) IndexObject(idx *Index, file string, racyTime int64) (*IndexEntry, error) {
+	fileInfo, err := os.Lstat(r.abs(file))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve file information: %w", err)
+	}
+	if e, ok := idx.Get(file); ok && StatClean(e, fileInfo, racyTime) {
+		return nil, nil
+	}
+
+	data, fileInfo, err := r.ReadWorktreeFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading file %s: %w", file, err)
+	}
+	objectHash, err := r.HashStore(data, "blob")
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash file %s: %w", file, err)
+	}
+	entry := EntryFromInfo(file, objectHash, fileInfo)
+	return &entry, nil
+}
+
+// builds an index entry for file with its current stat data
+func (r *Repository) EntryFromDisk(file string, hash string) (IndexEntry, error) {
+	fileInfo, err := os.Lstat(r.abs(file))
+	if err != nil {
+		return IndexEntry{}, fmt.Errorf("failed to retrieve file information: %w", err)
+	}
+	return EntryFromInfo(file, hash, fileInfo), nil
+}
+
+func EntryFromInfo(file string, hash string, fileInfo os.FileInfo) IndexEntry {
+	inode, ctime, ctimeNsec := statExtra(fileInfo)
+	mtime := fileInfo.ModTime()
+	return IndexEntry{
+		Path:      file,
+		Hash:      hash,
+		Mode:      ModeFromInfo(fileInfo),
+		Size:      fileInfo.Size(),
+		Mtime:     mtime.Unix(),
+		MtimeNsec: int64(mtime.Nanosecond()),
+		Ctime:     ctime,
+		CtimeNsec: ctimeNsec,
+		Inode:     inode,
+	}
+}
+
+// seconds timestamp of the last index write, files modified at or after it are racy
+func (r *Repository) IndexMtime() int64 {
+	info, err := os.Stat(r.Path("index"))
+	if err != nil {
+		return 0
+	}
+	return info.ModTime().Unix()
+}
+
+// reports whether the stat data of fileInfo proves the file still matches entry without rehashing
+func StatClean(e IndexEntry, fileInfo os.FileInfo, racyTime int64) bool {
+	if e.Mtime == 0 && e.MtimeNsec == 0 {
+		return false
+	}
+	// a write in the same second as the index may not have changed mtime, so it must be hashed
+	if e.Mtime >= racyTime {
+		return false
+	}
+	current := EntryFromInfo(e.Path, e.Hash, fileInfo)
+	return current.Size == e.Size &&
+		current.Mtime == e.Mtime &&
+		current.MtimeNsec == e.MtimeNsec &&
+		current.Ctime == e.Ctime &&
+		current.CtimeNsec == e.CtimeNsec &&
+		current.Inode == e.Inode &&
+		current.Mode == NormalizeMode(e.Mode)
+}
+
+// compares a tracked file on disk with its entry, fresh carries updated stat data when only those changed
+func (r *Repository) CompareWorktree(e IndexEntry, racyTime int64) (changed bool, fresh *IndexEntry, err error) {
+	fileInfo, err := os.Lstat(r.abs(e.Path))
+	if err != nil {
+		return false, nil, err
+	}
+	if StatClean(e, fileInfo, racyTime) {
+		return false, nil, nil
+	}
+	if ModeFromInfo(fileInfo) != NormalizeMode(e.Mode) {
+		return true, nil, nil
+	}
+	data, fileInfo, err := r.ReadWorktreeFile(e.Path)
+	if err != nil {
+		return false, nil, err
+	}
+	fileHash, _ := HashObject(data, "blob")
+	if fileHash != e.Hash {
+		return true, nil, nil
+	}
+	entry := EntryFromInfo(e.Path, e.Hash, fileInfo)
+	return false, &entry, nil
+}
+
+func HashObject(data []byte, objType string) (hash string, fullContent []byte) {
+	header := fmt.Sprintf("%s %d\x00", objType, len(data))
+
+	fullContent = append([]byte(header), data...)
+
+	hasher := sha256.New()
+	hasher.Write(fullContent)
+	hash = hex.EncodeToString(hasher.Sum(nil))
+
+	return hash, fullContent
+}
+
+func (r *Repository) HashStore(data []byte, objType string) (string, error) {
+	objectHash, byteContent := HashObject(data, objType)
+	if r.Objects.Has(objectHash) {
+		return objectHash, nil
+	}
+	if err := r.Objects.Put(objectHash, byteContent); err != nil {
+		return "", fmt.Errorf("failed to store object %s: %w", objectHash, err)
+	}
+	return objectHash, nil
+}
+
+func (r *Repository) ExtractObject(hash []byte) ([]byte, error) {
+	_, data, err := r.ReadObject(string(hash))
+	return data, err
+}
+
+// reads an object, returns its type and its content without the header
+func (r *Repository) ReadObject(hash string) (string, []byte, error) {
+	fullContent, err := r.Objects.Get(hash)
+	if err != nil {
+		return "", nil, err
+	}
+	nullIndex := bytes.IndexByte(fullContent, 0)
+	if nullIndex == -1 {
+		return "", nil, fmt.Errorf("invalid object format: no null byte found")
+	}
+	objType, _, _ := strings.Cut(string(fullContent[:nullIndex]), " ")
+	return objType, fullContent[nullIndex+1:], nil
+}
+
+type Commit struct {
+	Tree    string
+	Parents []string
+	// "Name <email> <unix time> <zone>" as in git, only set on commits imported from git
+	Author    string
+	Committer string
+	Message   string
+}
+
+// stores a commit object pointing at tree with the given parents
+func (r *Repository) WriteCommit(tree string, parents []string, message string) (string, error) {
+	return r.WriteCommitObject(&Commit{Tree: tree, Parents: parents, Message: message})
+}
+
+// stores a commit object, author and committer lines are followed by an empty line as in git
+func (r *Repository) WriteCommitObject(c *Commit) (string, error) {
+	var commitContent strings.Builder
+	commitContent.WriteString(fmt.Sprintf("tree %s\n", c.Tree))
+	for _, p := range c.Parents {
+		commitContent.WriteString(fmt.Sprintf("parent %s\n", p))
+	}
+	if c.Author != "" || c.Committer != "" {
+		commitContent.WriteString(fmt.Sprintf("author %s\ncommitter %s\n", c.Author, c.Committer))
+	}
+	// the empty line ends the headers, so a message cannot be read as one
+	commitContent.WriteString("\n")
+	commitContent.WriteString(c.Message)
+
+	commitHash, err := r.HashStore([]byte(commitContent.String()), "commit")
+	if err != nil {
+		return "", fmt.Errorf("failed to create commit object: %w", err)
+	}
+	return commitHash, nil
+}
+
+// parses the header lines of a commit object, everything after them is the message
+func (r *Repository) ReadCommit(hash string) (*Commit, error) {
+	content, err := r.ExtractObject([]byte(hash))
+	if err != nil {
+		return nil, fmt.Errorf("error extracting commit %s: %w", hash, err)
+	}
+	return ParseCommit(content), nil
+}
+
+// ParseCommit splits a commit object into its headers and message; the headers end at the first empty line,
+// in commits written before there always was one they end at the first line that is not a header
+func ParseCommit(content []byte) *Commit {
+	c := &Commit{}
+	if headers, message, ok := strings.Cut(string(content), "\n\n"); ok {
+		lines := strings.Split(headers, "\n")
+		if !slices.ContainsFunc(lines, func(line string) bool { return !parseCommitHeader(&Commit{}, line) }) {
+			for _, line := range lines {
+				parseCommitHeader(c, line)
+			}
+			c.Message = message
+			return c
+		}
+	}
+	rest := string(content)
+	for rest != "" {
+		line, after, _ := strings.Cut(rest, "\n")
+		if !parseCommitHeader(c, line) {
+			break
+		}
+		rest = after
+	}
+	c.Message = strings.TrimPrefix(rest, "\n")
+	return c
+}
+
+// sets the field a header line holds, false for a line that is not a header
+func parseCommitHeader(c *Commit, line string) bool {
+	if v, ok := strings.CutPrefix(line, "tree "); ok {
+		c.Tree = v
+	} else if v, ok := strings.CutPrefix(line, "parent "); ok {
+		c.Parents = append(c.Parents, v)
+	} else if v, ok := strings.CutPrefix(line, "author "); ok {
+		c.Author = v
+	} else if v, ok := strings.CutPrefix(line, "committer "); ok {
+		c.Committer = v
+	} else {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package gitre
+
+import (
+	"bufio"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// pack stream, zlib compressed as a whole:
+//
+//	"GPACK <version> <count>\n"
+//	per object: "<hash> <length>\n" followed by the full object, header included
+const (
+	packMagic   = "GPACK"
+	packVersion = 1
+	// limits on what a pack may announce, packs come from the network
+	maxPackObjects    = 1 << 24
+	maxPackObjectSize = 1 << 30
+)
+
+// WritePack writes the given objects to w as a pack stream, in order
+func (r *Repository) WritePack(w io.Writer, hashes []string) error {
+	zw := zlib.NewWriter(w)
+	fmt.Fprintf(zw, "%s %d %d\n", packMagic, packVersion, len(hashes))
+	for _, hash := range hashes {
+		content, err := r.Objects.Get(hash)
+		if err != nil {
+			return fmt.Errorf("failed to read object %s: %w", hash, err)
+		}
+		fmt.Fprintf(zw, "%s %d\n", hash, len(content))
+		if _, err := zw.Write(content); err != nil {
+			return fmt.Errorf("failed to write pack: %w", err)
+		}
+	}
+	return zw.Close()
+}
+
+// ReadPack stores every object of a pack stream in store after checking its hash, returns how many it held
+func ReadPack(rd io.Reader, store ObjectStore) (int, error) {
+	zr, err := zlib.NewReader(rd)
+	if err != nil {
+		return 0, fmt.Errorf("invalid pack: %w", err)
+	}
+	defer zr.Close()
+	br := bufio.NewReader(zr)
+
+	header, err := br.ReadString('\n')
+	if err != nil {
+		return 0, fmt.Errorf("invalid pack: missing header")
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 3 || fields[0] != packMagic {
+		return 0, fmt.Errorf("invalid pack: bad header %q", strings.TrimSpace(header))
+	}
+	if fields[1] != strconv.Itoa(packVersion) {
+		return 0, fmt.Errorf("unsupported pack version %s", fields[1])
+	}
+	count, err := strconv.Atoi(fields[2])
+	if err != nil || count < 0 || count > maxPackObjects {
+		return 0, fmt.Errorf("invalid pack: bad object count %q", fields[2])
+	}
+
+	for i := range count {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return i, fmt.Errorf("invalid pack: truncated after %d objects", i)
+		}
+		hash, lengthField, ok := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+		length, err := strconv.Atoi(lengthField)
+		if !ok || err != nil || length < 0 || !IsObjectHash(hash) {
+			return i, fmt.Errorf("invalid pack: bad object line %q", strings.TrimSpace(line))
+		}
+		if length > maxPackObjectSize {
+			return i, fmt.Errorf("invalid pack: object %s is larger than %d bytes", hash, maxPackObjectSize)
+		}
+		// the buffer grows with the data actually received rather than the announced length
+		content, err := io.ReadAll(io.LimitReader(br, int64(length)))
+		if err != nil || len(content) != length {
+			return i, fmt.Errorf("invalid pack: truncated object %s", hash)
+		}
+		sum := sha256.Sum256(content)
+		if hex.EncodeToString(sum[:]) != hash {
+			return i, fmt.Errorf("invalid pack: object %s does not match its hash", hash)
+		}
+		if err := store.Put(hash, content); err != nil {
+			return i, fmt.Errorf("failed to store object %s: %w", hash, err)
+		}
+	}
+	return count, nil
+}
+
+// MissingObjects lists the objects reachable from wants that a side holding haves lacks, in the order
+// ReachableObjects gives; haves this repository does not know are ignored
+func (r *Repository) MissingObjects(wants []string, haves []string) ([]string, error) {
+	var known []string
+	for _, h := range haves {
+		if h != "" && r.Objects.Has(h) {
+			known = append(known, h)
+		}
+	}
+	common, err := r.ReachableObjects(known, nil)
+	if err != nil {
+		return nil, err
+	}
+	have := map[string]bool{}
+	for _, h := range common {
+		have[h] = true
+	}
+	return r.ReachableObjects(wants, func(hash string) bool { return have[hash] })
+}
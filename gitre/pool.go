@@ -0,0 +1,43 @@
+package gitre
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// number of workers for hashing, core.workers in the config or GOMAXPROCS
+func (r *Repository) Workers() int {
+	if cfg, err := r.ReadConfig(); err == nil {
+		if n, err := strconv.Atoi(cfg.Get("core.workers")); err == nil && n > 0 {
+			return n
+		}
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// runs fn over items on a pool of at most workers goroutines, results and errors keep the order of items
+func RunParallel[T any, R any](workers int, items []T, fn func(T) (R, error)) ([]R, []error) {
+	results := make([]R, len(items))
+	errs := make([]error, len(items))
+
+	workers = min(max(workers, 1), len(items))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = fn(items[i])
+			}
+		}()
+	}
+	for i := range items {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results, errs
+}
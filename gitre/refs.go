@@ -0,0 +1,304 @@
+package gitre
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reads HEAD, returns the ref it points to (empty when detached) and the commit it resolves to
+func (r *Repository) ReadHead() (string, string, error) {
+	head, err := os.ReadFile(r.Path("HEAD"))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	content := strings.TrimSpace(string(head))
+	ref, ok := strings.CutPrefix(content, "ref: ")
+	if !ok {
+		return "", content, nil
+	}
+	hash, err := r.ReadRef(ref)
+	if err != nil {
+		return "", "", err
+	}
+	return ref, hash, nil
+}
+
+// reads the hash stored in a ref, empty if the ref does not exist yet
+func (r *Repository) ReadRef(refPath string) (string, error) {
+	data, err := os.ReadFile(r.Path(refPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read ref %s: %w", refPath, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// moves whatever HEAD points at: the current branch, or HEAD itself when detached
+func (r *Repository) UpdateHead(hash string) error {
+	ref, _, err := r.ReadHead()
+	if err != nil {
+		return err
+	}
+	if ref == "" {
+		return os.WriteFile(r.Path("HEAD"), []byte(hash), 0644)
+	}
+	return r.UpdateRef(ref, hash)
+}
+
+type ReflogEntry struct {
+	Old     string
+	New     string
+	Time    int64
+	Message string
+}
+
+var ZeroHash = strings.Repeat("0", 64)
+
+// appends a line to .gitre/logs/<ref>: "<old> <new> <unix time>\t<message>"
+func (r *Repository) AppendReflog(refPath string, oldHash string, newHash string, message string) error {
+	if oldHash == "" {
+		oldHash = ZeroHash
+	}
+	logPath := r.Path("logs", refPath)
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return fmt.Errorf("failed to create reflog directory: %w", err)
+	}
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open reflog: %w", err)
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "%s %s %d\t%s\n", oldHash, newHash, time.Now().Unix(), strings.ReplaceAll(message, "\n", " "))
+	return err
+}
+
+// reads the reflog of a ref, oldest entry first
+func (r *Repository) ReadReflog(refPath string) ([]ReflogEntry, error) {
+	data, err := os.ReadFile(r.Path("logs", refPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read reflog: %w", err)
+	}
+	var entries []ReflogEntry
+	for line := range strings.SplitSeq(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		header, message, _ := strings.Cut(line, "\t")
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid reflog line: %q", line)
+		}
+		ts, _ := strconv.ParseInt(fields[2], 10, 64)
+		entries = append(entries, ReflogEntry{Old: fields[0], New: fields[1], Time: ts, Message: message})
+	}
+	return entries, nil
+}
+
+func (r *Repository) WriteReflog(refPath string, entries []ReflogEntry) error {
+	var b strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&b, "%s %s %d\t%s\n", e.Old, e.New, e.Time, e.Message)
+	}
+	logPath := r.Path("logs", refPath)
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return fmt.Errorf("failed to create reflog directory: %w", err)
+	}
+	return os.WriteFile(logPath, []byte(b.String()), 0644)
+}
+
+// resolves HEAD, branch and tag names, full or abbreviated hashes, with ~N and ^N suffixes
+func (r *Repository) ResolveRev(rev string) (string, error) {
+	base := rev
+	suffix := ""
+	if i := strings.IndexAny(rev, "~^"); i >= 0 {
+		base, suffix = rev[:i], rev[i:]
+	}
+
+	hash, err := r.resolveBase(base)
+	if err != nil {
+		return "", err
+	}
+
+	for len(suffix) > 0 {
+		op := suffix[0]
+		suffix = suffix[1:]
+		digits := 0
+		for digits < len(suffix) && suffix[digits] >= '0' && suffix[digits] <= '9' {
+			digits++
+		}
+		n := 1
+		if digits > 0 {
+			n, _ = strconv.Atoi(suffix[:digits])
+			suffix = suffix[digits:]
+		}
+
+		if op == '~' {
+			for range n {
+				c, err := r.ReadCommit(hash)
+				if err != nil {
+					return "", err
+				}
+				if len(c.Parents) == 0 {
+					return "", fmt.Errorf("revision %s does not exist", rev)
+				}
+				hash = c.Parents[0]
+			}
+			continue
+		}
+		if n == 0 {
+			continue
+		}
+		c, err := r.ReadCommit(hash)
+		if err != nil {
+			return "", err
+		}
+		if len(c.Parents) < n {
+			return "", fmt.Errorf("revision %s does not exist", rev)
+		}
+		hash = c.Parents[n-1]
+	}
+
+	return hash, nil
+}
+
+func (r *Repository) resolveBase(name string) (string, error) {
+	if name == "HEAD" || name == "@" {
+		_, hash, err := r.ReadHead()
+		if err != nil {
+			return "", err
+		}
+		if hash == "" {
+			return "", fmt.Errorf("HEAD does not point to a commit yet")
+		}
+		return hash, nil
+	}
+
+	// MERGE_HEAD, FETCH_HEAD and the like; FETCH_HEAD lists a fetched ref per line and the first one is used
+	if strings.HasSuffix(name, "_HEAD") && strings.ToUpper(name) == name {
+		if data, err := os.ReadFile(r.Path(name)); err == nil {
+			if fields := strings.Fields(string(data)); len(fields) > 0 {
+				return fields[0], nil
+			}
+		}
+	}
+
+	if ref, n, ok := ParseReflogSelector(name); ok {
+		entries, err := r.ReadReflog(ref)
+		if err != nil {
+			return "", err
+		}
+		if n >= len(entries) {
+			return "", fmt.Errorf("log for '%s' only has %d entries", ref, len(entries))
+		}
+		return entries[len(entries)-1-n].New, nil
+	}
+
+	for _, ref := range []string{name, "refs/" + name, "refs/heads/" + name, "refs/tags/" + name, "refs/remotes/" + name} {
+		if strings.HasPrefix(ref, "refs/") {
+			hash, err := r.ReadRef(ref)
+			if err != nil {
+				return "", err
+			}
+			if hash != "" {
+				return hash, nil
+			}
+		}
+	}
+
+	if len(name) >= 4 && IsHex(name) {
+		return r.expandHash(name)
+	}
+
+	return "", fmt.Errorf("unknown revision: %s", name)
+}
+
+// splits name@{n} into its ref and n, newest entry being 0
+func ParseReflogSelector(name string) (string, int, bool) {
+	base, selector, ok := strings.Cut(name, "@{")
+	if !ok || !strings.HasSuffix(selector, "}") {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(selector, "}"))
+	if err != nil || n < 0 {
+		return "", 0, false
+	}
+	switch {
+	case base == "stash":
+		base = "refs/stash"
+	case !strings.HasPrefix(base, "refs/"):
+		base = "refs/heads/" + base
+	}
+	return base, n, true
+}
+
+// finds the single object whose hash starts with prefix, stores without a prefix lookup are scanned
+func (r *Repository) expandHash(prefix string) (string, error) {
+	prefix = strings.ToLower(prefix)
+	var matches []string
+	if lister, ok := r.Objects.(PrefixLister); ok {
+		var err error
+		if matches, err = lister.HashesWithPrefix(prefix); err != nil {
+			return "", err
+		}
+	} else {
+		err := r.Objects.Iterate(func(hash string) error {
+			if strings.HasPrefix(hash, prefix) {
+				matches = append(matches, hash)
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("unknown revision: %s", prefix)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("ambiguous revision: %s", prefix)
+}
+
+// CheckRefName rejects ref names that could leave the refs directory or clash with lock files, in the spirit of
+// git check-ref-format
+func CheckRefName(name string) error {
+	invalid := fmt.Errorf("invalid ref name %q", name)
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, "@{") {
+		return invalid
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(` ~^:?*[\`, c) {
+			return invalid
+		}
+	}
+	for part := range strings.SplitSeq(name, "/") {
+		if part == "" || strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".lock") {
+			return invalid
+		}
+	}
+	return nil
+}
+
+// IsObjectHash reports whether s is a full object name
+func IsObjectHash(s string) bool {
+	return len(s) == len(ZeroHash) && IsHex(s)
+}
+
+func IsHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
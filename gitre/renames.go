@@ -0,0 +1,202 @@
+package gitre
+
+import (
+	"path"
+	"sort"
+	"strconv"
+)
+
+const defaultRenameThreshold = 50
+
+// a changed path between two trees, OldPath and NewPath differ for renames and copies
+type FileChange struct {
+	Status  string // A, D, M, R or C
+	OldPath string
+	NewPath string
+	Old     IndexEntry
+	New     IndexEntry
+	Score   int // similarity percentage for renames and copies
+}
+
+type RenameOptions struct {
+	Renames     bool
+	Copies      bool
+	Threshold   int
+	NewFromDisk bool // new side entries describe working tree files, read them from disk
+}
+
+// rename options from diff.renames and diff.renameThreshold in the config
+func (r *Repository) DefaultRenameOptions() RenameOptions {
+	opts := RenameOptions{Renames: true, Threshold: defaultRenameThreshold}
+	cfg, err := r.ReadConfig()
+	if err != nil {
+		return opts
+	}
+	switch cfg.Get("diff.renames") {
+	case "false":
+		opts.Renames = false
+	case "copies", "copy":
+		opts.Copies = true
+	}
+	if n, err := strconv.Atoi(cfg.Get("diff.renameThreshold")); err == nil && n >= 0 && n <= 100 {
+		opts.Threshold = n
+	}
+	return opts
+}
+
+// lists the changes from old to new sorted by path, pairing deletions and additions into renames
+func (r *Repository) DiffTrees(old map[string]IndexEntry, new map[string]IndexEntry, opts RenameOptions) []FileChange {
+	var changes []FileChange
+	added := map[string]IndexEntry{}
+	deleted := map[string]IndexEntry{}
+	for path, o := range old {
+		n, ok := new[path]
+		if !ok {
+			deleted[path] = o
+		} else if n.Hash != o.Hash || NormalizeMode(n.Mode) != NormalizeMode(o.Mode) {
+			changes = append(changes, FileChange{Status: "M", OldPath: path, NewPath: path, Old: o, New: n})
+		}
+	}
+	for path, n := range new {
+		if _, ok := old[path]; !ok {
+			added[path] = n
+		}
+	}
+
+	if opts.Renames || opts.Copies {
+		changes = append(changes, r.detectRenames(old, deleted, added, opts)...)
+	}
+	for path, o := range deleted {
+		changes = append(changes, FileChange{Status: "D", OldPath: path, NewPath: path, Old: o})
+	}
+	for path, n := range added {
+		changes = append(changes, FileChange{Status: "A", OldPath: path, NewPath: path, New: n})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].NewPath != changes[j].NewPath {
+			return changes[i].NewPath < changes[j].NewPath
+		}
+		return changes[i].OldPath < changes[j].OldPath
+	})
+	return changes
+}
+
+// pairs entries of deleted with entries of added, exact hash matches first, then by content similarity;
+// paired entries are removed from both maps, copies are looked for among all of old
+func (r *Repository) detectRenames(old map[string]IndexEntry, deleted map[string]IndexEntry, added map[string]IndexEntry, opts RenameOptions) []FileChange {
+	var changes []FileChange
+	pair := func(status string, from IndexEntry, to IndexEntry, score int) {
+		changes = append(changes, FileChange{Status: status, OldPath: from.Path, NewPath: to.Path, Old: from, New: to, Score: score})
+		delete(added, to.Path)
+		if status == "R" {
+			delete(deleted, from.Path)
+		}
+	}
+
+	if opts.Renames {
+		for _, to := range SortedEntries(added) {
+			var best *IndexEntry
+			for _, from := range SortedEntries(deleted) {
+				if from.Hash != to.Hash {
+					continue
+				}
+				if best == nil || path.Base(from.Path) == path.Base(to.Path) {
+					best = &from
+				}
+			}
+			if best != nil {
+				pair("R", *best, to, 100)
+			}
+		}
+	}
+
+	type candidate struct {
+		from, to IndexEntry
+		score    int
+	}
+	similar := func(sources map[string]IndexEntry) []candidate {
+		var candidates []candidate
+		contents := map[string][]byte{}
+		load := func(e IndexEntry, fromDisk bool) []byte {
+			key := e.Path + "\x00" + e.Hash
+			if data, ok := contents[key]; ok {
+				return data
+			}
+			data, _ := r.EntryContent(e, fromDisk)
+			contents[key] = data
+			return data
+		}
+		for _, to := range SortedEntries(added) {
+			for _, from := range SortedEntries(sources) {
+				if from.Hash == to.Hash {
+					candidates = append(candidates, candidate{from, to, 100})
+					continue
+				}
+				score := similarity(load(from, false), load(to, opts.NewFromDisk))
+				if score >= opts.Threshold {
+					candidates = append(candidates, candidate{from, to, score})
+				}
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+		return candidates
+	}
+
+	if opts.Renames {
+		for _, c := range similar(deleted) {
+			_, fromFree := deleted[c.from.Path]
+			_, toFree := added[c.to.Path]
+			if fromFree && toFree {
+				pair("R", c.from, c.to, c.score)
+			}
+		}
+	}
+	if opts.Copies {
+		for _, c := range similar(old) {
+			if _, toFree := added[c.to.Path]; toFree {
+				pair("C", c.from, c.to, c.score)
+			}
+		}
+	}
+	return changes
+}
+
+// content of an entry from the object store, or from the working tree
+func (r *Repository) EntryContent(e IndexEntry, fromDisk bool) ([]byte, error) {
+	if fromDisk {
+		data, _, err := r.ReadWorktreeFile(e.Path)
+		return data, err
+	}
+	return r.ExtractObject([]byte(e.Hash))
+}
+
+// percentage of lines the two contents share
+func similarity(a []byte, b []byte) int {
+	if len(a) == 0 && len(b) == 0 {
+		return 100
+	}
+	linesA := SplitLines(a)
+	linesB := SplitLines(b)
+	counts := map[string]int{}
+	for _, l := range linesA {
+		counts[l]++
+	}
+	common := 0
+	for _, l := range linesB {
+		if counts[l] > 0 {
+			counts[l]--
+			common++
+		}
+	}
+	return common * 200 / (len(linesA) + len(linesB))
+}
+
+func SortedEntries(entries map[string]IndexEntry) []IndexEntry {
+	list := make([]IndexEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
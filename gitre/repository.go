@@ -0,0 +1,161 @@
+// Package gitre reads and writes gitre repositories: objects, the index, refs, commits, status, diffs and
+// history. The gitre command is a thin layer over it.
+package gitre
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DirName is the name of the repository directory at the top of a working tree
+const DirName = ".gitre"
+
+// Repository is an opened repository, both paths are absolute and WorkTree is empty for a bare repository
+type Repository struct {
+	GitDir   string
+	WorkTree string
+	Objects  ObjectStore // loose objects below GitDir unless replaced
+}
+
+// Open finds the repository containing path by walking up to the first directory holding .gitre, a path
+// that is itself a repository directory opens it as a bare repository
+func Open(path string) (*Repository, error) {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %s: %w", path, err)
+	}
+	if isRepoDir(dir) {
+		return OpenDir(dir, "")
+	}
+	for {
+		if info, err := os.Stat(filepath.Join(dir, DirName)); err == nil && info.IsDir() {
+			return OpenDir(filepath.Join(dir, DirName), dir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, fmt.Errorf("not a gitre repository (or any of the parent directories): %s", DirName)
+		}
+		dir = parent
+	}
+}
+
+// OpenRemote opens the repository at path without looking at its parents: a working tree holding .gitre or
+// a bare repository directory
+func OpenRemote(path string) (*Repository, error) {
+	if info, err := os.Stat(filepath.Join(path, DirName)); err == nil && info.IsDir() {
+		return OpenDir(filepath.Join(path, DirName), path)
+	}
+	if isRepoDir(path) {
+		return OpenDir(path, "")
+	}
+	return nil, fmt.Errorf("'%s' does not appear to be a gitre repository", path)
+}
+
+// OpenDir opens the repository directory gitDir with its working tree at workTree
+func OpenDir(gitDir string, workTree string) (*Repository, error) {
+	absDir, err := filepath.Abs(gitDir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository directory %s: %w", gitDir, err)
+	}
+	if info, err := os.Stat(absDir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("not a gitre repository: %s", gitDir)
+	}
+	absTree := ""
+	if workTree != "" {
+		if absTree, err = filepath.Abs(workTree); err != nil {
+			return nil, fmt.Errorf("invalid working tree %s: %w", workTree, err)
+		}
+	}
+	return &Repository{GitDir: absDir, WorkTree: absTree, Objects: NewLooseStore(filepath.Join(absDir, "objects"))}, nil
+}
+
+// Init creates the repository directory gitDir for workTree, or fills in whatever an existing one is missing;
+// an empty workTree makes a bare repository
+func Init(gitDir string, workTree string) (*Repository, error) {
+	var dirPerm os.FileMode = 0700
+	var filePerm os.FileMode = 0644
+	var err error
+	if err = os.MkdirAll(gitDir, dirPerm); err != nil {
+		return nil, fmt.Errorf("failed to create .gitre directory: %w", err)
+	}
+	r, err := OpenDir(gitDir, workTree)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(r.Path("refs", "heads"), dirPerm); err != nil {
+		return nil, fmt.Errorf("failed to create refs/heads directory: %w", err)
+	}
+	if err = os.MkdirAll(r.Path("objects"), dirPerm); err != nil {
+		return nil, fmt.Errorf("failed to create objects directory: %w", err)
+	}
+	if err = os.MkdirAll(r.Path("refs", "tags"), dirPerm); err != nil {
+		return nil, fmt.Errorf("failed to create refs/tags directory: %w", err)
+	}
+	if err = os.MkdirAll(r.Path("info"), dirPerm); err != nil {
+		return nil, fmt.Errorf("failed to create info directory: %w", err)
+	}
+
+	if _, err = os.Stat(r.Path("info", "exclude")); os.IsNotExist(err) {
+		var excludeContent string = "# per-repository ignore rules that are not committed, same syntax as .gitreignore\n"
+		if err = os.WriteFile(r.Path("info", "exclude"), []byte(excludeContent), filePerm); err != nil {
+			return nil, fmt.Errorf("failed to create info/exclude file: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to check info/exclude file: %w", err)
+	}
+
+	if _, err = os.Stat(r.Path("config")); os.IsNotExist(err) {
+		if err = os.WriteFile(r.Path("config"), nil, filePerm); err != nil {
+			return nil, fmt.Errorf("failed to create config file: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to check config file: %w", err)
+	}
+
+	if _, err = os.Stat(r.Path("index")); os.IsNotExist(err) {
+		emptyIndex, _ := encodeIndex(nil)
+		if err = os.WriteFile(r.Path("index"), emptyIndex, filePerm); err != nil {
+			return nil, fmt.Errorf("failed to create index file: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to check index file: %w", err)
+	}
+
+	if _, err = os.Stat(r.Path("HEAD")); os.IsNotExist(err) {
+		if err = os.WriteFile(r.Path("HEAD"), []byte("ref: refs/heads/main\n"), filePerm); err != nil {
+			return nil, fmt.Errorf("failed to create HEAD file: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to check HEAD file: %w", err)
+	}
+
+	return r, nil
+}
+
+// IsBare reports whether the repository has no working tree
+func (r *Repository) IsBare() bool {
+	return r.WorkTree == ""
+}
+
+// a repository directory holds HEAD and objects directly
+func isRepoDir(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); err != nil {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(dir, "objects"))
+	return err == nil && info.IsDir()
+}
+
+// Path is the location of a file inside the repository directory
+func (r *Repository) Path(elem ...string) string {
+	return filepath.Join(append([]string{r.GitDir}, elem...)...)
+}
+
+// location of a working tree path, given relative to the top of the working tree
+func (r *Repository) abs(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(r.WorkTree, p)
+}
@@ -0,0 +1,17 @@
+//go:build linux
+
+package gitre
+
+import (
+	"os"
+	"syscall"
+)
+
+// inode and ctime of a file, zero when the platform does not provide them
+func statExtra(info os.FileInfo) (inode uint64, ctimeSec int64, ctimeNsec int64) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, 0
+	}
+	return uint64(st.Ino), int64(st.Ctim.Sec), int64(st.Ctim.Nsec)
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package gitre
+
+import "os"
+
+// inode and ctime of a file, zero when the platform does not provide them
+func statExtra(info os.FileInfo) (inode uint64, ctimeSec int64, ctimeNsec int64) {
+	return 0, 0, 0
+}
@@ -0,0 +1,293 @@
+package gitre
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const statusVersion = 1
+
+type StatusEntry struct {
+	Path     string `json:"path"`
+	OrigPath string `json:"orig_path,omitempty"` // source of a staged rename
+	Score    int    `json:"score,omitempty"`     // similarity of a staged rename
+	Index    string `json:"index"`               // X: M modified, A added, D deleted, R renamed, U unmerged, ? untracked, ! ignored, space unchanged
+	Worktree string `json:"worktree"`            // Y: M modified, D deleted, U unmerged, ? untracked, ! ignored, space unchanged
+
+	// modes and hashes of a tracked path, the HEAD side of a rename is its source; a side the path is missing
+	// from has mode 000000 and the zero hash
+	HeadMode     string `json:"head_mode,omitempty"`
+	IndexMode    string `json:"index_mode,omitempty"`
+	WorktreeMode string `json:"worktree_mode,omitempty"`
+	HeadHash     string `json:"head_hash,omitempty"`
+	IndexHash    string `json:"index_hash,omitempty"`
+	// base, ours and theirs sides of an unmerged path
+	Stages []StatusStage `json:"stages,omitempty"`
+}
+
+type StatusStage struct {
+	Mode string `json:"mode"`
+	Hash string `json:"hash"`
+}
+
+// everything status reports, every output format is rendered from it
+type StatusResult struct {
+	Version  int           `json:"version"`
+	Branch   string        `json:"branch"` // empty when detached
+	Head     string        `json:"head"`   // empty before the first commit
+	Upstream string        `json:"upstream,omitempty"`
+	Ahead    int           `json:"ahead"`
+	Behind   int           `json:"behind"`
+	Entries  []StatusEntry `json:"entries"`
+}
+
+// compares HEAD, index and working tree
+func (r *Repository) Status(showIgnored bool) (*StatusResult, error) {
+	result := &StatusResult{Version: statusVersion}
+	ref, headHash, err := r.ReadHead()
+	if err != nil {
+		return nil, err
+	}
+	result.Branch = strings.TrimPrefix(ref, "refs/heads/")
+	result.Head = headHash
+	if upstream := r.BranchUpstream(result.Branch); upstream != "" {
+		if upstreamHash, _ := r.ReadRef(upstream); upstreamHash != "" {
+			result.Upstream = strings.TrimPrefix(upstream, "refs/remotes/")
+			if result.Ahead, result.Behind, err = r.AheadBehind(headHash, upstreamHash); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	headEntries, err := r.ReadCommitTree(headHash)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := r.ReadIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index: %w", err)
+	}
+
+	entries := map[string]*StatusEntry{}
+	get := func(path string) *StatusEntry {
+		if e, ok := entries[path]; ok {
+			return e
+		}
+		e := &StatusEntry{Path: path, Index: " ", Worktree: " "}
+		entries[path] = e
+		return e
+	}
+
+	for _, e := range idx.Entries {
+		committed, ok := headEntries[e.Path]
+		if !ok {
+			get(e.Path).Index = "A"
+		} else if committed.Hash != e.Hash || committed.Mode != e.Mode {
+			get(e.Path).Index = "M"
+		}
+	}
+	deleted := map[string]IndexEntry{}
+	added := map[string]IndexEntry{}
+	for path, e := range headEntries {
+		if _, ok := idx.Get(path); !ok {
+			get(path).Index = "D"
+			deleted[path] = e
+		}
+	}
+	for path, e := range entries {
+		if e.Index == "A" {
+			added[path], _ = idx.Get(path)
+		}
+	}
+	if opts := r.DefaultRenameOptions(); opts.Renames {
+		opts.Copies = false
+		for _, rename := range r.detectRenames(headEntries, deleted, added, opts) {
+			delete(entries, rename.OldPath)
+			e := get(rename.NewPath)
+			e.Index, e.OrigPath, e.Score = "R", rename.OldPath, rename.Score
+		}
+	}
+
+	// tracked files stay visible even when an ignore rule hides them from the walk
+	type comparison struct {
+		changed bool
+		fresh   *IndexEntry
+	}
+	racyTime := r.IndexMtime()
+	compared, errs := RunParallel(r.Workers(), idx.Entries, func(entry IndexEntry) (comparison, error) {
+		changed, fresh, err := r.CompareWorktree(entry, racyTime)
+		return comparison{changed, fresh}, err
+	})
+	var readErrs []error
+	refreshed := false
+	for i, entry := range idx.Entries {
+		switch {
+		case os.IsNotExist(errs[i]):
+			get(entry.Path).Worktree = "D"
+		case errs[i] != nil:
+			readErrs = append(readErrs, fmt.Errorf("failed to check %s: %w", entry.Path, errs[i]))
+		case compared[i].changed:
+			get(entry.Path).Worktree = "M"
+		case compared[i].fresh != nil:
+			idx.Entries[i] = *compared[i].fresh
+			refreshed = true
+		}
+	}
+	if len(readErrs) > 0 {
+		return nil, errors.Join(readErrs...)
+	}
+	// remember stat data of files that were hashed but unchanged so the next run can skip them
+	if refreshed {
+		if err := idx.Write(); err != nil {
+			return nil, err
+		}
+	}
+
+	ignores := r.Ignores()
+	var ignored, diskFiles []string
+	if showIgnored {
+		diskFiles, err = r.WalkDir("./", ignores, &ignored)
+	} else {
+		diskFiles, err = r.TraverseDir("./", ignores)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range diskFiles {
+		if _, ok := idx.Get(file); !ok {
+			e := get(file)
+			e.Index, e.Worktree = "?", "?"
+		}
+	}
+	for _, file := range ignored {
+		if _, ok := idx.Get(file); !ok {
+			e := get(file)
+			e.Index, e.Worktree = "!", "!"
+		}
+	}
+
+	conflicts, err := r.ReadConflicts()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range conflicts {
+		e := get(path)
+		e.Index, e.Worktree, e.OrigPath = "U", "U", ""
+	}
+
+	side := func(tree map[string]IndexEntry, path string) (string, string) {
+		if e, ok := tree[path]; ok {
+			return fmt.Sprintf("%06o", NormalizeMode(e.Mode)), e.Hash
+		}
+		return "000000", ZeroHash
+	}
+	var stages [3]map[string]IndexEntry
+	staged := false
+	for _, e := range entries {
+		if e.Index == "?" || e.Index == "!" {
+			continue
+		}
+		headPath := e.Path
+		if e.OrigPath != "" {
+			headPath = e.OrigPath
+		}
+		e.HeadMode, e.HeadHash = side(headEntries, headPath)
+		e.IndexMode, e.IndexHash = "000000", ZeroHash
+		if entry, ok := idx.Get(e.Path); ok {
+			e.IndexMode, e.IndexHash = fmt.Sprintf("%06o", NormalizeMode(entry.Mode)), entry.Hash
+		}
+		e.WorktreeMode = "000000"
+		if info, err := os.Lstat(r.abs(e.Path)); err == nil {
+			e.WorktreeMode = fmt.Sprintf("%06o", ModeFromInfo(info))
+		}
+		if e.Index != "U" {
+			continue
+		}
+		if !staged {
+			if stages, err = r.conflictStages(headHash, headEntries); err != nil {
+				return nil, err
+			}
+			staged = true
+		}
+		for _, tree := range stages {
+			mode, hash := side(tree, e.Path)
+			e.Stages = append(e.Stages, StatusStage{mode, hash})
+		}
+	}
+
+	result.Entries = []StatusEntry{}
+	for _, e := range entries {
+		result.Entries = append(result.Entries, *e)
+	}
+	sort.Slice(result.Entries, func(i, j int) bool { return result.Entries[i].Path < result.Entries[j].Path })
+	return result, nil
+}
+
+// base, ours and theirs trees of the merge, cherry-pick or revert that stopped on conflicts; a side that cannot
+// be told is empty
+func (r *Repository) conflictStages(head string, ours map[string]IndexEntry) ([3]map[string]IndexEntry, error) {
+	stages := [3]map[string]IndexEntry{{}, ours, {}}
+	var base, theirs string
+	if mergeHead, err := r.ReadRef("MERGE_HEAD"); err != nil {
+		return stages, err
+	} else if mergeHead != "" {
+		if base, err = r.MergeBase(head, mergeHead); err != nil {
+			return stages, err
+		}
+		theirs = mergeHead
+	}
+	for _, name := range []string{"CHERRY_PICK_HEAD", "REVERT_HEAD"} {
+		picked, err := r.ReadRef(name)
+		if err != nil {
+			return stages, err
+		}
+		if picked == "" || theirs != "" {
+			continue
+		}
+		c, err := r.ReadCommit(picked)
+		if err != nil {
+			return stages, err
+		}
+		parent := ""
+		if len(c.Parents) > 0 {
+			parent = c.Parents[0]
+		}
+		// a revert applies the change from the commit back to its parent
+		base, theirs = parent, picked
+		if name == "REVERT_HEAD" {
+			base, theirs = picked, parent
+		}
+	}
+	var err error
+	if base != "" {
+		if stages[0], err = r.ReadCommitTree(base); err != nil {
+			return stages, err
+		}
+	}
+	if theirs != "" {
+		if stages[2], err = r.ReadCommitTree(theirs); err != nil {
+			return stages, err
+		}
+	}
+	return stages, nil
+}
+
+// upstream ref of a branch as configured by branch.<name>.remote and branch.<name>.merge
+func (r *Repository) BranchUpstream(branch string) string {
+	if branch == "" {
+		return ""
+	}
+	cfg, err := r.ReadConfig()
+	if err != nil {
+		return ""
+	}
+	remote := cfg.Get("branch." + branch + ".remote")
+	merge := cfg.Get("branch." + branch + ".merge")
+	if remote == "" || merge == "" {
+		return ""
+	}
+	return "refs/remotes/" + remote + "/" + strings.TrimPrefix(merge, "refs/heads/")
+}
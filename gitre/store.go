@@ -0,0 +1,224 @@
+package gitre
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ObjectStore keeps objects by hash; content is the full object, "<type> <len>\x00" header included
+type ObjectStore interface {
+	Has(hash string) bool
+	Get(hash string) ([]byte, error)
+	// Put stores content under hash, storing an object that is already present is not an error
+	Put(hash string, content []byte) error
+	// Iterate calls fn for every stored hash, stopping at the first error
+	Iterate(fn func(hash string) error) error
+}
+
+// PrefixLister is implemented by stores that can find the hashes starting with a prefix without visiting every
+// object; prefix is lowercase hex of at least two digits
+type PrefixLister interface {
+	HashesWithPrefix(prefix string) ([]string, error)
+}
+
+// LooseStore keeps every object zlib-compressed in its own file, objects/<first two hex digits>/<rest>
+type LooseStore struct {
+	Dir string
+}
+
+func NewLooseStore(dir string) *LooseStore {
+	return &LooseStore{Dir: dir}
+}
+
+func (s *LooseStore) path(hash string) (string, error) {
+	if len(hash) < 3 || !IsHex(hash) {
+		return "", fmt.Errorf("invalid object hash %q", hash)
+	}
+	return filepath.Join(s.Dir, hash[:2], hash[2:]), nil
+}
+
+func (s *LooseStore) Has(hash string) bool {
+	path, err := s.path(hash)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+func (s *LooseStore) Get(hash string) ([]byte, error) {
+	path, err := s.path(hash)
+	if err != nil {
+		return nil, err
+	}
+	compressedData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not find object: %w", err)
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(compressedData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize zlib reader: %w", err)
+	}
+	defer reader.Close()
+
+	var out bytes.Buffer
+	_, err = out.ReadFrom(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress data: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+func (s *LooseStore) Put(hash string, content []byte) error {
+	objectPath, err := s.path(hash)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(objectPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if _, err := os.Stat(objectPath); err == nil {
+		return nil
+	}
+
+	// write to a temporary file first so concurrent writers of the same object never see a partial one
+	f, err := os.CreateTemp(dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	zw := zlib.NewWriter(f)
+	if _, err := zw.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	zw.Close()
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), objectPath)
+}
+
+func (s *LooseStore) Iterate(fn func(hash string) error) error {
+	dirs, err := os.ReadDir(s.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read objects: %w", err)
+	}
+	for _, d := range dirs {
+		if !d.IsDir() || len(d.Name()) != 2 || !IsHex(d.Name()) {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(s.Dir, d.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read objects: %w", err)
+		}
+		for _, f := range files {
+			if strings.HasPrefix(f.Name(), "tmp-") {
+				continue
+			}
+			if err := fn(d.Name() + f.Name()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// only reads the directory the prefix falls in
+func (s *LooseStore) HashesWithPrefix(prefix string) ([]string, error) {
+	if len(prefix) < 2 || !IsHex(prefix) {
+		return nil, fmt.Errorf("invalid object hash prefix %q", prefix)
+	}
+	files, err := os.ReadDir(filepath.Join(s.Dir, prefix[:2]))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read objects: %w", err)
+	}
+	var hashes []string
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), "tmp-") && strings.HasPrefix(f.Name(), prefix[2:]) {
+			hashes = append(hashes, prefix[:2]+f.Name())
+		}
+	}
+	return hashes, nil
+}
+
+// MemoryStore keeps objects in memory, for tests and throwaway repositories
+type MemoryStore struct {
+	mu      sync.RWMutex
+	objects map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{objects: map[string][]byte{}}
+}
+
+func (s *MemoryStore) Has(hash string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.objects[hash]
+	return ok
+}
+
+func (s *MemoryStore) Get(hash string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	content, ok := s.objects[hash]
+	if !ok {
+		return nil, fmt.Errorf("could not find object: %s", hash)
+	}
+	return bytes.Clone(content), nil
+}
+
+func (s *MemoryStore) Put(hash string, content []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[hash]; !ok {
+		s.objects[hash] = bytes.Clone(content)
+	}
+	return nil
+}
+
+// hashes are visited in sorted order
+func (s *MemoryStore) Iterate(fn func(hash string) error) error {
+	s.mu.RLock()
+	hashes := make([]string, 0, len(s.objects))
+	for h := range s.objects {
+		hashes = append(hashes, h)
+	}
+	s.mu.RUnlock()
+	sort.Strings(hashes)
+	for _, h := range hashes {
+		if err := fn(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) HashesWithPrefix(prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var hashes []string
+	for h := range s.objects {
+		if strings.HasPrefix(h, prefix) {
+			hashes = append(hashes, h)
+		}
+	}
+	sort.Strings(hashes)
+	return hashes, nil
+}
@@ -0,0 +1,271 @@
+package gitre
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ListRefs returns the refs below prefix ("refs/heads/", "refs/" for all) with the hashes they hold
+func (r *Repository) ListRefs(prefix string) (map[string]string, error) {
+	refs := map[string]string{}
+	root := r.Path()
+	err := filepath.WalkDir(r.Path(filepath.FromSlash(prefix)), func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		hash, err := r.ReadRef(name)
+		if err != nil {
+			return err
+		}
+		if hash != "" {
+			refs[name] = hash
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+	return refs, nil
+}
+
+// SortedRefs lists the names of refs in order
+func SortedRefs(refs map[string]string) []string {
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ReachableObjects lists the objects reachable from wants, skipping everything below an object for which
+// stop returns true; objects come after every object they reference so they can be stored in order
+func (r *Repository) ReachableObjects(wants []string, stop func(hash string) bool) ([]string, error) {
+	var order []string
+	seen := map[string]bool{}
+	var visit func(hash string) error
+	visit = func(hash string) error {
+		if hash == "" || seen[hash] || stop != nil && stop(hash) {
+			return nil
+		}
+		seen[hash] = true
+		objType, data, err := r.ReadObject(hash)
+		if err != nil {
+			return fmt.Errorf("missing object %s: %w", hash, err)
+		}
+		switch objType {
+		case "commit":
+			c := ParseCommit(data)
+			if err := visit(c.Tree); err != nil {
+				return err
+			}
+			for _, p := range c.Parents {
+				if err := visit(p); err != nil {
+					return err
+				}
+			}
+		case "tree":
+			for line := range strings.SplitSeq(string(data), "\n") {
+				if fields := strings.SplitN(line, " ", 4); len(fields) == 4 {
+					if err := visit(fields[2]); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		order = append(order, hash)
+		return nil
+	}
+	for _, want := range wants {
+		if err := visit(want); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// CopyObjects copies the objects reachable from wants that dst is missing, returns how many were copied
+func CopyObjects(src *Repository, dst *Repository, wants []string) (int, error) {
+	missing, err := src.ReachableObjects(wants, dst.Objects.Has)
+	if err != nil {
+		return 0, err
+	}
+	for _, hash := range missing {
+		content, err := src.Objects.Get(hash)
+		if err != nil {
+			return 0, err
+		}
+		if err := dst.Objects.Put(hash, content); err != nil {
+			return 0, fmt.Errorf("failed to store object %s: %w", hash, err)
+		}
+	}
+	return len(missing), nil
+}
+
+// IsAncestor reports whether ancestor is reachable from hash, an empty ancestor is reachable from anything
+func (r *Repository) IsAncestor(ancestor string, hash string) (bool, error) {
+	if ancestor == "" || ancestor == hash {
+		return true, nil
+	}
+	if !r.Objects.Has(ancestor) {
+		return false, nil
+	}
+	reachable, err := r.Ancestors(hash)
+	if err != nil {
+		return false, err
+	}
+	return reachable[ancestor], nil
+}
+
+// makes sure every object reachable from tips is stored, the history the existing refs hold is taken as complete
+func (r *Repository) checkConnected(tips []string) error {
+	refs, err := r.ListRefs("refs/")
+	if err != nil {
+		return err
+	}
+	var known []string
+	for _, hash := range refs {
+		known = append(known, hash)
+	}
+	reachable, err := r.ReachableObjects(known, nil)
+	if err != nil {
+		return err
+	}
+	have := map[string]bool{}
+	for _, hash := range reachable {
+		have[hash] = true
+	}
+	_, err = r.ReachableObjects(tips, func(hash string) bool { return have[hash] })
+	return err
+}
+
+// RefUpdate moves a remote ref from Old to New, an empty New deletes it
+type RefUpdate struct {
+	Name string
+	Old  string
+	New  string
+}
+
+// Transport is the other end of a clone, fetch or push
+type Transport interface {
+	// Refs lists the branches and tags of the remote, head is the branch its HEAD points at
+	Refs() (refs map[string]string, head string, err error)
+	// Fetch copies the objects reachable from wants that dst is missing, returns how many were copied
+	Fetch(dst *Repository, wants []string) (int, error)
+	// Push sends the objects the updates need, then moves each ref if it still holds Old
+	Push(src *Repository, updates []RefUpdate) error
+}
+
+// CheckRemoteRefs rejects a ref advertisement naming refs that could not be stored safely or objects that are
+// not full hashes
+func CheckRemoteRefs(refs map[string]string, head string) error {
+	if head != "" && CheckRefName(head) != nil {
+		return fmt.Errorf("invalid HEAD %q", head)
+	}
+	for name, hash := range refs {
+		if CheckRefName(name) != nil || !strings.HasPrefix(name, "refs/") || !IsObjectHash(hash) {
+			return fmt.Errorf("invalid ref %q", name)
+		}
+	}
+	return nil
+}
+
+// LocalTransport talks to a repository on the local filesystem
+type LocalTransport struct {
+	Repo *Repository
+}
+
+func (t *LocalTransport) Refs() (map[string]string, string, error) {
+	refs, err := t.Repo.ListRefs("refs/")
+	if err != nil {
+		return nil, "", err
+	}
+	for name := range refs {
+		if !strings.HasPrefix(name, "refs/heads/") && !strings.HasPrefix(name, "refs/tags/") {
+			delete(refs, name)
+		}
+	}
+	head, _, err := t.Repo.ReadHead()
+	if err != nil {
+		return nil, "", err
+	}
+	return refs, head, nil
+}
+
+func (t *LocalTransport) Fetch(dst *Repository, wants []string) (int, error) {
+	return CopyObjects(t.Repo, dst, wants)
+}
+
+func (t *LocalTransport) Push(src *Repository, updates []RefUpdate) error {
+	var wants []string
+	for _, u := range updates {
+		wants = append(wants, u.New)
+	}
+	if _, err := CopyObjects(src, t.Repo, wants); err != nil {
+		return err
+	}
+	return t.Repo.ApplyRefUpdates(updates)
+}
+
+// ApplyRefUpdates moves refs as a push asks, refusing a ref that changed since the pusher looked at it and
+// the branch checked out in a working tree
+func (r *Repository) ApplyRefUpdates(updates []RefUpdate) error {
+	head, _, err := r.ReadHead()
+	if err != nil {
+		return err
+	}
+	for _, u := range updates {
+		if err := CheckRefName(u.Name); err != nil || !strings.HasPrefix(u.Name, "refs/") {
+			return fmt.Errorf("invalid ref name %q", u.Name)
+		}
+		if u.Old != "" && !IsObjectHash(u.Old) || u.New != "" && !IsObjectHash(u.New) {
+			return fmt.Errorf("invalid object name in the update of %s", u.Name)
+		}
+		if u.Name == head && !r.IsBare() {
+			return fmt.Errorf("refusing to update checked out branch %s", u.Name)
+		}
+		current, err := r.ReadRef(u.Name)
+		if err != nil {
+			return err
+		}
+		if current != u.Old {
+			return fmt.Errorf("%s changed while pushing, fetch and try again", u.Name)
+		}
+		if u.New != "" && !r.Objects.Has(u.New) {
+			return fmt.Errorf("%s would point at missing object %s", u.Name, u.New)
+		}
+	}
+	var tips []string
+	for _, u := range updates {
+		tips = append(tips, u.New)
+	}
+	if err := r.checkConnected(tips); err != nil {
+		return fmt.Errorf("refusing the update, the pushed history is incomplete: %w", err)
+	}
+	for _, u := range updates {
+		if u.New == "" {
+			if err := os.Remove(r.Path(u.Name)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to delete %s: %w", u.Name, err)
+			}
+			continue
+		}
+		if err := r.UpdateRef(u.Name, u.New); err != nil {
+			return fmt.Errorf("failed to update %s: %w", u.Name, err)
+		}
+	}
+	return nil
+}
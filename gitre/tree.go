@@ -0,0 +1,146 @@
+package gitre
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type Node struct {
+	Name     string
+	Mode     int64
+	Hash     string
+	Children map[string]*Node
+}
+
+// builds tree from list of entries
+func BuildTree(entries []IndexEntry) *Node {
+	root := &Node{
+		Name:     "root",
+		Mode:     ModeDir,
+		Children: make(map[string]*Node),
+	}
+
+	for _, entry := range entries {
+		parts := strings.Split(entry.Path, "/")
+		currentNode := root
+
+		for i, part := range parts {
+			// check if file or dir
+			if i == len(parts)-1 {
+				currentNode.Children[part] = &Node{
+					Name: part,
+					Hash: entry.Hash,
+					Mode: entry.Mode,
+				}
+			} else {
+				if _, ok := currentNode.Children[part]; !ok {
+					currentNode.Children[part] = &Node{
+						Name:     part,
+						Mode:     ModeDir,
+						Children: make(map[string]*Node),
+					}
+				}
+				currentNode = currentNode.Children[part]
+			}
+		}
+	}
+	return root
+}
+
+// process & compress tree
+func (r *Repository) WriteTree(node *Node) (string, error) {
+	if node.Children == nil {
+		return node.Hash, nil
+	}
+
+	// entries are written in name order so equal trees always hash the same
+	names := make([]string, 0, len(node.Children))
+	for name := range node.Children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var treeLines []string
+	for _, name := range names {
+		child := node.Children[name]
+		childHash, err := r.WriteTree(child)
+		if err != nil {
+			return "", err
+		}
+
+		itemType := "blob"
+		if child.Children != nil {
+			itemType = "tree"
+		}
+		treeLines = append(treeLines, fmt.Sprintf("%s %s %s %s", FormatMode(child.Mode), itemType, childHash, name))
+	}
+	treeData := []byte(strings.Join(treeLines, "\n"))
+	return r.HashStore(treeData, "tree")
+}
+
+// flattens a stored tree into index-like entries keyed by path
+func (r *Repository) ReadTree(hash string) (map[string]IndexEntry, error) {
+	entries := map[string]IndexEntry{}
+	if hash == "" {
+		return entries, nil
+	}
+	var walk func(hash string, prefix string) error
+	walk = func(hash string, prefix string) error {
+		treeObj, err := r.ExtractObject([]byte(hash))
+		if err != nil {
+			return fmt.Errorf("error extracting tree %s: %w", hash, err)
+		}
+		for line := range strings.SplitSeq(string(treeObj), "\n") {
+			if line == "" {
+				continue
+			}
+			splits := strings.SplitN(line, " ", 4)
+			if len(splits) != 4 {
+				return fmt.Errorf("invalid tree entry in %s: %q", hash, line)
+			}
+			if splits[1] == "tree" {
+				if err := walk(splits[2], prefix+splits[3]+"/"); err != nil {
+					return err
+				}
+				continue
+			}
+			mode, err := parseMode(splits[0])
+			if err != nil {
+				return fmt.Errorf("invalid tree entry in %s: %w", hash, err)
+			}
+			entries[prefix+splits[3]] = IndexEntry{
+				Path: prefix + splits[3],
+				Hash: splits[2],
+				Mode: mode,
+			}
+		}
+		return nil
+	}
+	return entries, walk(hash, "")
+}
+
+// flattens the tree of a commit, empty for no commit
+func (r *Repository) ReadCommitTree(commitHash string) (map[string]IndexEntry, error) {
+	if commitHash == "" {
+		return map[string]IndexEntry{}, nil
+	}
+	c, err := r.ReadCommit(commitHash)
+	if err != nil {
+		return nil, err
+	}
+	return r.ReadTree(c.Tree)
+}
+
+// branch tracking
+func (r *Repository) UpdateRef(refPath string, hash string) error {
+	fullPath := r.Path(refPath)
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(fullPath, []byte(hash), 0644)
+}
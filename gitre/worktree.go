@@ -0,0 +1,104 @@
+package gitre
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// brings the files tracked in old on disk to their content in target
+func (r *Repository) UpdateWorkTree(old map[string]IndexEntry, target map[string]IndexEntry) error {
+	// nothing is touched when a tree holds a path that cannot be checked out
+	for path := range target {
+		if err := CheckPath(path); err != nil {
+			return err
+		}
+	}
+	for path := range old {
+		if _, ok := target[path]; ok {
+			continue
+		}
+		if err := os.Remove(r.abs(path)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		r.RemoveEmptyDirs(filepath.Dir(path))
+	}
+
+	for _, e := range target {
+		if err := r.CheckoutEntry(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckPath rejects tracked paths that are absolute or would step out of the working tree or into the
+// repository directory
+func CheckPath(path string) error {
+	if path == "" || strings.HasPrefix(path, "/") || strings.Contains(path, "\x00") || filepath.IsAbs(path) {
+		return fmt.Errorf("invalid path %q", path)
+	}
+	for part := range strings.SplitSeq(path, "/") {
+		if part == "" || part == "." || part == ".." || strings.EqualFold(part, DirName) {
+			return fmt.Errorf("invalid path %q", path)
+		}
+	}
+	return nil
+}
+
+// writes a single entry to disk as a regular file, executable or symlink, skipping it when already up to date
+func (r *Repository) CheckoutEntry(e IndexEntry) error {
+	if err := CheckPath(e.Path); err != nil {
+		return err
+	}
+	// a symlink among the parent directories would lead the write elsewhere
+	for dir := filepath.Dir(e.Path); dir != "."; dir = filepath.Dir(dir) {
+		if info, err := os.Lstat(r.abs(dir)); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to write %s beyond a symbolic link", e.Path)
+		}
+	}
+	mode := NormalizeMode(e.Mode)
+	if data, info, err := r.ReadWorktreeFile(e.Path); err == nil {
+		if hash, _ := HashObject(data, "blob"); hash == e.Hash && ModeFromInfo(info) == mode {
+			return nil
+		}
+	}
+	data, err := r.ExtractObject([]byte(e.Hash))
+	if err != nil {
+		return fmt.Errorf("failed to read blob for %s: %w", e.Path, err)
+	}
+	file := r.abs(e.Path)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", e.Path, err)
+	}
+
+	if info, err := os.Lstat(file); err == nil && (mode == ModeSymlink || info.Mode()&os.ModeSymlink != 0) {
+		if err := os.Remove(file); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", e.Path, err)
+		}
+	}
+	if mode == ModeSymlink {
+		if err := os.Symlink(string(data), file); err != nil {
+			return fmt.Errorf("failed to create symlink %s: %w", e.Path, err)
+		}
+		return nil
+	}
+
+	perm := filePerm(mode)
+	if err := os.WriteFile(file, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", e.Path, err)
+	}
+	// WriteFile keeps the permissions of an existing file
+	return os.Chmod(file, perm)
+}
+
+// removes dir and its parents while they are empty, stopping at the top of the working tree
+func (r *Repository) RemoveEmptyDirs(dir string) {
+	for dir != "." && dir != "/" && dir != "" && !filepath.IsAbs(dir) {
+		if err := os.Remove(r.abs(dir)); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// hooks are executables in .gitre/hooks, or the directory set by core.hooksPath, run from the top of
+// the working tree:
+//
+//	pre-commit                       before the commit message is asked for, nonzero aborts the commit
+//	commit-msg <file>                with the message file, may rewrite it, nonzero aborts the commit
+//	post-commit                      after the commit is made, its exit status is ignored
+//	pre-merge                        before a merge commit is made, nonzero aborts the merge
+//	post-checkout <old> <new> <flag> after switch updated the working tree, flag 1 for a branch checkout
+//	pre-push <remote> <url>          before pushing, stdin lists "<local ref> <local hash> <remote ref> <remote hash>"
+//
+// every hook gets GITRE_DIR, GITRE_WORK_TREE and GITRE_INDEX_FILE in its environment
+
+// directory holding the hooks
+func hooksDir() string {
+	if cfg, err := repo.ReadConfig(); err == nil {
+		if dir := cfg.Get("core.hooksPath"); dir != "" {
+			return dir
+		}
+	}
+	return repo.Path("hooks")
+}
+
+// path of the named hook, empty when it does not exist or is not executable
+func hookPath(name string) string {
+	path := filepath.Join(hooksDir(), name)
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+		return ""
+	}
+	return path
+}
+
+// runs the named hook if it exists and is executable, feeding it stdin; a nonzero exit is returned as an error
+func runHook(name string, args []string, stdin string) error {
+	path := hookPath(name)
+	if path == "" {
+		return nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to locate %s hook: %w", name, err)
+	}
+
+	cmd := exec.Command(abs, args...)
+	cmd.Env = append(os.Environ(),
+		"GITRE_DIR="+repo.GitDir,
+		"GITRE_WORK_TREE="+repo.WorkTree,
+		"GITRE_INDEX_FILE="+repo.Path("index"),
+	)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("%s hook failed with exit status %d", name, exitErr.ExitCode())
+		}
+		return fmt.Errorf("failed to run %s hook: %w", name, err)
+	}
+	return nil
+}
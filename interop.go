@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitre/gitre"
+)
+
+// a git repository given by its .git directory, its working tree or a bare directory
+func gitDirArg(arg string) string {
+	dir := userPath(arg)
+	if info, err := os.Stat(filepath.Join(dir, ".git")); err == nil && info.IsDir() {
+		return filepath.Join(dir, ".git")
+	}
+	return dir
+}
+
+// import-git [-f|--force] <path-to-.git>, converts the branches and tags of a git repository with their history;
+// existing branches are only fast-forwarded and existing tags kept unless forced
+func importGit(args []string) error {
+	force := false
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case arg == "-f" || arg == "--force":
+			force = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option for import-git: %s", arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: gitre import-git [-f|--force] <path-to-.git>")
+	}
+	gitDir := gitDirArg(positional[0])
+	if _, err := os.Stat(filepath.Join(gitDir, "objects")); err != nil {
+		return fmt.Errorf("'%s' does not appear to be a git repository", positional[0])
+	}
+	refs, head, err := gitre.GitRefs(gitDir)
+	if err != nil {
+		return err
+	}
+	// the names come from another repository and become files under .gitre
+	for _, name := range append(gitre.SortedRefs(refs), head) {
+		if err := gitre.CheckRefName(name); name != "" && err != nil {
+			return err
+		}
+	}
+	var names, hashes []string
+	for _, name := range gitre.SortedRefs(refs) {
+		if strings.HasPrefix(name, "refs/heads/") || strings.HasPrefix(name, "refs/tags/") {
+			names = append(names, name)
+			hashes = append(hashes, refs[name])
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no branches or tags to import from %s", positional[0])
+	}
+
+	m, err := repo.ReadGitMap()
+	if err != nil {
+		return err
+	}
+	converted, count, err := repo.ImportGit(gitDir, hashes, m)
+	// objects converted before a failure are kept, the map lets the next import skip them
+	if writeErr := m.Write(); err == nil {
+		err = writeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	current, headHash, err := repo.ReadHead()
+	if err != nil {
+		return err
+	}
+	// existing refs only move forward unless forced, and the checked out branch never moves
+	var updates, rejected []string
+	for _, name := range names {
+		hash := converted[refs[name]]
+		old, err := repo.ReadRef(name)
+		if err != nil {
+			return err
+		}
+		switch {
+		case old == hash:
+			continue
+		case old == "" || force:
+		case strings.HasPrefix(name, "refs/tags/"):
+			fmt.Printf(" ! [rejected] %s (would clobber existing tag)\n", name)
+			rejected = append(rejected, name)
+			continue
+		default:
+			if fastForward, err := repo.IsAncestor(old, hash); err != nil {
+				return err
+			} else if !fastForward {
+				fmt.Printf(" ! [rejected] %s (non-fast-forward)\n", name)
+				rejected = append(rejected, name)
+				continue
+			}
+		}
+		if name == current && headHash != "" && hash != headHash {
+			return fmt.Errorf("refusing to update checked out branch %s, switch to another branch first", strings.TrimPrefix(name, "refs/heads/"))
+		}
+		updates = append(updates, name)
+	}
+	for _, name := range updates {
+		hash := converted[refs[name]]
+		if err := repo.UpdateRef(name, hash); err != nil {
+			return fmt.Errorf("failed to create %s: %w", name, err)
+		}
+		fmt.Printf(" * %s -> %s\n", name, shortHash(hash))
+	}
+	fmt.Printf("imported %d objects\n", count)
+	var rejectErr error
+	if len(rejected) > 0 {
+		rejectErr = fmt.Errorf("%d refs were not updated, import with --force to overwrite them", len(rejected))
+	}
+
+	// a repository without commits takes over the branch git had checked out
+	if headHash != "" || refs[head] == "" || !strings.HasPrefix(head, "refs/heads/") {
+		return rejectErr
+	}
+	if err := os.WriteFile(repo.Path("HEAD"), []byte("ref: "+head+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to update HEAD: %w", err)
+	}
+	if repo.IsBare() {
+		return rejectErr
+	}
+	// the branch may have kept its own commit when the import was refused
+	target, err := repo.ReadRef(head)
+	if err != nil {
+		return err
+	}
+	entries, err := repo.ReadCommitTree(target)
+	if err != nil {
+		return err
+	}
+	if err := resetHard("", entries); err != nil {
+		return err
+	}
+	return rejectErr
+}
+
+// export-git <path-to-.git>, writes every branch and tag to a git repository, creating a bare one when missing
+func exportGit(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: gitre export-git <path-to-.git>")
+	}
+	gitDir := gitDirArg(args[0])
+	_, statErr := os.Stat(filepath.Join(gitDir, "HEAD"))
+	created := os.IsNotExist(statErr)
+	if err := gitre.InitGit(gitDir); err != nil {
+		return err
+	}
+
+	refs, err := branchesAndTags()
+	if err != nil {
+		return err
+	}
+	names := gitre.SortedRefs(refs)
+	if len(names) == 0 {
+		return fmt.Errorf("no branches or tags to export")
+	}
+	var hashes []string
+	for _, name := range names {
+		hashes = append(hashes, refs[name])
+	}
+	ident, err := gitIdent()
+	if err != nil {
+		return err
+	}
+
+	m, err := repo.ReadGitMap()
+	if err != nil {
+		return err
+	}
+	exported, count, err := repo.ExportGit(gitDir, hashes, ident, m)
+	if writeErr := m.Write(); err == nil {
+		err = writeErr
+	}
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		hash := exported[refs[name]]
+		if err := gitre.WriteGitRef(gitDir, name, hash); err != nil {
+			return err
+		}
+		fmt.Printf(" * %s -> %s\n", name, hash[:7])
+	}
+	if current, _, err := repo.ReadHead(); err == nil && created && refs[current] != "" {
+		if err := os.WriteFile(filepath.Join(gitDir, "HEAD"), []byte("ref: "+current+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to update HEAD: %w", err)
+		}
+	}
+	fmt.Printf("exported %d objects\n", count)
+	return nil
+}
+
+// author for commits made in gitre, which record none while git requires one
+func gitIdent() (string, error) {
+	cfg, err := repo.ReadConfig()
+	if err != nil {
+		return "", err
+	}
+	name, email := cfg.Get("user.name"), cfg.Get("user.email")
+	if name == "" {
+		name = "gitre"
+	}
+	if email == "" {
+		email = "gitre@localhost"
+	}
+	return fmt.Sprintf("%s <%s> 0 +0000", name, email), nil
+}
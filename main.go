@@ -3,20 +3,46 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
+	"sort"
 	"strings"
+
+	"gitre/gitre"
 )
 
+// commands that work without a working tree
+var bareCommands = map[string]bool{"log": true, "config": true, "fetch": true, "push": true, "remote": true, "branch": true, "bundle": true, "import-git": true, "export-git": true, "fast-export": true, "fast-import": true}
+
 func main() {
-	if len(os.Args) < 2 {
+	args := os.Args[1:]
+	// -C <dir> runs as if started in dir, repeated options are applied in order
+	for len(args) >= 2 && args[0] == "-C" {
+		if err := os.Chdir(args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot change to '%s': %v\n", args[1], err)
+			os.Exit(128)
+		}
+		args = args[2:]
+	}
+	if len(args) < 1 {
 		fmt.Println("no valid args.")
 		return
 	}
 
 	var err error
 
-	switch os.Args[1] {
+	if args[0] != "init" && args[0] != "clone" && args[0] != "serve" {
+		if err = discoverRepo(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(128)
+		}
+		if repo.IsBare() && !bareCommands[args[0]] {
+			fmt.Fprintf(os.Stderr, "Error: %s must be run in a work tree\n", args[0])
+			os.Exit(128)
+		}
+	}
+
+	switch args[0] {
 	case "init":
 		if err = initRepo(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -24,7 +50,7 @@ func main() {
 		}
 		return
 	case "add":
-		errs := add(os.Args[2:])
+		errs := add(args[1:])
 		if len(errs) > 0 {
 			fmt.Fprintln(os.Stderr, "Errors occurred while adding files:")
 			for _, e := range errs {
@@ -34,87 +60,202 @@ func main() {
 		}
 		return
 	case "commit":
-		if err = commit(os.Args[2]); err != nil {
+		if err = commit(args[1:]); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 		return
 	case "log":
-		if err = log(); err != nil {
+		if err = log(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "diff":
+		if err = diff(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "stash":
+		if err = stash(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "cherry-pick":
+		if err = cherryPick(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "revert":
+		if err = revert(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "rebase":
+		if err = rebase(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "merge":
+		if err = merge(args[1:]); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 		return
 	case "checkout":
-		if err = checkout(os.Args[2]); err != nil {
+		if err = checkout(args[1]); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 		return
 	case "switch":
-		switchBranch()
+		if err = switchBranch(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 		return
 	case "restore":
-		restore()
+		if err = restore(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "rm":
+		if err = rm(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "mv":
+		if err = mv(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "check-ignore":
+		ignored, err := checkIgnore(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(128)
+		}
+		if !ignored {
+			os.Exit(1)
+		}
+		return
+	case "config":
+		if err = config(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "reset":
+		if err = reset(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 		return
 	case "status":
-		if err = status(); err != nil {
+		if err = status(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "clone":
+		if err = clone(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "fetch":
+		if err = fetch(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "push":
+		if err = push(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "remote":
+		if err = remote(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "branch":
+		if err = branch(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "pull":
+		if err = pull(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "bundle":
+		if err = bundle(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "import-git":
+		if err = importGit(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "export-git":
+		if err = exportGit(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "fast-export":
+		if err = fastExport(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "fast-import":
+		if err = fastImport(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "serve":
+		if err = serve(args[1:]); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 		return
 	default:
-		fmt.Printf("unknown command: %s. available commands: init, add, commit, status, log\n", os.Args[1])
+		fmt.Printf("unknown command: %s. available commands: init, add, commit, status, log, reset, rm, mv, check-ignore, config, switch, restore, diff, stash, cherry-pick, revert, rebase, merge, clone, fetch, push, pull, remote, branch, bundle, import-git, export-git, fast-export, fast-import, serve\n", args[0])
 		return
 	}
 
 }
 
 func initRepo() error {
-	var repoDir string = ".gitre"
-	var dirPerm os.FileMode = 0700
-	var filePerm os.FileMode = 0644
-	var err error
-	if err = os.Mkdir(repoDir, dirPerm); err != nil && os.IsNotExist(err) {
-		return fmt.Errorf("failed to create .gitre directory: %w", err)
+	dir := os.Getenv("GITRE_DIR")
+	if dir == "" {
+		dir = gitre.DirName
 	}
-	if err = os.MkdirAll(filepath.Join(repoDir, "refs", "heads"), dirPerm); err != nil {
-		return fmt.Errorf("failed to create refs/heads directory: %w", err)
-	}
-	if err = os.MkdirAll(filepath.Join(repoDir, "objects"), dirPerm); err != nil {
-		return fmt.Errorf("failed to create objects directory: %w", err)
-	}
-	if err = os.MkdirAll(filepath.Join(repoDir, "refs", "tags"), dirPerm); err != nil {
-		return fmt.Errorf("failed to create refs/tags directory: %w", err)
-	}
-
-	if _, err = os.Stat(filepath.Join(repoDir, "config")); os.IsNotExist(err) {
-		if _, err = os.Create(filepath.Join(repoDir, "config")); err != nil {
-			return fmt.Errorf("failed to create config file: %w", err)
-		}
-	} else if err != nil {
-		return fmt.Errorf("failed to check config file: %w", err)
-	}
-
-	if _, err = os.Stat(filepath.Join(repoDir, "index")); os.IsNotExist(err) {
-		if err = os.WriteFile(filepath.Join(repoDir, "index"), []byte("[]"), filePerm); err != nil {
-			return fmt.Errorf("failed to create index file: %w", err)
-		}
-	} else if err != nil {
-		return fmt.Errorf("failed to check index file: %w", err)
-	}
-
-	if _, err = os.Stat(filepath.Join(repoDir, "HEAD")); os.IsNotExist(err) {
-		if err = os.WriteFile(filepath.Join(repoDir, "HEAD"), []byte("ref: refs/heads/main\n"), filePerm); err != nil {
-			return fmt.Errorf("failed to create HEAD file: %w", err)
-		}
-	} else if err != nil {
-		return fmt.Errorf("failed to check HEAD file: %w", err)
+	if _, err := gitre.Init(dir, "."); err != nil {
+		return err
 	}
 
 	var ignoreContent string = "*.exe\n*.dll\n.env\n"
 
-	if _, err = os.Stat(".gitreignore"); os.IsNotExist(err) {
+	if _, err := os.Stat(".gitreignore"); os.IsNotExist(err) {
 		if err = os.WriteFile(".gitreignore", []byte(ignoreContent), 0644); err != nil {
 			return fmt.Errorf("failed to create .gitreignore file: %w", err)
 		}
@@ -122,37 +263,125 @@ func initRepo() error {
 		return fmt.Errorf("failed to check .gitreignore file: %w", err)
 	}
 
-	fmt.Println("Initialized gitre repository:", repoDir)
-
+	fmt.Println("Initialized gitre repository:", dir)
 	return nil
 }
 
 func add(args []string) []error {
 	var errors []error
-	files := make(map[string]struct{})
-	ignores := accumIgnores()
+	all, update, force := false, false, false
+	var paths []string
 	for _, arg := range args {
-		info, err := os.Stat(arg)
+		switch arg {
+		case "-f", "--force":
+			force = true
+		case "-A", "--all":
+			all = true
+		case "-u", "--update":
+			update = true
+		default:
+			paths = append(paths, userPath(arg))
+		}
+	}
+	if (all || update) && len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	idx, err := repo.ReadIndex()
+	if err != nil {
+		return []error{fmt.Errorf("failed to load index: %w", err)}
+	}
+	tracked := append([]gitre.IndexEntry(nil), idx.Entries...)
+	trackedPaths := map[string]bool{}
+	for _, e := range tracked {
+		trackedPaths[e.Path] = true
+	}
+
+	files := make(map[string]struct{})
+	ignores := repo.Ignores()
+	if force {
+		ignores = gitre.NoIgnores()
+	}
+	var ignoredPaths []string
+	for _, arg := range paths {
+		info, err := os.Lstat(arg)
 		if err != nil {
-			errors = append(errors, fmt.Errorf("path not found: %s", arg))
+			if !(all || update) || !matchesTracked(tracked, arg) {
+				errors = append(errors, fmt.Errorf("path not found: %s", arg))
+			}
+			continue
+		}
+		if update {
+			continue
+		}
+		if !trackedPaths[gitre.CleanPath(arg)] && ignores.Ignored(arg, info.IsDir()) {
+			ignoredPaths = append(ignoredPaths, arg)
 			continue
 		}
 		if info.IsDir() {
-			diskFiles, _ := traverseDir(arg, ignores)
+			diskFiles, _ := repo.TraverseDir(arg, ignores)
 			for _, f := range diskFiles {
 				files[f] = struct{}{}
 			}
 		} else {
-			files[arg] = struct{}{}
+			files[gitre.CleanPath(arg)] = struct{}{}
 		}
 	}
+	if len(ignoredPaths) > 0 {
+		errors = append(errors, fmt.Errorf("the following paths are ignored by one of your .gitreignore files, use -f to add them: %s", strings.Join(ignoredPaths, ", ")))
+	}
+
+	// tracked files are updated even when ignore rules hide them, -A and -u also stage their deletions
+	deleted := map[string]bool{}
+	for _, e := range tracked {
+		if !gitre.MatchesAnyPath(e.Path, paths) {
+			continue
+		}
+		if _, err := os.Lstat(e.Path); os.IsNotExist(err) {
+			if all || update {
+				deleted[e.Path] = true
+			}
+		} else if !matchesIgnoredPath(e.Path, ignoredPaths) {
+			files[e.Path] = struct{}{}
+		}
+	}
+
+	var sorted []string
 	for filePath := range files {
-		err := IndexObject(filePath)
-		if err != nil {
-			errors = append(errors, fmt.Errorf("failed to add %s: %w", filePath, err))
-		} else {
-			fmt.Printf("added %s\n", filePath)
+		sorted = append(sorted, filePath)
+	}
+	sort.Strings(sorted)
+	racyTime := repo.IndexMtime()
+	staged, errs := gitre.RunParallel(repo.Workers(), sorted, func(filePath string) (*gitre.IndexEntry, error) {
+		return repo.IndexObject(idx, filePath, racyTime)
+	})
+	var resolved []string
+	for i, filePath := range sorted {
+		if errs[i] != nil {
+			errors = append(errors, fmt.Errorf("failed to add %s: %w", filePath, errs[i]))
+			continue
+		}
+		if staged[i] != nil {
+			idx.Set(*staged[i])
 		}
+		fmt.Printf("added %s\n", filePath)
+		resolved = append(resolved, filePath)
+	}
+	var removed []string
+	for path := range deleted {
+		removed = append(removed, path)
+	}
+	sort.Strings(removed)
+	for _, path := range removed {
+		idx.Remove(path)
+		fmt.Printf("removed %s\n", path)
+	}
+	if err := idx.Write(); err != nil {
+		errors = append(errors, err)
+	}
+	// staging a conflicted path marks it as resolved
+	if err := repo.ResolveConflicts(append(resolved, removed...)); err != nil {
+		errors = append(errors, err)
 	}
 	if len(errors) > 0 {
 		return errors
@@ -160,91 +389,362 @@ func add(args []string) []error {
 	return nil
 }
 
-func commit(message string) error {
-	entries, err := LoadIndex()
-	if err != nil {
-		return fmt.Errorf("failed to load index: %w", err)
+// reports whether path lies at or below one of the refused ignored paths
+func matchesIgnoredPath(path string, ignoredPaths []string) bool {
+	return len(ignoredPaths) > 0 && gitre.MatchesAnyPath(path, ignoredPaths)
+}
+
+// reports whether any tracked entry lies at or below path
+func matchesTracked(entries []gitre.IndexEntry, path string) bool {
+	for _, e := range entries {
+		if gitre.MatchesAnyPath(e.Path, []string{path}) {
+			return true
+		}
 	}
-	if len(entries) == 0 {
-		return fmt.Errorf("nothing to commit")
+	return false
+}
+
+// commit [-m <message>]... [-F <file>] [-a] [--amend] [--allow-empty] [-n] [<message>]
+func commit(args []string) error {
+	var messages []string
+	messageFile := ""
+	all, amend, allowEmpty, noVerify := false, false, false, false
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-m", "--message", "-F", "--file":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			if arg == "-F" || arg == "--file" {
+				messageFile = args[i]
+				if messageFile != "-" {
+					messageFile = userPath(messageFile)
+				}
+			} else {
+				messages = append(messages, args[i])
+			}
+		case "-a", "--all":
+			all = true
+		case "--amend":
+			amend = true
+		case "--allow-empty":
+			allowEmpty = true
+		case "-n", "--no-verify":
+			noVerify = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown option for commit: %s", arg)
+			}
+			// a bare message is still accepted as in earlier versions
+			messages = append(messages, arg)
+		}
+	}
+	if messageFile != "" && len(messages) > 0 {
+		return fmt.Errorf("-m and -F cannot be used together")
 	}
 
-	rootNode := BuildTree(entries)
+	if conflicts, err := repo.ReadConflicts(); err != nil {
+		return err
+	} else if len(conflicts) > 0 {
+		return fmt.Errorf("cannot commit with unresolved conflicts in: %s", strings.Join(conflicts, ", "))
+	}
+	if all {
+		if err := stageTracked(); err != nil {
+			return err
+		}
+	}
+	if !noVerify {
+		if err := runHook("pre-commit", nil, ""); err != nil {
+			return err
+		}
+	}
 
-	rootTreeHash, err := WriteTree(rootNode)
+	entries, err := repo.LoadIndex()
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+	rootTreeHash, err := repo.WriteTree(gitre.BuildTree(entries))
 	if err != nil {
 		return fmt.Errorf("failed to write tree objects: %w", err)
 	}
 
-	head, err := os.ReadFile(".gitre/HEAD")
-	ref := bytes.TrimPrefix(head, []byte("ref: "))
-	ref = bytes.TrimSpace(ref)
-	path := filepath.Join(".gitre", string(ref))
-	p_hash, err := os.ReadFile(path)
-	p_hash = bytes.TrimSpace(p_hash)
-	var commitContent strings.Builder
-	commitContent.WriteString(fmt.Sprintf("tree %s\n", rootTreeHash))
-	if len(p_hash) > 0 {
-		commitContent.WriteString(fmt.Sprintf("parent %s\n", string(p_hash)))
+	_, headHash, err := repo.ReadHead()
+	if err != nil {
+		return err
 	}
-	commitContent.WriteString(message)
-
-	commitHash, err := HashStore([]byte(commitContent.String()), "commit")
+	var parents []string
+	var head *gitre.Commit
+	if headHash != "" {
+		if head, err = repo.ReadCommit(headHash); err != nil {
+			return err
+		}
+		parents = []string{headHash}
+	}
+	if amend {
+		if head == nil {
+			return fmt.Errorf("there is no commit to amend yet")
+		}
+		parents = head.Parents
+	}
+	mergeHead, err := repo.ReadRef("MERGE_HEAD")
 	if err != nil {
-		return fmt.Errorf("failed to create commit object: %w", err)
+		return err
+	}
+	if mergeHead != "" {
+		if amend {
+			return fmt.Errorf("cannot amend while a merge is in progress")
+		}
+		parents = append(parents, mergeHead)
+	}
+
+	// an unchanged tree only makes a commit when asked for, when amending the message or concluding a merge
+	if !allowEmpty && !amend && mergeHead == "" {
+		unchanged := len(entries) == 0
+		if head != nil {
+			unchanged = head.Tree == rootTreeHash
+		}
+		if unchanged {
+			return fmt.Errorf("nothing to commit, working tree clean (use --allow-empty to commit anyway)")
+		}
+	}
+
+	var message string
+	edited := false
+	switch {
+	case messageFile != "":
+		var data []byte
+		if messageFile == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(messageFile)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read commit message: %w", err)
+		}
+		message = cleanMessage(string(data), false)
+	case len(messages) > 0:
+		message = strings.Join(messages, "\n\n") + "\n"
+	default:
+		initial := ""
+		if data, err := os.ReadFile(repo.Path("MERGE_MSG")); err == nil {
+			initial = string(data)
+		} else if amend {
+			initial = head.Message
+		}
+		template, err := commitTemplate(initial)
+		if err != nil {
+			return err
+		}
+		if message, err = editText(repo.Path("COMMIT_EDITMSG"), template); err != nil {
+			return err
+		}
+		edited = true
+	}
+	if strings.TrimSpace(message) == "" {
+		return fmt.Errorf("aborting commit due to empty commit message")
+	}
+	if !noVerify && hookPath("commit-msg") != "" {
+		// the hook may rewrite the message file
+		messagePath := repo.Path("COMMIT_EDITMSG")
+		if err := os.WriteFile(messagePath, []byte(message), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", messagePath, err)
+		}
+		if err := runHook("commit-msg", []string{messagePath}, ""); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(messagePath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", messagePath, err)
+		}
+		if message = cleanMessage(string(data), edited); message == "" {
+			return fmt.Errorf("aborting commit due to empty commit message")
+		}
 	}
 
-	err = UpdateRef("refs/heads/main", commitHash)
+	commitHash, err := repo.WriteCommit(rootTreeHash, parents, message)
 	if err != nil {
+		return err
+	}
+	if err = repo.UpdateHead(commitHash); err != nil {
 		return fmt.Errorf("failed to update ref: %w", err)
 	}
+	// a commit made by hand concludes a merge or a stopped cherry-pick or revert step
+	if mergeHead != "" {
+		if err := clearMergeState(); err != nil {
+			return err
+		}
+	}
+	if sequencerActive() {
+		if err := clearStep(); err != nil {
+			return err
+		}
+	}
 
-	fmt.Printf("[%s] %s\n", commitHash[:7], message)
+	subject, _, _ := strings.Cut(message, "\n")
+	fmt.Printf("[%s] %s\n", commitHash[:7], subject)
+	if err := runHook("post-commit", nil, ""); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
 	return nil
 }
 
-func log() error {
-	head, err := os.ReadFile(".gitre/HEAD")
+// initial commit message followed by the status as comments
+func commitTemplate(initial string) (string, error) {
+	result, err := repo.Status(false)
 	if err != nil {
-		return fmt.Errorf("failed to read HEAD: %w", err)
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString(initial)
+	if !strings.HasSuffix(initial, "\n") {
+		b.WriteString("\n")
+	}
+	b.WriteString("\n# Please enter the commit message for your changes. Lines starting\n")
+	b.WriteString("# with '#' will be ignored, and an empty message aborts the commit.\n#\n")
+	if result.Branch != "" {
+		fmt.Fprintf(&b, "# On branch %s\n", result.Branch)
+	} else {
+		fmt.Fprintf(&b, "# HEAD detached at %s\n", shortHash(result.Head))
+	}
+	labels := map[string]string{"M": "modified", "A": "new file", "D": "deleted", "R": "renamed"}
+	sections := []struct {
+		title  string
+		status func(gitre.StatusEntry) string
+	}{
+		{"Changes to be committed:", func(e gitre.StatusEntry) string { return labels[e.Index] }},
+		{"Changes not staged for commit:", func(e gitre.StatusEntry) string { return labels[e.Worktree] }},
+		{"Untracked files:", func(e gitre.StatusEntry) string {
+			if e.Index == "?" {
+				return "untracked"
+			}
+			return ""
+		}},
+	}
+	for _, section := range sections {
+		var lines []string
+		for _, e := range result.Entries {
+			label := section.status(e)
+			if label == "" {
+				continue
+			}
+			path := e.Path
+			if e.Index == "R" && label == "renamed" {
+				path = e.OrigPath + " -> " + e.Path
+			}
+			lines = append(lines, fmt.Sprintf("#\t%-12s%s", label+":", path))
+		}
+		if len(lines) > 0 {
+			fmt.Fprintf(&b, "#\n# %s\n%s\n", section.title, strings.Join(lines, "\n"))
+		}
 	}
-	head = bytes.TrimSpace(head)
-	ref := bytes.TrimPrefix(head, []byte("ref: "))
-	path := filepath.Join(".gitre", string(ref))
-	hash, err := os.ReadFile(path)
-	for len(hash) > 0 {
-		content, err := ExtractObject(hash)
+	return b.String(), nil
+}
+
+// stages modifications and deletions of every tracked file, as commit -a does
+func stageTracked() error {
+	idx, err := repo.ReadIndex()
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+	racyTime := repo.IndexMtime()
+	tracked := append([]gitre.IndexEntry(nil), idx.Entries...)
+	for _, e := range tracked {
+		if _, err := os.Lstat(e.Path); os.IsNotExist(err) {
+			idx.Remove(e.Path)
+			continue
+		}
+		staged, err := repo.IndexObject(idx, e.Path, racyTime)
 		if err != nil {
-			return fmt.Errorf("error extracting object %s: %w", hash, err)
+			return fmt.Errorf("failed to add %s: %w", e.Path, err)
 		}
-		fmt.Printf("commit %s\n", hash)
-		fmt.Printf("%s", string(content))
-		var parentHash []byte
-		lines := bytes.SplitSeq(content, []byte("\n"))
-		for line := range lines {
-			if after, ok := bytes.CutPrefix(line, []byte("parent ")); ok {
-				parentHash = after
-				break
+		if staged != nil {
+			idx.Set(*staged)
+		}
+	}
+	return idx.Write()
+}
+
+// log [--follow] [<rev>] [[--] <path>]
+func log(args []string) error {
+	follow := false
+	rev, path := "", ""
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--follow":
+			follow = true
+		case arg == "--":
+			if i+1 < len(args) {
+				path = gitre.CleanPath(userPath(args[i+1]))
+			}
+			i = len(args)
+		case rev == "" && path == "":
+			if _, err := repo.ResolveRev(arg); err == nil {
+				rev = arg
+			} else {
+				path = gitre.CleanPath(userPath(arg))
 			}
+		default:
+			path = gitre.CleanPath(userPath(arg))
 		}
-		if len(parentHash) > 0 {
-			fmt.Println("  |")
+	}
+	if follow && path == "" {
+		return fmt.Errorf("--follow requires exactly one path")
+	}
+
+	var hash string
+	if rev == "" {
+		_, headHash, err := repo.ReadHead()
+		if err != nil {
+			return err
 		}
-		hash = parentHash
+		hash = headHash
+	} else {
+		resolved, err := repo.ResolveRev(rev)
+		if err != nil {
+			return err
+		}
+		hash = resolved
 	}
-	return nil
+
+	shown := 0
+	return repo.Log(hash, func(hash string, c *gitre.Commit, content []byte) error {
+		if path != "" {
+			var parentHash string
+			if len(c.Parents) > 0 {
+				parentHash = c.Parents[0]
+			}
+			show, renamedFrom, err := repo.TouchesPath(c, parentHash, path, follow)
+			if err != nil || !show {
+				return err
+			}
+			if renamedFrom != "" {
+				path = renamedFrom
+			}
+		}
+		if shown > 0 {
+			fmt.Println("  |")
+		}
+		fmt.Printf("commit %s\n", hash)
+		fmt.Printf("%s", string(content))
+		shown++
+		return nil
+	})
 }
 
 func checkout(name string) error {
-	newBranchPath := ".gitre/refs/heads/" + name
+	if err := checkBranchName(name); err != nil {
+		return err
+	}
+	newBranchPath := repo.Path("refs", "heads", name)
 	if _, err := os.Stat(newBranchPath); err == nil {
 		return fmt.Errorf("branch '%s' already exists", name)
 	}
-	branches, err := os.ReadDir(".gitre/refs/heads/")
+	branches, err := os.ReadDir(repo.Path("refs", "heads"))
 	if err != nil {
 		return fmt.Errorf("error finding branches: %w", err)
 	}
-	head, err := os.ReadFile(".gitre/HEAD")
+	head, err := os.ReadFile(repo.Path("HEAD"))
 	if err != nil {
 		return fmt.Errorf("error reading file: %w", err)
 	}
@@ -267,121 +767,12 @@ func checkout(name string) error {
 		return nil
 	}
 
-	hash, err := os.ReadFile(".gitre/refs/heads/" + branches[choice-1].Name())
+	hash, err := os.ReadFile(repo.Path("refs", "heads", branches[choice-1].Name()))
 	if err != nil {
 		return fmt.Errorf("error reading file: %w", err)
 	}
-	os.WriteFile(".gitre/refs/heads/"+name, hash, 0644)
-	os.WriteFile(".gitre/HEAD", []byte("ref: refs/heads/"+name), 0644)
+	os.WriteFile(repo.Path("refs", "heads", name), hash, 0644)
+	os.WriteFile(repo.Path("HEAD"), []byte("ref: refs/heads/"+name), 0644)
 
 	return nil
 }
-
-func switchBranch() error {
-	return nil
-}
-
-func restore() error {
-	return nil
-}
-
-func status() error {
-	indexEntries, err := LoadIndex()
-	indexMap := map[string]string{}
-	for _, entry := range indexEntries {
-		indexMap[entry.Path] = entry.Hash
-	}
-	head, err := os.ReadFile(".gitre/HEAD")
-	head = bytes.TrimSpace(head)
-	currentBranch := strings.TrimPrefix(string(head), "ref: refs/heads/")
-	fmt.Printf("On branch: %s\n", currentBranch)
-	headHash, err := os.ReadFile(".gitre/refs/heads/" + currentBranch)
-	if err != nil || headHash == nil {
-		return fmt.Errorf("no commit on current branch: %w", err)
-	}
-	content, err := ExtractObject(bytes.TrimSpace(headHash))
-	firstLine := strings.Split(string(content), "\n")[0]
-	treeHash := strings.TrimPrefix(firstLine, "tree ")
-	treeObj, _ := ExtractObject([]byte(treeHash))
-	headMap := map[string]string{}
-	var searchTree func(tree []byte, prefix string)
-	searchTree = func(tree []byte, prefix string) {
-		lines := strings.SplitSeq(string(tree), "\n")
-		for line := range lines {
-			if line == "" {
-				continue
-			}
-			splits := strings.Split(line, " ")
-			if splits[1] == "tree" {
-				treeObj, err := ExtractObject([]byte(splits[2]))
-				if err != nil {
-					fmt.Printf("%v\n", err)
-					return
-				}
-				searchTree(treeObj, prefix+splits[3]+"/")
-			} else {
-				headMap[prefix+splits[3]] = splits[2]
-			}
-		}
-	}
-	searchTree(treeObj, "")
-	fmt.Println("\nSTAGING: (index <-> commit)")
-	var mod, new []string
-	for k, v := range indexMap {
-		value, ok := headMap[k]
-		if ok && v != value {
-			mod = append(mod, k)
-		}
-		if !ok {
-			new = append(new, k)
-		}
-		delete(headMap, k)
-	}
-	fmt.Println("\nModified files:")
-	for _, k := range mod {
-		fmt.Printf("%s, ", k)
-	}
-	fmt.Println("\nNew files:")
-	for _, k := range new {
-		fmt.Printf("%s, ", k)
-	}
-	fmt.Println("\nDeleted files: ")
-	for k := range headMap {
-		fmt.Printf("%s, ", k)
-	}
-
-	fmt.Println("\nUNSTAGED (disk <-> index): ")
-	ignores := accumIgnores()
-	diskFiles, err := traverseDir("./", ignores)
-	var modified, untracked []string
-	for _, file := range diskFiles {
-		value, ok := indexMap[file]
-		if ok {
-			data, _ := os.ReadFile(file)
-			fileHash, _ := HashObject(data, "blob")
-			if fileHash != value {
-				modified = append(modified, file)
-			}
-		} else {
-			untracked = append(untracked, file)
-		}
-		delete(indexMap, file)
-	}
-	fmt.Println("\nModified files:")
-	for _, k := range modified {
-		fmt.Printf("%s, ", k)
-	}
-	fmt.Println("\nUntracked:")
-	for _, k := range untracked {
-		fmt.Printf("%s, ", k)
-	}
-	fmt.Println("\nDeleted files: ")
-	for k := range indexMap {
-		fmt.Printf("%s, ", k)
-	}
-
-	if err != nil {
-		return fmt.Errorf("error: %w", err)
-	}
-	return nil
-}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// merge [--no-ff|--ff-only] [-m <message>] [--no-verify] <rev> or merge --continue [--no-verify] | merge --abort
+func merge(args []string) error {
+	noFF, ffOnly, noVerify, cont := false, false, false, false
+	message := ""
+	var revs []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "--continue":
+			cont = true
+		case "--abort":
+			return mergeAbort()
+		case "--no-ff":
+			noFF = true
+		case "--ff-only":
+			ffOnly = true
+		case "--no-verify":
+			noVerify = true
+		case "-m", "--message":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a message", arg)
+			}
+			i++
+			message = args[i]
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown option for merge: %s", arg)
+			}
+			revs = append(revs, arg)
+		}
+	}
+	commitArgs := []string{"-F", repo.Path("MERGE_MSG")}
+	if noVerify {
+		commitArgs = append(commitArgs, "--no-verify")
+	}
+	if cont {
+		if len(revs) > 0 || noFF || ffOnly || message != "" {
+			return fmt.Errorf("usage: gitre merge --continue [--no-verify]")
+		}
+		return commit(commitArgs)
+	}
+	if len(revs) != 1 {
+		return fmt.Errorf("usage: gitre merge [--no-ff|--ff-only] [-m <message>] [--no-verify] <rev>")
+	}
+	if mergeHead, err := repo.ReadRef("MERGE_HEAD"); err != nil {
+		return err
+	} else if mergeHead != "" {
+		return fmt.Errorf("a merge is in progress, conclude it with 'gitre merge --continue' or 'gitre merge --abort'")
+	}
+
+	target, err := repo.ResolveRev(revs[0])
+	if err != nil {
+		return err
+	}
+	headHash, err := requireCleanHead("merge")
+	if err != nil {
+		return err
+	}
+	base, err := repo.MergeBase(headHash, target)
+	if err != nil {
+		return err
+	}
+	if base == target {
+		fmt.Println("Already up to date.")
+		return nil
+	}
+	if base == headHash && !noFF {
+		fmt.Printf("Updating %s..%s\nFast-forward\n", shortHash(headHash), shortHash(target))
+		return resetToCommit(target)
+	}
+	if ffOnly {
+		return fmt.Errorf("not possible to fast-forward, aborting")
+	}
+
+	baseTree, err := repo.ReadCommitTree(base)
+	if err != nil {
+		return err
+	}
+	ours, err := repo.ReadCommitTree(headHash)
+	if err != nil {
+		return err
+	}
+	theirs, err := repo.ReadCommitTree(target)
+	if err != nil {
+		return err
+	}
+	merged, err := repo.MergeTrees(baseTree, ours, theirs, "HEAD", revs[0])
+	if err != nil {
+		return err
+	}
+	if err := checkoutMerge(ours, merged); err != nil {
+		return err
+	}
+
+	if message == "" {
+		name := revs[0]
+		if branchHash, _ := repo.ReadRef("refs/heads/" + name); branchHash != "" {
+			name = "branch '" + name + "'"
+		} else {
+			name = "commit '" + shortHash(target) + "'"
+		}
+		message = "Merge " + name + "\n"
+	}
+	if err := repo.UpdateRef("MERGE_HEAD", target); err != nil {
+		return fmt.Errorf("failed to write MERGE_HEAD: %w", err)
+	}
+	if err := os.WriteFile(repo.Path("MERGE_MSG"), []byte(message), 0644); err != nil {
+		return fmt.Errorf("failed to write MERGE_MSG: %w", err)
+	}
+	if len(merged.Conflicts) > 0 {
+		if err := repo.WriteConflicts(merged.Conflicts); err != nil {
+			return err
+		}
+		for _, path := range merged.Conflicts {
+			fmt.Printf("CONFLICT: merge conflict in %s\n", path)
+		}
+		return fmt.Errorf("automatic merge failed, fix the conflicts, mark them with 'gitre add <path>' and run 'gitre merge --continue'")
+	}
+
+	if !noVerify {
+		if err := runHook("pre-merge", nil, ""); err != nil {
+			return fmt.Errorf("%w, the merge result is left staged for 'gitre merge --continue'", err)
+		}
+	}
+	return commit(commitArgs)
+}
+
+// throws away a merge in progress, restoring HEAD in index and working tree
+func mergeAbort() error {
+	if mergeHead, err := repo.ReadRef("MERGE_HEAD"); err != nil {
+		return err
+	} else if mergeHead == "" {
+		return fmt.Errorf("there is no merge to abort")
+	}
+	if err := resetToCommit("HEAD"); err != nil {
+		return err
+	}
+	return clearMergeState()
+}
+
+func clearMergeState() error {
+	for _, name := range []string{"MERGE_HEAD", "MERGE_MSG"} {
+		if err := os.Remove(repo.Path(name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+	return repo.WriteConflicts(nil)
+}
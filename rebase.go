@@ -0,0 +1,541 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gitre/gitre"
+)
+
+// state of a running rebase: head-name, orig-head, onto, the remaining todo, the done steps and,
+// when stopped on conflicts, the step and message to commit on --continue
+func rebaseDir() string {
+	return repo.Path("rebase-merge")
+}
+
+type rebaseStep struct {
+	Action string // pick, reword, edit, squash, fixup, drop or exec
+	Arg    string // commit hash, or the command for exec
+}
+
+func (s rebaseStep) String() string {
+	return s.Action + " " + s.Arg
+}
+
+var rebaseActions = map[string]string{
+	"p": "pick", "r": "reword", "e": "edit", "s": "squash", "f": "fixup", "x": "exec", "d": "drop",
+}
+
+const rebaseTodoHelp = `
+# Commands:
+# p, pick <commit> = use commit
+# r, reword <commit> = use commit, but edit the commit message
+# e, edit <commit> = use commit, but stop for amending
+# s, squash <commit> = use commit, but meld into previous commit
+# f, fixup <commit> = like "squash", but discard this commit's message
+# x, exec <command> = run command (the rest of the line) using shell
+# d, drop <commit> = remove commit
+#
+# Lines can be reordered, they are executed from top to bottom.
+# Removing every line aborts the rebase.
+`
+
+// rebase [-i] <upstream> or rebase --continue|--skip|--abort
+func rebase(args []string) error {
+	interactive := false
+	var upstream []string
+	for _, arg := range args {
+		switch arg {
+		case "--continue", "--skip", "--abort":
+			if len(args) != 1 {
+				return fmt.Errorf("%s takes no other arguments", arg)
+			}
+			if !rebaseActive() {
+				return fmt.Errorf("no rebase in progress")
+			}
+			switch arg {
+			case "--continue":
+				return rebaseContinue()
+			case "--skip":
+				return rebaseSkip()
+			}
+			return rebaseAbort()
+		case "-i", "--interactive":
+			interactive = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown option for rebase: %s", arg)
+			}
+			upstream = append(upstream, arg)
+		}
+	}
+	if rebaseActive() {
+		return fmt.Errorf("a rebase is already in progress, use 'gitre rebase --continue', '--skip' or '--abort'")
+	}
+	if len(upstream) != 1 {
+		return fmt.Errorf("usage: gitre rebase [-i] <upstream>")
+	}
+
+	ref, _, err := repo.ReadHead()
+	if err != nil {
+		return err
+	}
+	headHash, err := requireCleanHead("rebase")
+	if err != nil {
+		return err
+	}
+	onto, err := repo.ResolveRev(upstream[0])
+	if err != nil {
+		return err
+	}
+	base, err := repo.MergeBase(headHash, onto)
+	if err != nil {
+		return err
+	}
+	if !interactive && base == onto {
+		fmt.Println("Current branch is up to date.")
+		return nil
+	}
+
+	// first-parent commits of HEAD that upstream does not contain, oldest first; merges are dropped
+	upstreamCommits, err := repo.Ancestors(onto)
+	if err != nil {
+		return err
+	}
+	var steps []rebaseStep
+	for h := headHash; h != "" && !upstreamCommits[h]; {
+		c, err := repo.ReadCommit(h)
+		if err != nil {
+			return err
+		}
+		if len(c.Parents) <= 1 {
+			steps = append([]rebaseStep{{"pick", h}}, steps...)
+		}
+		h = ""
+		if len(c.Parents) > 0 {
+			h = c.Parents[0]
+		}
+	}
+
+	if err := os.MkdirAll(rebaseDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create rebase directory: %w", err)
+	}
+	headName := ref
+	if headName == "" {
+		headName = "detached HEAD"
+	}
+	for name, value := range map[string]string{"head-name": headName, "orig-head": headHash, "onto": onto} {
+		if err := writeRebaseFile(name, value+"\n"); err != nil {
+			return err
+		}
+	}
+
+	if interactive {
+		if steps, err = editRebaseTodo(steps, base, headHash, onto); err != nil {
+			os.RemoveAll(rebaseDir())
+			return err
+		}
+		if len(steps) == 0 {
+			os.RemoveAll(rebaseDir())
+			fmt.Println("Nothing to do")
+			return nil
+		}
+	}
+	if err := writeRebaseTodo(steps); err != nil {
+		return err
+	}
+
+	// replay on a detached HEAD, the branch only moves once every step is done
+	ontoEntries, err := repo.ReadCommitTree(onto)
+	if err != nil {
+		return err
+	}
+	if err := resetHard(headHash, ontoEntries); err != nil {
+		return err
+	}
+	if err := os.WriteFile(repo.Path("HEAD"), []byte(onto+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to detach HEAD: %w", err)
+	}
+	return runRebase()
+}
+
+func rebaseActive() bool {
+	_, err := os.Stat(rebaseDir())
+	return err == nil
+}
+
+func readRebaseFile(name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(rebaseDir(), name))
+	if err != nil {
+		return "", fmt.Errorf("failed to read rebase state %s: %w", name, err)
+	}
+	return string(data), nil
+}
+
+func writeRebaseFile(name string, content string) error {
+	if err := os.WriteFile(filepath.Join(rebaseDir(), name), []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write rebase state %s: %w", name, err)
+	}
+	return nil
+}
+
+// writes the todo list with abbreviated hashes and subjects, lets the user edit it and parses the result
+func editRebaseTodo(steps []rebaseStep, base string, headHash string, onto string) ([]rebaseStep, error) {
+	var b strings.Builder
+	for _, s := range steps {
+		c, err := repo.ReadCommit(s.Arg)
+		if err != nil {
+			return nil, err
+		}
+		subject, _, _ := strings.Cut(c.Message, "\n")
+		fmt.Fprintf(&b, "%s %s %s\n", s.Action, shortHash(s.Arg), subject)
+	}
+	fmt.Fprintf(&b, "\n# Rebase %s..%s onto %s (%d commands)\n", shortHash(base), shortHash(headHash), shortHash(onto), len(steps))
+	b.WriteString(rebaseTodoHelp)
+
+	text, err := editText(filepath.Join(rebaseDir(), "git-rebase-todo"), b.String())
+	if err != nil {
+		return nil, err
+	}
+	return parseRebaseTodo(text)
+}
+
+// parses "<action> <commit> [subject]" and "exec <command>" lines, comments and blank lines are skipped
+func parseRebaseTodo(text string) ([]rebaseStep, error) {
+	var steps []rebaseStep
+	for line := range strings.SplitSeq(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		word, rest, _ := strings.Cut(line, " ")
+		action := word
+		if full, ok := rebaseActions[word]; ok {
+			action = full
+		}
+		rest = strings.TrimSpace(rest)
+		switch action {
+		case "exec":
+			if rest == "" {
+				return nil, fmt.Errorf("missing command after exec")
+			}
+			steps = append(steps, rebaseStep{action, rest})
+			continue
+		case "pick", "reword", "edit", "squash", "fixup", "drop":
+		default:
+			return nil, fmt.Errorf("invalid rebase command: %s", word)
+		}
+		rev, _, _ := strings.Cut(rest, " ")
+		if rev == "" {
+			return nil, fmt.Errorf("missing commit after %s", action)
+		}
+		hash, err := repo.ResolveRev(rev)
+		if err != nil {
+			return nil, err
+		}
+		if (action == "squash" || action == "fixup") && !hasPickedCommit(steps) {
+			return nil, fmt.Errorf("cannot '%s' without a previous commit", action)
+		}
+		steps = append(steps, rebaseStep{action, hash})
+	}
+	return steps, nil
+}
+
+func hasPickedCommit(steps []rebaseStep) bool {
+	for _, s := range steps {
+		if s.Action != "exec" && s.Action != "drop" {
+			return true
+		}
+	}
+	return false
+}
+
+func readRebaseTodo() ([]rebaseStep, error) {
+	text, err := readRebaseFile("todo")
+	if err != nil {
+		return nil, err
+	}
+	var steps []rebaseStep
+	for line := range strings.SplitSeq(text, "\n") {
+		if line == "" {
+			continue
+		}
+		action, arg, _ := strings.Cut(line, " ")
+		steps = append(steps, rebaseStep{action, arg})
+	}
+	return steps, nil
+}
+
+func writeRebaseTodo(steps []rebaseStep) error {
+	var b strings.Builder
+	for _, s := range steps {
+		b.WriteString(s.String() + "\n")
+	}
+	return writeRebaseFile("todo", b.String())
+}
+
+// executes the remaining steps until the todo is empty or a step stops
+func runRebase() error {
+	for {
+		steps, err := readRebaseTodo()
+		if err != nil {
+			return err
+		}
+		if len(steps) == 0 {
+			return finishRebase()
+		}
+		step := steps[0]
+		if err := writeRebaseTodo(steps[1:]); err != nil {
+			return err
+		}
+		done, _ := os.ReadFile(filepath.Join(rebaseDir(), "done"))
+		if err := writeRebaseFile("done", string(done)+step.String()+"\n"); err != nil {
+			return err
+		}
+
+		stopped, err := runRebaseStep(step)
+		if err != nil || stopped {
+			return err
+		}
+	}
+}
+
+// runs one todo step, reporting whether the rebase stops to let the user work
+func runRebaseStep(step rebaseStep) (bool, error) {
+	switch step.Action {
+	case "drop":
+		return false, nil
+	case "exec":
+		fmt.Printf("Executing: %s\n", step.Arg)
+		cmd := exec.Command("sh", "-c", step.Arg)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return true, fmt.Errorf("execution failed: %s: %w\nfix it and run 'gitre rebase --continue'", step.Arg, err)
+		}
+		return false, nil
+	}
+
+	_, headHash, err := repo.ReadHead()
+	if err != nil {
+		return false, err
+	}
+	c, err := repo.ReadCommit(step.Arg)
+	if err != nil {
+		return false, err
+	}
+	// a commit already sitting on HEAD is reused instead of recreated
+	if (step.Action == "pick" || step.Action == "edit") && len(c.Parents) == 1 && c.Parents[0] == headHash {
+		if err := resetToCommit(step.Arg); err != nil {
+			return false, err
+		}
+		return rebaseStopForEdit(step)
+	}
+
+	message, conflicts, err := mergeCommit("pick", step.Arg)
+	if err != nil {
+		return false, err
+	}
+	if step.Action == "squash" || step.Action == "fixup" {
+		head, err := repo.ReadCommit(headHash)
+		if err != nil {
+			return false, err
+		}
+		if step.Action == "fixup" {
+			message = head.Message
+		} else {
+			message = strings.TrimRight(head.Message, "\n") + "\n\n" + message
+		}
+	}
+	if len(conflicts) > 0 {
+		if err := writeRebaseFile("message", message); err != nil {
+			return false, err
+		}
+		if err := writeRebaseFile("stopped", step.String()+"\n"); err != nil {
+			return false, err
+		}
+		return true, stopOnConflicts(step.Arg, conflicts, "rebase")
+	}
+	return commitRebaseStep(step, message)
+}
+
+// commits the merged index of a step, amending HEAD for squash and fixup
+func commitRebaseStep(step rebaseStep, message string) (bool, error) {
+	if step.Action == "reword" || step.Action == "squash" {
+		edited, err := editText(repo.Path("COMMIT_EDITMSG"), message)
+		if err != nil {
+			return true, err
+		}
+		if edited == "" {
+			return true, fmt.Errorf("aborting commit due to empty commit message, fix it and run 'gitre rebase --continue'")
+		}
+		message = edited
+	}
+	if step.Action == "squash" || step.Action == "fixup" {
+		if err := amendIndex(message); err != nil {
+			return true, err
+		}
+		return false, nil
+	}
+	if err := commitIndex(message); err != nil {
+		return true, err
+	}
+	return rebaseStopForEdit(step)
+}
+
+func rebaseStopForEdit(step rebaseStep) (bool, error) {
+	if step.Action != "edit" {
+		return false, nil
+	}
+	fmt.Printf("Stopped at %s\nyou can amend the commit now with 'gitre commit --amend', then run 'gitre rebase --continue'\n", shortHash(step.Arg))
+	return true, nil
+}
+
+// replaces HEAD with a commit of the index, keeping the parents of HEAD
+func amendIndex(message string) error {
+	entries, err := repo.LoadIndex()
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+	tree, err := repo.WriteTree(gitre.BuildTree(entries))
+	if err != nil {
+		return fmt.Errorf("failed to write tree objects: %w", err)
+	}
+	_, headHash, err := repo.ReadHead()
+	if err != nil {
+		return err
+	}
+	head, err := repo.ReadCommit(headHash)
+	if err != nil {
+		return err
+	}
+	commitHash, err := repo.WriteCommit(tree, head.Parents, message)
+	if err != nil {
+		return err
+	}
+	if err := repo.UpdateHead(commitHash); err != nil {
+		return fmt.Errorf("failed to update ref: %w", err)
+	}
+	subject, _, _ := strings.Cut(message, "\n")
+	fmt.Printf("[%s] %s\n", commitHash[:7], subject)
+	return nil
+}
+
+// points the rebased branch at the new HEAD and attaches HEAD to it again
+func finishRebase() error {
+	headName, err := readRebaseFile("head-name")
+	if err != nil {
+		return err
+	}
+	headName = strings.TrimSpace(headName)
+	_, headHash, err := repo.ReadHead()
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(headName, "refs/") {
+		if err := repo.UpdateRef(headName, headHash); err != nil {
+			return fmt.Errorf("failed to update %s: %w", headName, err)
+		}
+		if err := os.WriteFile(repo.Path("HEAD"), []byte("ref: "+headName+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to update HEAD: %w", err)
+		}
+	}
+	if err := os.RemoveAll(rebaseDir()); err != nil {
+		return fmt.Errorf("failed to remove rebase state: %w", err)
+	}
+	fmt.Printf("Successfully rebased and updated %s.\n", headName)
+	return nil
+}
+
+// commits the resolution of a stopped step, then replays the rest of the todo
+func rebaseContinue() error {
+	conflicts, err := repo.ReadConflicts()
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("unresolved conflicts in:\n\t%s\nfix them and mark them with 'gitre add <path>'", strings.Join(conflicts, "\n\t"))
+	}
+
+	if line, err := readRebaseFile("stopped"); err == nil {
+		message, err := readRebaseFile("message")
+		if err != nil {
+			return err
+		}
+		action, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+		os.Remove(filepath.Join(rebaseDir(), "stopped"))
+		os.Remove(filepath.Join(rebaseDir(), "message"))
+		stopped, err := commitRebaseStep(rebaseStep{action, arg}, message)
+		if err != nil || stopped {
+			return err
+		}
+	} else if staged, err := stagedChanges(); err != nil {
+		return err
+	} else if staged {
+		return fmt.Errorf("you have staged changes, commit them before running 'gitre rebase --continue'")
+	}
+	return runRebase()
+}
+
+// reports whether the index differs from the HEAD commit
+func stagedChanges() (bool, error) {
+	_, headHash, err := repo.ReadHead()
+	if err != nil {
+		return false, err
+	}
+	headEntries, err := repo.ReadCommitTree(headHash)
+	if err != nil {
+		return false, err
+	}
+	entries, err := repo.LoadIndex()
+	if err != nil {
+		return false, fmt.Errorf("failed to load index: %w", err)
+	}
+	return !sameTree(headEntries, entries), nil
+}
+
+// throws away the stopped step and replays the rest of the todo
+func rebaseSkip() error {
+	if err := resetToCommit("HEAD"); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(rebaseDir(), "stopped"))
+	os.Remove(filepath.Join(rebaseDir(), "message"))
+	if err := repo.WriteConflicts(nil); err != nil {
+		return err
+	}
+	return runRebase()
+}
+
+// restores the branch, index and working tree from before the rebase
+func rebaseAbort() error {
+	origHead, err := readRebaseFile("orig-head")
+	if err != nil {
+		return err
+	}
+	headName, err := readRebaseFile("head-name")
+	if err != nil {
+		return err
+	}
+	origHead, headName = strings.TrimSpace(origHead), strings.TrimSpace(headName)
+	if err := resetToCommit(origHead); err != nil {
+		return err
+	}
+	if strings.HasPrefix(headName, "refs/") {
+		if err := repo.UpdateRef(headName, origHead); err != nil {
+			return fmt.Errorf("failed to update %s: %w", headName, err)
+		}
+		if err := os.WriteFile(repo.Path("HEAD"), []byte("ref: "+headName+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to update HEAD: %w", err)
+		}
+	}
+	if err := repo.WriteConflicts(nil); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(rebaseDir()); err != nil {
+		return fmt.Errorf("failed to remove rebase state: %w", err)
+	}
+	return nil
+}
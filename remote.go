@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitre/gitre"
+)
+
+// remote [-v] | remote add <name> <url> | remote remove <name> | remote rename <old> <new> | remote show <name>
+func remote(args []string) error {
+	if len(args) == 0 || args[0] == "-v" || args[0] == "--verbose" || args[0] == "list" {
+		return remoteList(len(args) > 0 && args[0] != "list")
+	}
+	cfg, err := repo.ReadConfig()
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "add":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: gitre remote add <name> <url>")
+		}
+		name, url := args[1], args[2]
+		if err := checkRemoteName(name); err != nil {
+			return err
+		}
+		if cfg.Get("remote."+name+".url") != "" {
+			return fmt.Errorf("remote %s already exists", name)
+		}
+		// local paths are stored absolute so the remote works from anywhere in the working tree
+		if !strings.Contains(url, "://") {
+			if url, err = filepath.Abs(userPath(url)); err != nil {
+				return fmt.Errorf("invalid url %s: %w", args[2], err)
+			}
+		}
+		cfg.Set("remote."+name+".url", url)
+		return cfg.Write()
+	case "remove", "rm":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: gitre remote remove <name>")
+		}
+		name := args[1]
+		if err := checkRemoteName(name); err != nil {
+			return err
+		}
+		if !cfg.RemoveSection("remote", name) {
+			return fmt.Errorf("no such remote: '%s'", name)
+		}
+		for _, branch := range cfg.Subsections("branch") {
+			if cfg.Get("branch."+branch+".remote") == name {
+				cfg.Unset("branch." + branch + ".remote")
+				cfg.Unset("branch." + branch + ".merge")
+			}
+		}
+		if err := cfg.Write(); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(repo.Path("refs", "remotes", name)); err != nil {
+			return fmt.Errorf("failed to remove remote-tracking branches: %w", err)
+		}
+		return nil
+	case "rename":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: gitre remote rename <old> <new>")
+		}
+		oldName, newName := args[1], args[2]
+		for _, name := range []string{oldName, newName} {
+			if err := checkRemoteName(name); err != nil {
+				return err
+			}
+		}
+		if cfg.Get("remote."+oldName+".url") == "" {
+			return fmt.Errorf("no such remote: '%s'", oldName)
+		}
+		if cfg.Get("remote."+newName+".url") != "" {
+			return fmt.Errorf("remote %s already exists", newName)
+		}
+		cfg.RenameSection("remote", oldName, newName)
+		for _, branch := range cfg.Subsections("branch") {
+			if cfg.Get("branch."+branch+".remote") == oldName {
+				cfg.Set("branch."+branch+".remote", newName)
+			}
+		}
+		if err := cfg.Write(); err != nil {
+			return err
+		}
+		err := os.Rename(repo.Path("refs", "remotes", oldName), repo.Path("refs", "remotes", newName))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rename remote-tracking branches: %w", err)
+		}
+		return nil
+	case "show":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: gitre remote show <name>")
+		}
+		return remoteShow(cfg, args[1])
+	}
+	return fmt.Errorf("unknown remote subcommand: %s", args[0])
+}
+
+func checkRemoteName(name string) error {
+	if strings.Contains(name, "/") || gitre.CheckRefName("refs/remotes/"+name) != nil {
+		return fmt.Errorf("'%s' is not a valid remote name", name)
+	}
+	return nil
+}
+
+// branch names become files under refs/heads and config sections
+func checkBranchName(name string) error {
+	if strings.HasPrefix(name, "-") || gitre.CheckRefName("refs/heads/"+name) != nil {
+		return fmt.Errorf("'%s' is not a valid branch name", name)
+	}
+	return nil
+}
+
+func remoteList(verbose bool) error {
+	cfg, err := repo.ReadConfig()
+	if err != nil {
+		return err
+	}
+	for _, name := range cfg.Subsections("remote") {
+		if !verbose {
+			fmt.Println(name)
+			continue
+		}
+		url := cfg.Get("remote." + name + ".url")
+		fmt.Printf("%s\t%s (fetch)\n%s\t%s (push)\n", name, url, name, url)
+	}
+	return nil
+}
+
+// describes a remote: its url, the branch its HEAD points at, its branches and the local branches tracking them
+func remoteShow(cfg *gitre.Config, name string) error {
+	url := cfg.Get("remote." + name + ".url")
+	if url == "" {
+		return fmt.Errorf("no such remote: '%s'", name)
+	}
+	fmt.Printf("* remote %s\n  URL: %s\n", name, url)
+	t, _, err := openRemote(name)
+	if err != nil {
+		return err
+	}
+	refs, head, err := t.Refs()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  HEAD branch: %s\n", strings.TrimPrefix(head, "refs/heads/"))
+
+	fmt.Println("  Remote branches:")
+	for _, ref := range gitre.SortedRefs(refs) {
+		branch, ok := strings.CutPrefix(ref, "refs/heads/")
+		if !ok {
+			continue
+		}
+		state := "new (next fetch will store in remotes/" + name + ")"
+		if tracked, _ := repo.ReadRef("refs/remotes/" + name + "/" + branch); tracked != "" {
+			state = "tracked"
+		}
+		fmt.Printf("    %s %s\n", branch, state)
+	}
+
+	var tracking []string
+	for _, branch := range cfg.Subsections("branch") {
+		if cfg.Get("branch."+branch+".remote") == name {
+			merge := strings.TrimPrefix(cfg.Get("branch."+branch+".merge"), "refs/heads/")
+			tracking = append(tracking, fmt.Sprintf("    %s merges with remote %s", branch, merge))
+		}
+	}
+	if len(tracking) > 0 {
+		fmt.Println("  Local branches configured for 'gitre pull':")
+		fmt.Println(strings.Join(tracking, "\n"))
+	}
+	return nil
+}
+
+// branch [-v] | branch <name> [<start>] | branch -d|-D <name> | branch --set-upstream-to=<upstream> [<name>]
+// | branch --unset-upstream [<name>]
+func branch(args []string) error {
+	if len(args) == 0 || args[0] == "-v" || args[0] == "-vv" || args[0] == "--list" {
+		return branchList(len(args) > 0 && args[0] != "--list")
+	}
+	current, headHash, err := repo.ReadHead()
+	if err != nil {
+		return err
+	}
+	currentBranch := strings.TrimPrefix(current, "refs/heads/")
+	// the branch an option applies to, the current one unless named
+	target := func(rest []string) (string, error) {
+		switch {
+		case len(rest) > 1:
+			return "", fmt.Errorf("too many arguments to branch")
+		case len(rest) == 1:
+			return rest[0], checkBranchName(rest[0])
+		case currentBranch == "":
+			return "", fmt.Errorf("HEAD is detached, name the branch")
+		}
+		return currentBranch, nil
+	}
+
+	switch arg := args[0]; {
+	case arg == "-u" || arg == "--set-upstream-to" || strings.HasPrefix(arg, "--set-upstream-to="):
+		upstream, rest := strings.TrimPrefix(arg, "--set-upstream-to="), args[1:]
+		if !strings.HasPrefix(arg, "--set-upstream-to=") {
+			if len(rest) == 0 {
+				return fmt.Errorf("%s requires an upstream branch", arg)
+			}
+			upstream, rest = rest[0], rest[1:]
+		}
+		name, err := target(rest)
+		if err != nil {
+			return err
+		}
+		return setUpstream(name, upstream)
+	case arg == "--unset-upstream":
+		name, err := target(args[1:])
+		if err != nil {
+			return err
+		}
+		cfg, err := repo.ReadConfig()
+		if err != nil {
+			return err
+		}
+		if cfg.Get("branch."+name+".remote") == "" {
+			return fmt.Errorf("branch '%s' has no upstream information", name)
+		}
+		cfg.Unset("branch." + name + ".remote")
+		cfg.Unset("branch." + name + ".merge")
+		return cfg.Write()
+	case arg == "-d" || arg == "-D" || arg == "--delete":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: gitre branch -d <name>")
+		}
+		name := args[1]
+		if err := checkBranchName(name); err != nil {
+			return err
+		}
+		if name == currentBranch {
+			return fmt.Errorf("cannot delete branch '%s' checked out", name)
+		}
+		hash, err := repo.ReadRef("refs/heads/" + name)
+		if err != nil {
+			return err
+		}
+		if hash == "" {
+			return fmt.Errorf("branch '%s' not found", name)
+		}
+		if arg != "-D" {
+			if merged, err := repo.IsAncestor(hash, headHash); err != nil {
+				return err
+			} else if !merged {
+				return fmt.Errorf("the branch '%s' is not fully merged (use -D to delete it anyway)", name)
+			}
+		}
+		if err := os.Remove(repo.Path("refs", "heads", name)); err != nil {
+			return fmt.Errorf("failed to delete branch: %w", err)
+		}
+		fmt.Printf("Deleted branch %s (was %s).\n", name, shortHash(hash))
+		return nil
+	case strings.HasPrefix(arg, "-"):
+		return fmt.Errorf("unknown option for branch: %s", arg)
+	}
+
+	if len(args) > 2 {
+		return fmt.Errorf("usage: gitre branch <name> [<start>]")
+	}
+	name := args[0]
+	if err := checkBranchName(name); err != nil {
+		return err
+	}
+	if existing, err := repo.ReadRef("refs/heads/" + name); err != nil {
+		return err
+	} else if existing != "" {
+		return fmt.Errorf("branch '%s' already exists", name)
+	}
+	start := "HEAD"
+	if len(args) == 2 {
+		start = args[1]
+	}
+	hash, err := repo.ResolveRev(start)
+	if err != nil {
+		return err
+	}
+	return repo.UpdateRef("refs/heads/"+name, hash)
+}
+
+func branchList(verbose bool) error {
+	refs, err := repo.ListRefs("refs/heads/")
+	if err != nil {
+		return err
+	}
+	current, _, err := repo.ReadHead()
+	if err != nil {
+		return err
+	}
+	for _, ref := range gitre.SortedRefs(refs) {
+		name := strings.TrimPrefix(ref, "refs/heads/")
+		marker := " "
+		if ref == current {
+			marker = "*"
+		}
+		if !verbose {
+			fmt.Printf("%s %s\n", marker, name)
+			continue
+		}
+		line := fmt.Sprintf("%s %s %s", marker, name, shortHash(refs[ref]))
+		if upstream := repo.BranchUpstream(name); upstream != "" {
+			info := strings.TrimPrefix(upstream, "refs/remotes/")
+			if upstreamHash, _ := repo.ReadRef(upstream); upstreamHash == "" {
+				info += ": gone"
+			} else if ahead, behind, err := repo.AheadBehind(refs[ref], upstreamHash); err == nil {
+				var counts []string
+				if ahead > 0 {
+					counts = append(counts, fmt.Sprintf("ahead %d", ahead))
+				}
+				if behind > 0 {
+					counts = append(counts, fmt.Sprintf("behind %d", behind))
+				}
+				if len(counts) > 0 {
+					info += ": " + strings.Join(counts, ", ")
+				}
+			}
+			line += " [" + info + "]"
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// makes branch track upstream, given as <remote>/<branch> and already fetched
+func setUpstream(branch string, upstream string) error {
+	if hash, err := repo.ReadRef("refs/heads/" + branch); err != nil {
+		return err
+	} else if hash == "" {
+		return fmt.Errorf("branch '%s' does not exist", branch)
+	}
+	upstream = strings.TrimPrefix(upstream, "refs/remotes/")
+	remoteName, remoteBranch, ok := strings.Cut(upstream, "/")
+	if !ok || remoteURL(remoteName) == "" || checkBranchName(remoteBranch) != nil {
+		return fmt.Errorf("the requested upstream branch '%s' is not a remote-tracking branch", upstream)
+	}
+	if hash, err := repo.ReadRef("refs/remotes/" + upstream); err != nil {
+		return err
+	} else if hash == "" {
+		return fmt.Errorf("the requested upstream branch '%s' does not exist (fetch %s first)", upstream, remoteName)
+	}
+
+	cfg, err := repo.ReadConfig()
+	if err != nil {
+		return err
+	}
+	cfg.Set("branch."+branch+".remote", remoteName)
+	cfg.Set("branch."+branch+".merge", "refs/heads/"+remoteBranch)
+	if err := cfg.Write(); err != nil {
+		return err
+	}
+	fmt.Printf("branch '%s' set up to track '%s'.\n", branch, upstream)
+	return nil
+}
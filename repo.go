@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitre/gitre"
+)
+
+// the repository every command works on, set by discoverRepo
+var repo *gitre.Repository
+
+// the directory gitre was started in, relative to the top of the working tree ("" at the top)
+var cwdPrefix string
+
+// opens the repository from $GITRE_DIR or by walking up from the current directory, then moves to the
+// top of the working tree ($GITRE_WORK_TREE, or the directory holding .gitre) so every path is relative to it
+func discoverRepo() error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+	workTree := os.Getenv("GITRE_WORK_TREE")
+
+	if dir := os.Getenv("GITRE_DIR"); dir != "" {
+		if workTree == "" {
+			workTree = cwd
+		}
+		repo, err = gitre.OpenDir(dir, workTree)
+	} else if repo, err = gitre.Open(cwd); err == nil && workTree != "" {
+		repo, err = gitre.OpenDir(repo.GitDir, workTree)
+	}
+	if err != nil {
+		return err
+	}
+	if repo.IsBare() {
+		return nil
+	}
+	return enterWorkTree(cwd)
+}
+
+func enterWorkTree(cwd string) error {
+	root := repo.WorkTree
+	if err := os.Chdir(root); err != nil {
+		return fmt.Errorf("failed to enter working tree: %w", err)
+	}
+	if rel, err := filepath.Rel(root, cwd); err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		cwdPrefix = filepath.ToSlash(rel)
+	}
+	return nil
+}
+
+// translates a path given relative to the directory gitre was started in into one relative to the top
+// of the working tree
+func userPath(p string) string {
+	if filepath.IsAbs(p) {
+		if rel, err := filepath.Rel(repo.WorkTree, p); err == nil {
+			return filepath.ToSlash(rel)
+		}
+		return p
+	}
+	if cwdPrefix == "" {
+		return p
+	}
+	return filepath.ToSlash(filepath.Join(cwdPrefix, p))
+}
+
+func userPaths(paths []string) []string {
+	translated := make([]string, len(paths))
+	for i, p := range paths {
+		translated[i] = userPath(p)
+	}
+	return translated
+}
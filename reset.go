@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gitre/gitre"
+)
+
+// reset [--soft|--mixed|--hard] [<rev>] or reset [<rev>] [--] <paths>
+func reset(args []string) error {
+	mode := ""
+	var rest, paths []string
+	dashdash := false
+	for i, arg := range args {
+		switch arg {
+		case "--soft", "--mixed", "--hard":
+			if mode != "" {
+				return fmt.Errorf("only one of --soft, --mixed or --hard can be given")
+			}
+			mode = strings.TrimPrefix(arg, "--")
+		case "--":
+			paths = append(paths, args[i+1:]...)
+			dashdash = true
+		default:
+			rest = append(rest, arg)
+			continue
+		}
+		if dashdash {
+			break
+		}
+	}
+
+	rev := "HEAD"
+	if len(rest) > 0 {
+		// with a mode only a revision can follow, its resolution error is the one to report
+		if _, err := repo.ResolveRev(rest[0]); err == nil || dashdash || mode != "" {
+			rev = rest[0]
+			rest = rest[1:]
+		}
+	}
+	if dashdash && len(rest) > 0 {
+		return fmt.Errorf("unexpected arguments before '--': %s", strings.Join(rest, " "))
+	}
+	paths = userPaths(append(paths, rest...))
+
+	if len(paths) > 0 {
+		if mode != "" {
+			return fmt.Errorf("cannot do a --%s reset with paths", mode)
+		}
+		return resetPaths(rev, paths)
+	}
+	if mode == "" {
+		mode = "mixed"
+	}
+	return resetCommit(mode, rev)
+}
+
+// moves the current branch to rev, rebuilding the index (mixed) and working tree (hard)
+func resetCommit(mode string, rev string) error {
+	target, err := repo.ResolveRev(rev)
+	if err != nil {
+		return err
+	}
+	commit, err := repo.ReadCommit(target)
+	if err != nil {
+		return err
+	}
+	_, headHash, err := repo.ReadHead()
+	if err != nil {
+		return err
+	}
+
+	if mode != "soft" {
+		targetEntries, err := repo.ReadTree(commit.Tree)
+		if err != nil {
+			return err
+		}
+		if mode == "hard" {
+			err = resetHard(headHash, targetEntries)
+		} else {
+			err = resetIndex(targetEntries)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := repo.UpdateHead(target); err != nil {
+		return fmt.Errorf("failed to update ref: %w", err)
+	}
+
+	if mode == "hard" {
+		subject, _, _ := strings.Cut(commit.Message, "\n")
+		fmt.Printf("HEAD is now at %s %s\n", target[:7], subject)
+	}
+	return nil
+}
+
+// rebuilds the index from targetEntries, leaving the working tree alone
+func resetIndex(targetEntries map[string]gitre.IndexEntry) error {
+	indexEntries, err := repo.LoadIndex()
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+	return repo.WriteIndex(resetEntries(indexEntries, targetEntries, false))
+}
+
+// rewrites index and working tree to targetEntries, removing files tracked by HEAD or the index that it lacks
+func resetHard(headHash string, targetEntries map[string]gitre.IndexEntry) error {
+	indexEntries, err := repo.LoadIndex()
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+	tracked, err := repo.ReadCommitTree(headHash)
+	if err != nil {
+		return err
+	}
+	for _, e := range indexEntries {
+		tracked[e.Path] = e
+	}
+	if err := repo.UpdateWorkTree(tracked, targetEntries); err != nil {
+		return err
+	}
+	return repo.WriteIndex(resetEntries(indexEntries, targetEntries, true))
+}
+
+// restores the index entries under paths to their state in rev, leaving disk untouched
+func resetPaths(rev string, paths []string) error {
+	var targetEntries map[string]gitre.IndexEntry
+	if hash, err := repo.ResolveRev(rev); err == nil {
+		if targetEntries, err = repo.ReadCommitTree(hash); err != nil {
+			return err
+		}
+	} else if rev == "HEAD" {
+		targetEntries = map[string]gitre.IndexEntry{}
+	} else {
+		return err
+	}
+
+	indexEntries, err := repo.LoadIndex()
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+
+	var kept []gitre.IndexEntry
+	for _, e := range indexEntries {
+		if !gitre.MatchesAnyPath(e.Path, paths) {
+			kept = append(kept, e)
+		}
+	}
+	for path, e := range targetEntries {
+		if gitre.MatchesAnyPath(path, paths) {
+			kept = append(kept, e)
+		}
+	}
+
+	if err := repo.WriteIndex(kept); err != nil {
+		return err
+	}
+	for _, p := range paths {
+		fmt.Printf("unstaged %s\n", p)
+	}
+	return nil
+}
+
+// index entries for target, keeping stat data of unchanged entries or refreshing it from disk
+func resetEntries(current []gitre.IndexEntry, target map[string]gitre.IndexEntry, fromDisk bool) []gitre.IndexEntry {
+	previous := map[string]gitre.IndexEntry{}
+	for _, e := range current {
+		previous[e.Path] = e
+	}
+	var entries []gitre.IndexEntry
+	for path, e := range target {
+		if fromDisk {
+			if diskEntry, err := repo.EntryFromDisk(path, e.Hash); err == nil {
+				e = diskEntry
+			}
+		} else if prev, ok := previous[path]; ok && prev.Hash == e.Hash {
+			// the stat data of an unchanged entry still describes the file on disk
+			prev.Mode = e.Mode
+			e = prev
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
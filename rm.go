@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitre/gitre"
+)
+
+// rm [--cached] [-r] [-f] <paths>
+func rm(args []string) error {
+	cached, recursive, force := false, false, false
+	var paths []string
+	for _, arg := range args {
+		switch arg {
+		case "--cached":
+			cached = true
+		case "-r":
+			recursive = true
+		case "-f", "--force":
+			force = true
+		default:
+			paths = append(paths, userPath(arg))
+		}
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("usage: gitre rm [--cached] [-r] [-f] <paths>")
+	}
+
+	entries, err := repo.LoadIndex()
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+
+	removed := map[string]bool{}
+	for _, p := range paths {
+		clean := gitre.CleanPath(p)
+		matched := false
+		for _, e := range entries {
+			if e.Path == clean {
+				removed[e.Path] = true
+				matched = true
+			} else if gitre.MatchesAnyPath(e.Path, []string{clean}) {
+				if !recursive {
+					return fmt.Errorf("not removing '%s' recursively without -r", p)
+				}
+				removed[e.Path] = true
+				matched = true
+			}
+		}
+		if !matched {
+			return fmt.Errorf("pathspec '%s' did not match any tracked files", p)
+		}
+	}
+
+	var kept []gitre.IndexEntry
+	for _, e := range entries {
+		if !removed[e.Path] {
+			kept = append(kept, e)
+			continue
+		}
+		if cached || force {
+			continue
+		}
+		if data, _, err := repo.ReadWorktreeFile(e.Path); err == nil {
+			if hash, _ := gitre.HashObject(data, "blob"); hash != e.Hash {
+				return fmt.Errorf("'%s' has local modifications (use --cached to keep the file, or -f to force removal)", e.Path)
+			}
+		}
+	}
+
+	if err := repo.WriteIndex(kept); err != nil {
+		return err
+	}
+	var resolved []string
+	for path := range removed {
+		resolved = append(resolved, path)
+	}
+	if err := repo.ResolveConflicts(resolved); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !removed[e.Path] {
+			continue
+		}
+		if !cached {
+			if err := os.Remove(e.Path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", e.Path, err)
+			}
+			repo.RemoveEmptyDirs(filepath.Dir(e.Path))
+		}
+		fmt.Printf("rm '%s'\n", e.Path)
+	}
+	return nil
+}
+
+// mv <src> <dst>
+func mv(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: gitre mv <src> <dst>")
+	}
+	src, dst := gitre.CleanPath(userPath(args[0])), gitre.CleanPath(userPath(args[1]))
+	if info, err := os.Stat(dst); err == nil && info.IsDir() {
+		dst = gitre.CleanPath(filepath.Join(dst, filepath.Base(src)))
+	} else if err == nil {
+		return fmt.Errorf("destination '%s' already exists", args[1])
+	}
+	if _, err := os.Lstat(src); err != nil {
+		return fmt.Errorf("bad source '%s': %w", args[0], err)
+	}
+	if gitre.MatchesAnyPath(dst, []string{src}) {
+		return fmt.Errorf("cannot move '%s' into itself", args[0])
+	}
+
+	entries, err := repo.LoadIndex()
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+	// a tracked destination that is missing from disk is replaced rather than listed twice
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.Path != dst && !strings.HasPrefix(e.Path, dst+"/") {
+			kept = append(kept, e)
+		}
+	}
+	entries = kept
+	moved := false
+	for i, e := range entries {
+		if e.Path == src {
+			entries[i].Path = dst
+			moved = true
+		} else if rest, ok := strings.CutPrefix(e.Path, src+"/"); ok {
+			entries[i].Path = dst + "/" + rest
+			moved = true
+		}
+	}
+	if !moved {
+		return fmt.Errorf("not under version control: '%s'", args[0])
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", dst, err)
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return fmt.Errorf("failed to move %s to %s: %w", src, dst, err)
+	}
+	if err := repo.WriteIndex(entries); err != nil {
+		return err
+	}
+	fmt.Printf("renamed %s -> %s\n", src, dst)
+	return nil
+}
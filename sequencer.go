@@ -0,0 +1,422 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitre/gitre"
+)
+
+// state of a running cherry-pick or revert: the remaining steps and the HEAD to go back to on --abort
+func sequencerDir() string {
+	return repo.Path("sequencer")
+}
+
+type sequencerStep struct {
+	Action string // pick or revert
+	Hash   string
+}
+
+func cherryPick(args []string) error {
+	return sequence("pick", args)
+}
+
+func revert(args []string) error {
+	return sequence("revert", args)
+}
+
+// cherry-pick|revert <rev>... or cherry-pick|revert --continue|--skip|--abort
+func sequence(action string, args []string) error {
+	command := sequencerCommand(action)
+	if len(args) == 1 {
+		switch args[0] {
+		case "--continue":
+			return sequencerContinue()
+		case "--skip":
+			return sequencerSkip()
+		case "--abort":
+			return sequencerAbort()
+		}
+	}
+	if sequencerActive() {
+		return fmt.Errorf("a cherry-pick or revert is already in progress, use 'gitre %s --continue', '--skip' or '--abort'", command)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("usage: gitre %s [--continue|--skip|--abort] <rev>...", command)
+	}
+
+	var steps []sequencerStep
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("unknown option for %s: %s", command, arg)
+		}
+		hash, err := repo.ResolveRev(arg)
+		if err != nil {
+			return err
+		}
+		steps = append(steps, sequencerStep{action, hash})
+	}
+
+	headHash, err := requireCleanHead(command)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(sequencerDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create sequencer directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(sequencerDir(), "head"), []byte(headHash+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write sequencer state: %w", err)
+	}
+	if err := writeTodo(steps); err != nil {
+		return err
+	}
+	return runSequencer()
+}
+
+// HEAD commit, refusing to go on before the first commit or with uncommitted changes to tracked files
+func requireCleanHead(command string) (string, error) {
+	_, headHash, err := repo.ReadHead()
+	if err != nil {
+		return "", err
+	}
+	if headHash == "" {
+		return "", fmt.Errorf("cannot %s before the first commit", command)
+	}
+	result, err := repo.Status(false)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range result.Entries {
+		if e.Index != "?" {
+			return "", fmt.Errorf("your local changes would be overwritten by %s, commit or stash them first", command)
+		}
+	}
+	return headHash, nil
+}
+
+func sequencerCommand(action string) string {
+	if action == "revert" {
+		return "revert"
+	}
+	return "cherry-pick"
+}
+
+func sequencerActive() bool {
+	_, err := os.Stat(sequencerDir())
+	return err == nil
+}
+
+func readTodo() ([]sequencerStep, error) {
+	data, err := os.ReadFile(filepath.Join(sequencerDir(), "todo"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sequencer todo: %w", err)
+	}
+	var steps []sequencerStep
+	for line := range strings.SplitSeq(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		action, hash, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid sequencer todo line: %q", line)
+		}
+		steps = append(steps, sequencerStep{action, hash})
+	}
+	return steps, nil
+}
+
+func writeTodo(steps []sequencerStep) error {
+	var b strings.Builder
+	for _, s := range steps {
+		fmt.Fprintf(&b, "%s %s\n", s.Action, s.Hash)
+	}
+	if err := os.WriteFile(filepath.Join(sequencerDir(), "todo"), []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write sequencer todo: %w", err)
+	}
+	return nil
+}
+
+// applies the remaining steps one by one, stopping at the first conflict
+func runSequencer() error {
+	for {
+		steps, err := readTodo()
+		if err != nil {
+			return err
+		}
+		if len(steps) == 0 {
+			return clearSequencer()
+		}
+		if err := writeTodo(steps[1:]); err != nil {
+			return err
+		}
+		if err := applyStep(steps[0]); err != nil {
+			return err
+		}
+	}
+}
+
+// merges the change introduced by a commit (or its inverse for revert) into HEAD and commits it
+func applyStep(step sequencerStep) error {
+	message, conflicts, err := mergeCommit(step.Action, step.Hash)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) == 0 {
+		return commitIndex(message)
+	}
+
+	if err := os.WriteFile(repo.Path(stepHeadFile(step.Action)), []byte(step.Hash+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", stepHeadFile(step.Action), err)
+	}
+	if err := os.WriteFile(repo.Path("MERGE_MSG"), []byte(message), 0644); err != nil {
+		return fmt.Errorf("failed to write MERGE_MSG: %w", err)
+	}
+	return stopOnConflicts(step.Hash, conflicts, sequencerCommand(step.Action))
+}
+
+// records the conflicts of a stopped step and returns the error telling the user how to go on
+func stopOnConflicts(hash string, conflicts []string, command string) error {
+	if err := repo.WriteConflicts(conflicts); err != nil {
+		return err
+	}
+	for _, path := range conflicts {
+		fmt.Printf("CONFLICT: merge conflict in %s\n", path)
+	}
+	subject := ""
+	if c, err := repo.ReadCommit(hash); err == nil {
+		subject, _, _ = strings.Cut(c.Message, "\n")
+	}
+	return fmt.Errorf("could not apply %s... %s\nresolve the conflicts, mark them with 'gitre add <path>' and run 'gitre %s --continue'", shortHash(hash), subject, command)
+}
+
+// merges the change introduced by a commit (or its inverse for revert) into HEAD, leaving the
+// result in index and working tree; returns the message for the new commit and the conflicted paths
+func mergeCommit(action string, hash string) (string, []string, error) {
+	c, err := repo.ReadCommit(hash)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(c.Parents) > 1 {
+		return "", nil, fmt.Errorf("commit %s is a merge, which cannot be %s", shortHash(hash), map[string]string{"pick": "cherry-picked", "revert": "reverted"}[action])
+	}
+	parentTree := map[string]gitre.IndexEntry{}
+	if len(c.Parents) == 1 {
+		if parentTree, err = repo.ReadCommitTree(c.Parents[0]); err != nil {
+			return "", nil, err
+		}
+	}
+	commitTree, err := repo.ReadTree(c.Tree)
+	if err != nil {
+		return "", nil, err
+	}
+
+	subject, _, _ := strings.Cut(c.Message, "\n")
+	base, theirs, message := parentTree, commitTree, c.Message
+	if action == "revert" {
+		base, theirs = commitTree, parentTree
+		message = fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s.\n", subject, hash)
+	}
+
+	_, headHash, err := repo.ReadHead()
+	if err != nil {
+		return "", nil, err
+	}
+	ours, err := repo.ReadCommitTree(headHash)
+	if err != nil {
+		return "", nil, err
+	}
+	merged, err := repo.MergeTrees(base, ours, theirs, "HEAD", shortHash(hash)+" ("+subject+")")
+	if err != nil {
+		return "", nil, err
+	}
+	if err := checkoutMerge(ours, merged); err != nil {
+		return "", nil, err
+	}
+	return message, merged.Conflicts, nil
+}
+
+func stepHeadFile(action string) string {
+	if action == "revert" {
+		return "REVERT_HEAD"
+	}
+	return "CHERRY_PICK_HEAD"
+}
+
+// writes a merge result to disk and index; conflicted paths keep our version staged so the
+// markers on disk show up as unstaged changes
+func checkoutMerge(ours map[string]gitre.IndexEntry, merged *gitre.MergeResult) error {
+	var overwritten []string
+	for path, e := range merged.Entries {
+		if _, tracked := ours[path]; tracked {
+			continue
+		}
+		if _, err := os.Lstat(path); err == nil && !worktreeMatches(path, e, true) {
+			overwritten = append(overwritten, path)
+		}
+	}
+	if len(overwritten) > 0 {
+		return fmt.Errorf("untracked working tree files would be overwritten:\n\t%s", strings.Join(overwritten, "\n\t"))
+	}
+	if err := repo.UpdateWorkTree(ours, merged.Entries); err != nil {
+		return err
+	}
+
+	conflicted := map[string]bool{}
+	for _, path := range merged.Conflicts {
+		conflicted[path] = true
+	}
+	var entries []gitre.IndexEntry
+	for path, e := range merged.Entries {
+		if !conflicted[path] {
+			if diskEntry, err := repo.EntryFromDisk(path, e.Hash); err == nil {
+				e = diskEntry
+			}
+		} else if staged, ok := ours[path]; ok {
+			e = staged
+		}
+		entries = append(entries, e)
+	}
+	return repo.WriteIndex(entries)
+}
+
+// commits the index on top of HEAD, skipping the commit when nothing changed
+func commitIndex(message string) error {
+	entries, err := repo.LoadIndex()
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+	tree, err := repo.WriteTree(gitre.BuildTree(entries))
+	if err != nil {
+		return fmt.Errorf("failed to write tree objects: %w", err)
+	}
+	_, headHash, err := repo.ReadHead()
+	if err != nil {
+		return err
+	}
+	subject, _, _ := strings.Cut(message, "\n")
+	head, err := repo.ReadCommit(headHash)
+	if err != nil {
+		return err
+	}
+	if headTree, err := repo.ReadTree(head.Tree); err == nil && sameTree(headTree, entries) {
+		fmt.Printf("skipping %s: the change is already present\n", subject)
+		return nil
+	}
+	commitHash, err := repo.WriteCommit(tree, []string{headHash}, message)
+	if err != nil {
+		return err
+	}
+	if err := repo.UpdateHead(commitHash); err != nil {
+		return fmt.Errorf("failed to update ref: %w", err)
+	}
+	fmt.Printf("[%s] %s\n", commitHash[:7], subject)
+	return nil
+}
+
+func sameTree(tree map[string]gitre.IndexEntry, entries []gitre.IndexEntry) bool {
+	if len(tree) != len(entries) {
+		return false
+	}
+	for _, e := range entries {
+		t, ok := tree[e.Path]
+		if !ok || !gitre.SameEntry(&t, &e) {
+			return false
+		}
+	}
+	return true
+}
+
+// commits the resolved step and carries on with the remaining ones
+func sequencerContinue() error {
+	if !sequencerActive() {
+		return fmt.Errorf("no cherry-pick or revert in progress")
+	}
+	conflicts, err := repo.ReadConflicts()
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("unresolved conflicts in:\n\t%s\nfix them and mark them with 'gitre add <path>'", strings.Join(conflicts, "\n\t"))
+	}
+	if message, err := os.ReadFile(repo.Path("MERGE_MSG")); err == nil {
+		if err := commitIndex(string(message)); err != nil {
+			return err
+		}
+	}
+	if err := clearStep(); err != nil {
+		return err
+	}
+	return runSequencer()
+}
+
+// drops the stopped step, resetting to HEAD, and carries on with the remaining ones
+func sequencerSkip() error {
+	if !sequencerActive() {
+		return fmt.Errorf("no cherry-pick or revert in progress")
+	}
+	if err := resetToCommit("HEAD"); err != nil {
+		return err
+	}
+	if err := clearStep(); err != nil {
+		return err
+	}
+	return runSequencer()
+}
+
+// goes back to the HEAD from before the cherry-pick or revert started
+func sequencerAbort() error {
+	if !sequencerActive() {
+		return fmt.Errorf("no cherry-pick or revert in progress")
+	}
+	data, err := os.ReadFile(filepath.Join(sequencerDir(), "head"))
+	if err != nil {
+		return fmt.Errorf("failed to read sequencer state: %w", err)
+	}
+	if err := resetToCommit(strings.TrimSpace(string(data))); err != nil {
+		return err
+	}
+	return clearSequencer()
+}
+
+// hard reset of index, working tree and the current branch to rev
+func resetToCommit(rev string) error {
+	target, err := repo.ResolveRev(rev)
+	if err != nil {
+		return err
+	}
+	_, headHash, err := repo.ReadHead()
+	if err != nil {
+		return err
+	}
+	entries, err := repo.ReadCommitTree(target)
+	if err != nil {
+		return err
+	}
+	if err := resetHard(headHash, entries); err != nil {
+		return err
+	}
+	return repo.UpdateHead(target)
+}
+
+// removes the state of the stopped step
+func clearStep() error {
+	for _, name := range []string{"CHERRY_PICK_HEAD", "REVERT_HEAD", "MERGE_MSG"} {
+		if err := os.Remove(repo.Path(name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+	return repo.WriteConflicts(nil)
+}
+
+func clearSequencer() error {
+	if err := clearStep(); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(sequencerDir()); err != nil {
+		return fmt.Errorf("failed to remove sequencer state: %w", err)
+	}
+	return nil
+}
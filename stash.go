@@ -0,0 +1,474 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitre/gitre"
+)
+
+const stashRef = "refs/stash"
+
+// stash [push [-m <message>] [-u]] | list | show [-p] [<stash>] | apply [--index] [<stash>] | pop [--index] [<stash>] | drop [<stash>]
+func stash(args []string) error {
+	sub := "push"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		sub, args = args[0], args[1:]
+	}
+	switch sub {
+	case "push", "save":
+		return stashPush(args)
+	case "list":
+		return stashList()
+	case "show":
+		return stashShow(args)
+	case "apply":
+		return stashApply(args, false)
+	case "pop":
+		return stashApply(args, true)
+	case "drop":
+		return stashDrop(args)
+	}
+	return fmt.Errorf("unknown stash subcommand: %s", sub)
+}
+
+// records index and working tree as commits: I (index, parent HEAD), optional U (untracked files)
+// and W (working tree, parents HEAD, I and U), then resets to HEAD
+func stashPush(args []string) error {
+	message, untracked := "", false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-m", "--message":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a message", args[i])
+			}
+			i++
+			message = args[i]
+		case "-u", "--include-untracked":
+			untracked = true
+		default:
+			return fmt.Errorf("unknown option for stash push: %s", args[i])
+		}
+	}
+
+	ref, headHash, err := repo.ReadHead()
+	if err != nil {
+		return err
+	}
+	if headHash == "" {
+		return fmt.Errorf("cannot stash before the first commit")
+	}
+	head, err := repo.ReadCommit(headHash)
+	if err != nil {
+		return err
+	}
+	result, err := repo.Status(false)
+	if err != nil {
+		return err
+	}
+
+	var untrackedFiles []string
+	changed := false
+	for _, e := range result.Entries {
+		if e.Index == "?" {
+			untrackedFiles = append(untrackedFiles, e.Path)
+		} else {
+			changed = true
+		}
+	}
+	if !changed && (!untracked || len(untrackedFiles) == 0) {
+		fmt.Println("No local changes to save")
+		return nil
+	}
+
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+	if branch == "" {
+		branch = "(no branch)"
+	}
+	subject, _, _ := strings.Cut(head.Message, "\n")
+	description := fmt.Sprintf("%s: %s %s", branch, headHash[:7], subject)
+	if message == "" {
+		message = "WIP on " + description
+	} else {
+		message = "On " + branch + ": " + message
+	}
+
+	idx, err := repo.ReadIndex()
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+	indexTree, err := repo.WriteTree(gitre.BuildTree(idx.Entries))
+	if err != nil {
+		return fmt.Errorf("failed to write index tree: %w", err)
+	}
+	indexCommit, err := repo.WriteCommit(indexTree, []string{headHash}, "index on "+description)
+	if err != nil {
+		return err
+	}
+
+	// working tree state of every tracked file, deleted files are left out
+	var worktree []gitre.IndexEntry
+	for _, e := range idx.Entries {
+		stored, err := storeWorktreeFile(e.Path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		worktree = append(worktree, stored)
+	}
+	worktreeTree, err := repo.WriteTree(gitre.BuildTree(worktree))
+	if err != nil {
+		return fmt.Errorf("failed to write working tree: %w", err)
+	}
+
+	parents := []string{headHash, indexCommit}
+	if untracked && len(untrackedFiles) > 0 {
+		var entries []gitre.IndexEntry
+		for _, path := range untrackedFiles {
+			stored, err := storeWorktreeFile(path)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, stored)
+		}
+		untrackedTree, err := repo.WriteTree(gitre.BuildTree(entries))
+		if err != nil {
+			return fmt.Errorf("failed to write untracked tree: %w", err)
+		}
+		untrackedCommit, err := repo.WriteCommit(untrackedTree, nil, "untracked files on "+description)
+		if err != nil {
+			return err
+		}
+		parents = append(parents, untrackedCommit)
+	}
+
+	stashCommit, err := repo.WriteCommit(worktreeTree, parents, message)
+	if err != nil {
+		return err
+	}
+	previous, err := repo.ReadRef(stashRef)
+	if err != nil {
+		return err
+	}
+	if err := repo.UpdateRef(stashRef, stashCommit); err != nil {
+		return fmt.Errorf("failed to update stash ref: %w", err)
+	}
+	if err := repo.AppendReflog(stashRef, previous, stashCommit, message); err != nil {
+		return err
+	}
+
+	headEntries, err := repo.ReadTree(head.Tree)
+	if err != nil {
+		return err
+	}
+	if err := resetHard(headHash, headEntries); err != nil {
+		return err
+	}
+	if untracked {
+		for _, path := range untrackedFiles {
+			os.Remove(path)
+			repo.RemoveEmptyDirs(filepath.Dir(path))
+		}
+	}
+
+	fmt.Printf("Saved working directory and index state %s\n", message)
+	return nil
+}
+
+// stores the current content of a working tree file as a blob
+func storeWorktreeFile(path string) (gitre.IndexEntry, error) {
+	data, info, err := repo.ReadWorktreeFile(path)
+	if err != nil {
+		return gitre.IndexEntry{}, err
+	}
+	hash, err := repo.HashStore(data, "blob")
+	if err != nil {
+		return gitre.IndexEntry{}, fmt.Errorf("failed to store %s: %w", path, err)
+	}
+	return gitre.EntryFromInfo(path, hash, info), nil
+}
+
+func stashList() error {
+	entries, err := repo.ReadReflog(stashRef)
+	if err != nil {
+		return err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		fmt.Printf("stash@{%d}: %s\n", len(entries)-1-i, entries[i].Message)
+	}
+	return nil
+}
+
+// parses an optional stash@{n} argument, returning n and the remaining args
+func stashSelector(args []string) (int, []string, error) {
+	var rest []string
+	n := 0
+	found := false
+	var err error
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			rest = append(rest, arg)
+			continue
+		}
+		if found {
+			return 0, nil, fmt.Errorf("too many stash references given")
+		}
+		found = true
+		// either stash@{n} or plain n
+		number := arg
+		if sel, ok := strings.CutPrefix(arg, "stash@{"); ok && strings.HasSuffix(sel, "}") {
+			number = strings.TrimSuffix(sel, "}")
+		}
+		if n, err = strconv.Atoi(number); err != nil || n < 0 {
+			return 0, nil, fmt.Errorf("%s is not a valid stash reference", arg)
+		}
+	}
+	entries, err := repo.ReadReflog(stashRef)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(entries) == 0 {
+		return 0, nil, fmt.Errorf("no stash entries found")
+	}
+	if n >= len(entries) {
+		return 0, nil, fmt.Errorf("stash@{%d} does not exist", n)
+	}
+	return n, rest, nil
+}
+
+type stashState struct {
+	base, index, worktree, untracked map[string]gitre.IndexEntry
+}
+
+func readStash(n int) (*stashState, error) {
+	hash, err := repo.ResolveRev(fmt.Sprintf("stash@{%d}", n))
+	if err != nil {
+		return nil, err
+	}
+	c, err := repo.ReadCommit(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Parents) < 2 {
+		return nil, fmt.Errorf("%s is not a stash commit", hash)
+	}
+	state := &stashState{untracked: map[string]gitre.IndexEntry{}}
+	if state.worktree, err = repo.ReadTree(c.Tree); err != nil {
+		return nil, err
+	}
+	if state.base, err = repo.ReadCommitTree(c.Parents[0]); err != nil {
+		return nil, err
+	}
+	if state.index, err = repo.ReadCommitTree(c.Parents[1]); err != nil {
+		return nil, err
+	}
+	if len(c.Parents) > 2 {
+		if state.untracked, err = repo.ReadCommitTree(c.Parents[2]); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+func stashShow(args []string) error {
+	n, rest, err := stashSelector(args)
+	if err != nil {
+		return err
+	}
+	patch := len(rest) > 0 && (rest[0] == "-p" || rest[0] == "--patch")
+	state, err := readStash(n)
+	if err != nil {
+		return err
+	}
+	for _, c := range repo.DiffTrees(state.base, state.worktree, repo.DefaultRenameOptions()) {
+		if !patch {
+			printNameStatus(c)
+			continue
+		}
+		if err := printPatch(c, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merges the change from the stash base to the stashed working tree into the index and working tree; conflicts are
+// left with markers like a merge's and keep the stash, files the merge would touch must not have unstaged changes
+func stashApply(args []string, pop bool) error {
+	n, rest, err := stashSelector(args)
+	if err != nil {
+		return err
+	}
+	restoreIndex := false
+	for _, arg := range rest {
+		if arg != "--index" {
+			return fmt.Errorf("unknown option for stash: %s", arg)
+		}
+		restoreIndex = true
+	}
+	if unmerged, err := repo.ReadConflicts(); err != nil {
+		return err
+	} else if len(unmerged) > 0 {
+		return fmt.Errorf("you have unmerged paths, resolve them before applying a stash")
+	}
+	state, err := readStash(n)
+	if err != nil {
+		return err
+	}
+	idx, err := repo.ReadIndex()
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+	ours := map[string]gitre.IndexEntry{}
+	for _, e := range idx.Entries {
+		ours[e.Path] = e
+	}
+	merged, err := repo.MergeTrees(state.base, ours, state.worktree, "Updated upstream", "Stashed changes")
+	if err != nil {
+		return err
+	}
+	if restoreIndex && len(merged.Conflicts) > 0 {
+		return fmt.Errorf("conflicts in the stashed changes, try without --index")
+	}
+
+	// only the paths the merge changes are written, each must hold its staged content or not exist
+	before, after := map[string]gitre.IndexEntry{}, map[string]gitre.IndexEntry{}
+	for path, e := range ours {
+		if m, ok := merged.Entries[path]; !ok || !gitre.SameEntry(&e, &m) {
+			before[path] = e
+		}
+	}
+	for path, e := range merged.Entries {
+		if o, ok := ours[path]; !ok || !gitre.SameEntry(&o, &e) {
+			after[path] = e
+		}
+	}
+	var conflicts []string
+	for path, e := range before {
+		if _, err := os.Lstat(path); err == nil && !worktreeMatches(path, e, true) {
+			conflicts = append(conflicts, path)
+		}
+	}
+	for path, e := range after {
+		if _, tracked := ours[path]; !tracked {
+			if _, err := os.Lstat(path); err == nil && !worktreeMatches(path, e, true) {
+				conflicts = append(conflicts, path)
+			}
+		}
+	}
+	for path, e := range state.untracked {
+		if _, err := os.Lstat(path); err == nil && !worktreeMatches(path, e, true) {
+			conflicts = append(conflicts, path)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("your local changes to the following files would be overwritten:\n\t%s\ncommit or stash them first", strings.Join(conflicts, "\n\t"))
+	}
+
+	if err := repo.UpdateWorkTree(before, after); err != nil {
+		return err
+	}
+	// the applied changes stay unstaged, except that new files are added and deleted ones removed
+	conflicted := map[string]bool{}
+	for _, path := range merged.Conflicts {
+		conflicted[path] = true
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			idx.Remove(path)
+		}
+	}
+	for path, e := range after {
+		if _, tracked := ours[path]; tracked || conflicted[path] || restoreIndex {
+			continue
+		}
+		if staged, err := repo.EntryFromDisk(path, e.Hash); err == nil {
+			idx.Set(staged)
+		}
+	}
+	if restoreIndex {
+		for _, c := range repo.DiffTrees(state.base, state.index, gitre.RenameOptions{}) {
+			if c.Status == "D" {
+				idx.Remove(c.OldPath)
+			} else {
+				idx.Set(c.New)
+			}
+		}
+	}
+	for _, e := range gitre.SortedEntries(state.untracked) {
+		if err := repo.CheckoutEntry(e); err != nil {
+			return err
+		}
+	}
+	if err := idx.Write(); err != nil {
+		return err
+	}
+
+	if len(merged.Conflicts) > 0 {
+		if err := repo.WriteConflicts(merged.Conflicts); err != nil {
+			return err
+		}
+		for _, path := range merged.Conflicts {
+			fmt.Printf("CONFLICT: merge conflict in %s\n", path)
+		}
+		return fmt.Errorf("the stash is kept, fix the conflicts and mark them with 'gitre add <path>'")
+	}
+	if pop {
+		return dropStash(n)
+	}
+	return nil
+}
+
+// reports whether the file at path holds the content of e, or is absent when exists is false
+func worktreeMatches(path string, e gitre.IndexEntry, exists bool) bool {
+	data, _, err := repo.ReadWorktreeFile(path)
+	if !exists {
+		return os.IsNotExist(err)
+	}
+	if err != nil {
+		return false
+	}
+	hash, _ := gitre.HashObject(data, "blob")
+	return hash == e.Hash
+}
+
+func stashDrop(args []string) error {
+	n, rest, err := stashSelector(args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("unknown option for stash drop: %s", rest[0])
+	}
+	return dropStash(n)
+}
+
+// removes stash@{n} from the reflog and points refs/stash at the newest remaining entry
+func dropStash(n int) error {
+	entries, err := repo.ReadReflog(stashRef)
+	if err != nil {
+		return err
+	}
+	i := len(entries) - 1 - n
+	dropped := entries[i]
+	entries = append(entries[:i], entries[i+1:]...)
+	if err := repo.WriteReflog(stashRef, entries); err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		if err := os.Remove(repo.Path(stashRef)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stash ref: %w", err)
+		}
+	} else if err := repo.UpdateRef(stashRef, entries[len(entries)-1].New); err != nil {
+		return fmt.Errorf("failed to update stash ref: %w", err)
+	}
+	fmt.Printf("Dropped stash@{%d} (%s)\n", n, dropped.New[:7])
+	return nil
+}
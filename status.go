@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gitre/gitre"
+)
+
+// status [--short|--porcelain[=v1|v2]|--json] [-b] [-z] [--ignored]
+func status(args []string) error {
+	format, showBranch, nulTerminated, showIgnored := "long", false, false, false
+	for _, arg := range args {
+		switch arg {
+		case "--ignored":
+			showIgnored = true
+		case "-s", "--short":
+			format = "short"
+		case "--porcelain", "--porcelain=v1", "--porcelain=1":
+			format = "v1"
+		case "--porcelain=v2", "--porcelain=2":
+			format = "v2"
+		case "--json":
+			format = "json"
+		case "-b", "--branch":
+			showBranch = true
+		case "-z":
+			nulTerminated = true
+		default:
+			return fmt.Errorf("unknown option for status: %s", arg)
+		}
+	}
+	if nulTerminated && format == "long" {
+		format = "v1"
+	}
+
+	result, err := repo.Status(showIgnored)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "json":
+		out, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal status: %w", err)
+		}
+		fmt.Println(string(out))
+	case "short", "v1":
+		printShortStatus(result, showBranch, nulTerminated)
+	case "v2":
+		printStatusV2(result, showBranch, nulTerminated)
+	default:
+		printLongStatus(result)
+	}
+	return nil
+}
+
+func printLongStatus(result *gitre.StatusResult) {
+	switch {
+	case result.Branch == "":
+		fmt.Printf("HEAD detached at %s\n", shortHash(result.Head))
+	default:
+		fmt.Printf("On branch: %s\n", result.Branch)
+	}
+	if result.Head == "" {
+		fmt.Println("No commits yet")
+	}
+	if result.Upstream != "" {
+		switch {
+		case result.Ahead > 0 && result.Behind > 0:
+			fmt.Printf("Your branch and '%s' have diverged: ahead %d, behind %d\n", result.Upstream, result.Ahead, result.Behind)
+		case result.Ahead > 0:
+			fmt.Printf("Your branch is ahead of '%s' by %d commit(s)\n", result.Upstream, result.Ahead)
+		case result.Behind > 0:
+			fmt.Printf("Your branch is behind '%s' by %d commit(s)\n", result.Upstream, result.Behind)
+		default:
+			fmt.Printf("Your branch is up to date with '%s'\n", result.Upstream)
+		}
+	}
+
+	section := func(title string, filter func(gitre.StatusEntry) bool) {
+		var paths []string
+		for _, e := range result.Entries {
+			if filter(e) {
+				paths = append(paths, e.Path)
+			}
+		}
+		if len(paths) == 0 {
+			return
+		}
+		fmt.Printf("\n%s\n", title)
+		for _, p := range paths {
+			fmt.Printf("  %s\n", p)
+		}
+	}
+
+	section("Unmerged paths:", func(e gitre.StatusEntry) bool { return e.Index == "U" })
+
+	fmt.Println("\nSTAGING: (index <-> commit)")
+	section("Modified files:", func(e gitre.StatusEntry) bool { return e.Index == "M" })
+	section("New files:", func(e gitre.StatusEntry) bool { return e.Index == "A" })
+	section("Deleted files:", func(e gitre.StatusEntry) bool { return e.Index == "D" })
+	var renamed []string
+	for _, e := range result.Entries {
+		if e.Index == "R" {
+			renamed = append(renamed, e.OrigPath+" -> "+e.Path)
+		}
+	}
+	if len(renamed) > 0 {
+		fmt.Printf("\nRenamed files:\n")
+		for _, r := range renamed {
+			fmt.Printf("  %s\n", r)
+		}
+	}
+
+	fmt.Println("\nUNSTAGED (disk <-> index):")
+	section("Modified files:", func(e gitre.StatusEntry) bool { return e.Worktree == "M" })
+	section("Deleted files:", func(e gitre.StatusEntry) bool { return e.Worktree == "D" && e.Index != "D" })
+	section("Untracked:", func(e gitre.StatusEntry) bool { return e.Index == "?" })
+	section("Ignored files:", func(e gitre.StatusEntry) bool { return e.Index == "!" })
+}
+
+func printShortStatus(result *gitre.StatusResult, showBranch bool, nulTerminated bool) {
+	end := "\n"
+	if nulTerminated {
+		end = "\x00"
+	}
+	if showBranch {
+		var header string
+		switch {
+		case result.Branch == "":
+			header = "## HEAD (no branch)"
+		case result.Head == "":
+			header = "## No commits yet on " + result.Branch
+		default:
+			header = "## " + result.Branch
+		}
+		if result.Upstream != "" {
+			header += "..." + result.Upstream
+			var counts []string
+			if result.Ahead > 0 {
+				counts = append(counts, fmt.Sprintf("ahead %d", result.Ahead))
+			}
+			if result.Behind > 0 {
+				counts = append(counts, fmt.Sprintf("behind %d", result.Behind))
+			}
+			if len(counts) > 0 {
+				header += " [" + strings.Join(counts, ", ") + "]"
+			}
+		}
+		fmt.Print(header + end)
+	}
+	for _, e := range result.Entries {
+		switch {
+		case e.OrigPath != "" && nulTerminated:
+			fmt.Print(e.Index + e.Worktree + " " + e.Path + end + e.OrigPath + end)
+		case e.OrigPath != "":
+			fmt.Print(e.Index + e.Worktree + " " + e.OrigPath + " -> " + e.Path + end)
+		default:
+			fmt.Print(e.Index + e.Worktree + " " + e.Path + end)
+		}
+	}
+}
+
+// git's porcelain v2: "1" lines for changes, "2" for renames and "u" for conflicts carry the modes and hashes
+// on every side of the change, "?" and "!" lines list untracked and ignored files
+func printStatusV2(result *gitre.StatusResult, showBranch bool, nulTerminated bool) {
+	end := "\n"
+	if nulTerminated {
+		end = "\x00"
+	}
+	if showBranch {
+		oid, head := result.Head, result.Branch
+		if oid == "" {
+			oid = "(initial)"
+		}
+		if head == "" {
+			head = "(detached)"
+		}
+		fmt.Print("# branch.oid " + oid + end)
+		fmt.Print("# branch.head " + head + end)
+		if result.Upstream != "" {
+			fmt.Print("# branch.upstream " + result.Upstream + end)
+			fmt.Printf("# branch.ab +%d -%d%s", result.Ahead, result.Behind, end)
+		}
+	}
+	for _, e := range result.Entries {
+		xy := strings.ReplaceAll(e.Index+e.Worktree, " ", ".")
+		switch e.Index {
+		case "?", "!":
+			continue
+		case "U":
+			s := e.Stages
+			fmt.Printf("u %s N... %s %s %s %s %s %s %s %s%s", xy, s[0].Mode, s[1].Mode, s[2].Mode, e.WorktreeMode, s[0].Hash, s[1].Hash, s[2].Hash, e.Path, end)
+		case "R":
+			sep := "\t"
+			if nulTerminated {
+				sep = "\x00"
+			}
+			fmt.Printf("2 %s N... %s %s %s %s %s R%d %s%s%s%s", xy, e.HeadMode, e.IndexMode, e.WorktreeMode, e.HeadHash, e.IndexHash, e.Score, e.Path, sep, e.OrigPath, end)
+		default:
+			fmt.Printf("1 %s N... %s %s %s %s %s %s%s", xy, e.HeadMode, e.IndexMode, e.WorktreeMode, e.HeadHash, e.IndexHash, e.Path, end)
+		}
+	}
+	// untracked then ignored files follow the tracked ones
+	for _, kind := range []string{"?", "!"} {
+		for _, e := range result.Entries {
+			if e.Index == kind {
+				fmt.Print(kind + " " + e.Path + end)
+			}
+		}
+	}
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
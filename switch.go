@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gitre/gitre"
+)
+
+// switch [-c] [-f] <branch>
+func switchBranch(args []string) error {
+	create, force := false, false
+	var name string
+	for _, arg := range args {
+		switch arg {
+		case "-c", "--create":
+			create = true
+		case "-f", "--force":
+			force = true
+		default:
+			if name != "" {
+				return fmt.Errorf("usage: gitre switch [-c] [-f] <branch>")
+			}
+			name = arg
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("usage: gitre switch [-c] [-f] <branch>")
+	}
+	if err := checkBranchName(name); err != nil {
+		return err
+	}
+
+	_, currentHash, err := repo.ReadHead()
+	if err != nil {
+		return err
+	}
+	refPath := "refs/heads/" + name
+	targetHash, err := repo.ReadRef(refPath)
+	if err != nil {
+		return err
+	}
+	if create {
+		if targetHash != "" {
+			return fmt.Errorf("branch '%s' already exists", name)
+		}
+		targetHash = currentHash
+	} else if targetHash == "" {
+		return fmt.Errorf("branch '%s' does not exist (use -c to create it)", name)
+	}
+
+	if err := moveWorkTree(currentHash, targetHash, force); err != nil {
+		return err
+	}
+
+	if create && targetHash != "" {
+		if err := repo.UpdateRef(refPath, targetHash); err != nil {
+			return fmt.Errorf("failed to create branch: %w", err)
+		}
+	}
+	if err := os.WriteFile(repo.Path("HEAD"), []byte("ref: "+refPath+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to update HEAD: %w", err)
+	}
+	fmt.Printf("Switched to branch '%s'\n", name)
+	hookArgs := []string{currentHash, targetHash, "1"}
+	for i, h := range hookArgs[:2] {
+		if h == "" {
+			hookArgs[i] = gitre.ZeroHash
+		}
+	}
+	return runHook("post-checkout", hookArgs, "")
+}
+
+// updates index and working tree for the files that differ between two commits, keeping unrelated local changes
+func moveWorkTree(fromHash string, toHash string, force bool) error {
+	from, err := repo.ReadCommitTree(fromHash)
+	if err != nil {
+		return err
+	}
+	to, err := repo.ReadCommitTree(toHash)
+	if err != nil {
+		return err
+	}
+	idx, err := repo.ReadIndex()
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+
+	oldChanged, newChanged := changedEntries(from, to)
+
+	if !force {
+		if conflicts := localChanges(idx, from, oldChanged, newChanged); len(conflicts) > 0 {
+			return fmt.Errorf("your local changes to the following files would be overwritten:\n\t%s\ncommit them or use -f to discard them", strings.Join(conflicts, "\n\t"))
+		}
+	}
+
+	if err := repo.UpdateWorkTree(oldChanged, newChanged); err != nil {
+		return err
+	}
+	for path := range oldChanged {
+		if _, ok := newChanged[path]; !ok {
+			idx.Remove(path)
+		}
+	}
+	for path, e := range newChanged {
+		if diskEntry, err := repo.EntryFromDisk(path, e.Hash); err == nil {
+			e = diskEntry
+		}
+		idx.Set(e)
+	}
+	return idx.Write()
+}
+
+// entries of two trees that differ, as they are on each side
+func changedEntries(from map[string]gitre.IndexEntry, to map[string]gitre.IndexEntry) (map[string]gitre.IndexEntry, map[string]gitre.IndexEntry) {
+	oldChanged := map[string]gitre.IndexEntry{}
+	newChanged := map[string]gitre.IndexEntry{}
+	for path, e := range from {
+		if t, ok := to[path]; !ok || t.Hash != e.Hash || t.Mode != e.Mode {
+			oldChanged[path] = e
+		}
+	}
+	for path, e := range to {
+		if f, ok := from[path]; !ok || f.Hash != e.Hash || f.Mode != e.Mode {
+			newChanged[path] = e
+		}
+	}
+	return oldChanged, newChanged
+}
+
+// paths that differ between the commits and carry staged, unstaged or untracked content that would be lost
+func localChanges(idx *gitre.Index, from map[string]gitre.IndexEntry, oldChanged map[string]gitre.IndexEntry, newChanged map[string]gitre.IndexEntry) []string {
+	paths := map[string]bool{}
+	for path := range oldChanged {
+		paths[path] = true
+	}
+	for path := range newChanged {
+		paths[path] = true
+	}
+
+	racyTime := repo.IndexMtime()
+	var conflicts []string
+	for path := range paths {
+		committed, inCommit := from[path]
+		staged, inIndex := idx.Get(path)
+		switch {
+		case inCommit != inIndex || inIndex && (staged.Hash != committed.Hash || staged.Mode != committed.Mode):
+			conflicts = append(conflicts, path)
+		case inIndex:
+			if changed, _, err := repo.CompareWorktree(staged, racyTime); changed || err != nil && !os.IsNotExist(err) {
+				conflicts = append(conflicts, path)
+			}
+		default:
+			if _, err := os.Lstat(path); err == nil {
+				conflicts = append(conflicts, path)
+			}
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// restore [--staged] [--worktree] [--source <rev>] <paths>
+func restore(args []string) error {
+	staged, worktree := false, false
+	source := ""
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--staged" || arg == "-S":
+			staged = true
+		case arg == "--worktree" || arg == "-W":
+			worktree = true
+		case arg == "--source" || arg == "-s":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--source requires a revision")
+			}
+			i++
+			source = args[i]
+		case strings.HasPrefix(arg, "--source="):
+			source = strings.TrimPrefix(arg, "--source=")
+		case arg == "--":
+			paths = append(paths, userPaths(args[i+1:])...)
+			i = len(args)
+		default:
+			paths = append(paths, userPath(arg))
+		}
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("usage: gitre restore [--staged] [--worktree] [--source <rev>] <paths>")
+	}
+	if !staged {
+		worktree = true
+	}
+	if staged && source == "" {
+		source = "HEAD"
+	}
+
+	idx, err := repo.ReadIndex()
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+
+	// entries to restore from, either a commit or the index itself
+	sourceEntries := map[string]gitre.IndexEntry{}
+	if source != "" {
+		hash, err := repo.ResolveRev(source)
+		if err != nil && !(source == "HEAD" && staged) {
+			return err
+		}
+		if sourceEntries, err = repo.ReadCommitTree(hash); err != nil {
+			return err
+		}
+	} else {
+		for _, e := range idx.Entries {
+			sourceEntries[e.Path] = e
+		}
+	}
+
+	for _, p := range paths {
+		if !matchesTracked(idx.Entries, p) && !matchesSource(sourceEntries, p) {
+			return fmt.Errorf("pathspec '%s' did not match any file(s) known to gitre", p)
+		}
+	}
+
+	matchedSource := map[string]gitre.IndexEntry{}
+	for path, e := range sourceEntries {
+		if gitre.MatchesAnyPath(path, paths) {
+			matchedSource[path] = e
+		}
+	}
+	matchedIndex := map[string]gitre.IndexEntry{}
+	for _, e := range idx.Entries {
+		if gitre.MatchesAnyPath(e.Path, paths) {
+			matchedIndex[e.Path] = e
+		}
+	}
+
+	if worktree {
+		if err := repo.UpdateWorkTree(matchedIndex, matchedSource); err != nil {
+			return err
+		}
+	}
+	if staged {
+		for path := range matchedIndex {
+			if _, ok := matchedSource[path]; !ok {
+				idx.Remove(path)
+			}
+		}
+		for path, e := range matchedSource {
+			if prev, ok := matchedIndex[path]; ok && prev.Hash == e.Hash && prev.Mode == e.Mode {
+				continue
+			}
+			if worktree {
+				if diskEntry, err := repo.EntryFromDisk(path, e.Hash); err == nil {
+					e = diskEntry
+				}
+			}
+			idx.Set(e)
+		}
+		if err := idx.Write(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchesSource(entries map[string]gitre.IndexEntry, path string) bool {
+	for p := range entries {
+		if gitre.MatchesAnyPath(p, []string{path}) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,12 +1,21 @@
 package test
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"strings"
 	"testing"
+
+	"gitre/gitre"
 )
 
 var binPath string
@@ -223,6 +232,1821 @@ func Test_Status(t *testing.T) {
 	}
 }
 
+func Test_Reset(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-reset-*")
+	defer os.RemoveAll(tempDir)
+
+	setupInit(t, tempDir)
+	setupAdd(t, tempDir, "file1.txt")
+	runCommand(t, tempDir, "commit", "First commit")
+
+	refPath := filepath.Join(tempDir, ".gitre", "refs", "heads", "main")
+	hash1, _ := os.ReadFile(refPath)
+
+	os.WriteFile(filepath.Join(tempDir, "file1.txt"), []byte("changed"), 0644)
+	os.WriteFile(filepath.Join(tempDir, "file2.txt"), []byte("second"), 0644)
+	runCommand(t, tempDir, "add", "file1.txt", "file2.txt")
+	runCommand(t, tempDir, "commit", "Second commit")
+
+	runCommand(t, tempDir, "reset", "--soft", "HEAD~1")
+	if hash, _ := os.ReadFile(refPath); string(hash) != string(hash1) {
+		t.Errorf("Soft reset did not move branch. Got: %s, Want: %s", hash, hash1)
+	}
+	if !strings.Contains(readIndex(t, tempDir), "file2.txt") {
+		t.Error("Soft reset should keep the index")
+	}
+
+	runCommand(t, tempDir, "reset", "file2.txt")
+	if strings.Contains(readIndex(t, tempDir), "file2.txt") {
+		t.Error("Path reset should unstage file2.txt")
+	}
+
+	runCommand(t, tempDir, "add", "file2.txt")
+	runCommand(t, tempDir, "reset", "--hard", string(hash1))
+	if _, err := os.Stat(filepath.Join(tempDir, "file2.txt")); !os.IsNotExist(err) {
+		t.Error("Hard reset should remove file2.txt from disk")
+	}
+	if data, _ := os.ReadFile(filepath.Join(tempDir, "file1.txt")); string(data) != "content" {
+		t.Errorf("Hard reset should restore file1.txt. Got: %s", data)
+	}
+
+	// a mixed reset keeps the full stat data of entries it leaves unchanged
+	loadIndex := func() []gitre.IndexEntry {
+		r, err := gitre.Open(tempDir)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		entries, err := r.LoadIndex()
+		if err != nil {
+			t.Fatalf("LoadIndex failed: %v", err)
+		}
+		return entries
+	}
+	before := loadIndex()
+	runCommand(t, tempDir, "reset")
+	if after := loadIndex(); !reflect.DeepEqual(before, after) || before[0].Inode == 0 && runtime.GOOS != "windows" {
+		t.Errorf("reset should keep the stat data. Before: %+v, after: %+v", before, after)
+	}
+
+	// with a mode the argument is a revision, so a typo is reported as one
+	cmd := exec.Command(binPath, "reset", "--hard", "no-such-rev")
+	cmd.Dir = tempDir
+	if out, err := cmd.CombinedOutput(); err == nil || strings.Contains(string(out), "with paths") {
+		t.Errorf("reset --hard should report the unknown revision. Got: %s", out)
+	}
+}
+
+func Test_RmMv(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-rm-*")
+	defer os.RemoveAll(tempDir)
+
+	setupInit(t, tempDir)
+	setupAdd(t, tempDir, "keep.txt", "gone.txt", "cached.txt", "old.txt")
+
+	runCommand(t, tempDir, "rm", "gone.txt")
+	if _, err := os.Stat(filepath.Join(tempDir, "gone.txt")); !os.IsNotExist(err) {
+		t.Error("rm should delete gone.txt from disk")
+	}
+	runCommand(t, tempDir, "rm", "--cached", "cached.txt")
+	if _, err := os.Stat(filepath.Join(tempDir, "cached.txt")); err != nil {
+		t.Error("rm --cached should keep cached.txt on disk")
+	}
+
+	runCommand(t, tempDir, "mv", "old.txt", "new.txt")
+	if _, err := os.Stat(filepath.Join(tempDir, "new.txt")); err != nil {
+		t.Error("mv should rename old.txt to new.txt on disk")
+	}
+
+	indexContent := readIndex(t, tempDir)
+	for _, name := range []string{"gone.txt", "cached.txt", "old.txt"} {
+		if strings.Contains(indexContent, name) {
+			t.Errorf("Index should not contain %s", name)
+		}
+	}
+	if !strings.Contains(indexContent, "new.txt") {
+		t.Error("Index missing new.txt after mv")
+	}
+
+	// a tracked destination missing from disk is replaced
+	setupAdd(t, tempDir, "target.txt")
+	os.Remove(filepath.Join(tempDir, "target.txt"))
+	runCommand(t, tempDir, "mv", "new.txt", "target.txt")
+	if indexContent := readIndex(t, tempDir); strings.Count(indexContent, "target.txt") != 1 || strings.Contains(indexContent, "new.txt") {
+		t.Errorf("mv should leave a single index entry for target.txt. Got: %q", indexContent)
+	}
+
+	os.Remove(filepath.Join(tempDir, "keep.txt"))
+	runCommand(t, tempDir, "add", "-u")
+	if strings.Contains(readIndex(t, tempDir), "keep.txt") {
+		t.Error("add -u should stage deletion of keep.txt")
+	}
+	if strings.Contains(readIndex(t, tempDir), "cached.txt") {
+		t.Error("add -u should not stage untracked cached.txt")
+	}
+	runCommand(t, tempDir, "add", "-A")
+	if !strings.Contains(readIndex(t, tempDir), "cached.txt") {
+		t.Error("add -A should stage untracked cached.txt")
+	}
+}
+
+func Test_Ignore(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-ignore-*")
+	defer os.RemoveAll(tempDir)
+
+	setupInit(t, tempDir)
+	os.WriteFile(filepath.Join(tempDir, ".gitreignore"), []byte("build/*.o\n/root-only\n**/tmp\nlogs/\n*.log\n!keep.log\n"), 0644)
+	os.WriteFile(filepath.Join(tempDir, ".gitre", "info", "exclude"), []byte("secret.txt\n"), 0644)
+
+	files := []string{
+		"build/a.o", "build/a.c", "root-only", "sub/root-only", "x/y/tmp",
+		"logs/today.txt", "debug.log", "keep.log", "secret.txt",
+		"nested/local.txt", "nested/other.txt",
+	}
+	for _, f := range files {
+		os.MkdirAll(filepath.Join(tempDir, filepath.Dir(f)), 0755)
+		os.WriteFile(filepath.Join(tempDir, f), []byte("content"), 0644)
+	}
+	os.WriteFile(filepath.Join(tempDir, "nested", ".gitreignore"), []byte("local.txt\n"), 0644)
+
+	runCommand(t, tempDir, "add", ".")
+	indexContent := readIndex(t, tempDir)
+
+	ignored := []string{"build/a.o", "x/y/tmp", "logs/today.txt", "debug.log", "secret.txt", "nested/local.txt"}
+	for _, f := range ignored {
+		if strings.Contains(indexContent, f) {
+			t.Errorf("Index should not contain ignored %s", f)
+		}
+	}
+	kept := []string{"build/a.c", "sub/root-only", "keep.log", "nested/other.txt"}
+	for _, f := range kept {
+		if !strings.Contains(indexContent, f) {
+			t.Errorf("Index missing %s", f)
+		}
+	}
+
+	output := runCommand(t, tempDir, "check-ignore", "root-only")
+	if !strings.Contains(output, "root-only") {
+		t.Error("check-ignore should report root-only as ignored")
+	}
+	cmd := exec.Command(binPath, "check-ignore", "sub/root-only")
+	cmd.Dir = tempDir
+	if out, err := cmd.CombinedOutput(); err == nil {
+		t.Errorf("Anchored /root-only should not ignore sub/root-only. Output: %s", out)
+	}
+
+	output = runCommand(t, tempDir, "check-ignore", "-v", "debug.log")
+	if !strings.Contains(output, ".gitreignore:5:*.log") {
+		t.Errorf("check-ignore -v should report the matching rule. Got: %s", output)
+	}
+}
+
+func Test_IgnoredTracked(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-ignored-*")
+	defer os.RemoveAll(tempDir)
+
+	setupInit(t, tempDir)
+	setupAdd(t, tempDir, "tracked.log")
+	runCommand(t, tempDir, "commit", "Initial commit")
+	os.WriteFile(filepath.Join(tempDir, ".gitreignore"), []byte("*.log\n"), 0644)
+	os.WriteFile(filepath.Join(tempDir, "tracked.log"), []byte("changed"), 0644)
+	os.WriteFile(filepath.Join(tempDir, "other.log"), []byte("ignored"), 0644)
+
+	output := runCommand(t, tempDir, "status")
+	if !strings.Contains(output, "tracked.log") {
+		t.Error("Status should show tracked.log even though it is ignored")
+	}
+	if strings.Contains(output, "other.log") {
+		t.Error("Status should hide untracked ignored other.log")
+	}
+	output = runCommand(t, tempDir, "status", "--ignored")
+	if !strings.Contains(output, "other.log") {
+		t.Error("Status --ignored should list other.log")
+	}
+
+	cmd := exec.Command(binPath, "add", "other.log")
+	cmd.Dir = tempDir
+	if out, err := cmd.CombinedOutput(); err == nil {
+		t.Errorf("add of an ignored path should fail without --force. Output: %s", out)
+	}
+	runCommand(t, tempDir, "add", "tracked.log")
+	runCommand(t, tempDir, "add", "--force", "other.log")
+	if !strings.Contains(readIndex(t, tempDir), "other.log") {
+		t.Error("add --force should stage ignored other.log")
+	}
+}
+
+func Test_StatusRacyEdit(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-racy-*")
+	defer os.RemoveAll(tempDir)
+
+	setupInit(t, tempDir)
+	setupAdd(t, tempDir, "file1.txt")
+	runCommand(t, tempDir, "commit", "Initial commit")
+
+	// same size and same second as the index write, only the content tells the change apart
+	filePath := filepath.Join(tempDir, "file1.txt")
+	info, _ := os.Stat(filePath)
+	os.WriteFile(filePath, []byte("CONTENT"), 0644)
+	os.Chtimes(filePath, info.ModTime(), info.ModTime())
+
+	output := runCommand(t, tempDir, "status")
+	unstaged := output[strings.Index(output, "UNSTAGED"):]
+	if !strings.Contains(unstaged, "file1.txt") {
+		t.Errorf("Status should detect a same-size edit within the index timestamp. Got: %s", output)
+	}
+
+	runCommand(t, tempDir, "add", "file1.txt")
+	output = runCommand(t, tempDir, "status")
+	unstaged = output[strings.Index(output, "UNSTAGED"):]
+	if strings.Contains(unstaged, "file1.txt") {
+		t.Errorf("Status should report file1.txt clean after add. Got: %s", output)
+	}
+}
+
+func Test_IndexUpgrade(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-index-*")
+	defer os.RemoveAll(tempDir)
+
+	setupInit(t, tempDir)
+	os.WriteFile(filepath.Join(tempDir, "file1.txt"), []byte("content"), 0644)
+	legacy := `[{"path": "file1.txt", "hash": "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73", "mode": 420, "size": 7, "mtime": 1}]`
+	os.WriteFile(filepath.Join(tempDir, ".gitre", "index"), []byte(legacy), 0644)
+
+	setupAdd(t, tempDir, "file2.txt")
+	indexContent := readIndex(t, tempDir)
+	if !strings.HasPrefix(indexContent, "GIDX") {
+		t.Error("JSON index should be upgraded to the binary format")
+	}
+	for _, name := range []string{"file1.txt", "file2.txt"} {
+		if !strings.Contains(indexContent, name) {
+			t.Errorf("Upgraded index missing %s", name)
+		}
+	}
+
+	runCommand(t, tempDir, "commit", "After upgrade")
+
+	data, _ := os.ReadFile(filepath.Join(tempDir, ".gitre", "index"))
+	data[10] ^= 0xff
+	os.WriteFile(filepath.Join(tempDir, ".gitre", "index"), data, 0644)
+	cmd := exec.Command(binPath, "status")
+	cmd.Dir = tempDir
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "checksum") {
+		t.Errorf("Corrupted index should be rejected. Output: %s", out)
+	}
+
+	// a well-formed header announcing more entries than the file holds
+	header := []byte("GIDX\x00\x00\x00\x01\xff\xff\xff\xff")
+	sum := sha256.Sum256(header)
+	os.WriteFile(filepath.Join(tempDir, ".gitre", "index"), append(header, sum[:]...), 0644)
+	cmd = exec.Command(binPath, "status")
+	cmd.Dir = tempDir
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "do not fit") {
+		t.Errorf("An index with an impossible entry count should be rejected. Output: %s", out)
+	}
+}
+
+func Test_ParallelAdd(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-parallel-*")
+	defer os.RemoveAll(tempDir)
+
+	setupInit(t, tempDir)
+	runCommand(t, tempDir, "config", "core.workers", "4")
+
+	var names []string
+	for i := range 50 {
+		name := filepath.Join("tree", string(rune('a'+i%5)), "file"+strings.Repeat("x", i)+".txt")
+		names = append(names, filepath.ToSlash(name))
+		os.MkdirAll(filepath.Join(tempDir, filepath.Dir(name)), 0755)
+		os.WriteFile(filepath.Join(tempDir, name), []byte(name), 0644)
+	}
+
+	first := runCommand(t, tempDir, "add", "tree")
+	indexContent := readIndex(t, tempDir)
+	for _, name := range names {
+		if !strings.Contains(indexContent, name) {
+			t.Errorf("Index missing %s", name)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(first), "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i-1] > lines[i] {
+			t.Fatalf("add output should be sorted, got %q before %q", lines[i-1], lines[i])
+		}
+	}
+	if second := runCommand(t, tempDir, "add", "tree"); second != first {
+		t.Error("add output should be deterministic across runs")
+	}
+}
+
+func Test_ModesAndSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks and exec bits are not portable to windows")
+	}
+	tempDir, _ := os.MkdirTemp("", "gitre-modes-*")
+	defer os.RemoveAll(tempDir)
+
+	setupInit(t, tempDir)
+	os.WriteFile(filepath.Join(tempDir, "run.sh"), []byte("#!/bin/sh\n"), 0755)
+	os.WriteFile(filepath.Join(tempDir, "target.txt"), []byte("target"), 0644)
+	os.Symlink("target.txt", filepath.Join(tempDir, "link"))
+	runCommand(t, tempDir, "add", "run.sh", "target.txt", "link")
+	runCommand(t, tempDir, "commit", "Modes")
+
+	runCommand(t, tempDir, "switch", "-c", "empty")
+	runCommand(t, tempDir, "rm", "run.sh", "link")
+	runCommand(t, tempDir, "commit", "Remove")
+	if _, err := os.Lstat(filepath.Join(tempDir, "link")); !os.IsNotExist(err) {
+		t.Fatal("rm should remove the symlink")
+	}
+
+	runCommand(t, tempDir, "switch", "main")
+	info, err := os.Stat(filepath.Join(tempDir, "run.sh"))
+	if err != nil || info.Mode().Perm()&0100 == 0 {
+		t.Errorf("switch should restore run.sh as executable: %v", err)
+	}
+	target, err := os.Readlink(filepath.Join(tempDir, "link"))
+	if err != nil || target != "target.txt" {
+		t.Errorf("switch should recreate link -> target.txt. Got: %q, %v", target, err)
+	}
+
+	os.Chmod(filepath.Join(tempDir, "run.sh"), 0644)
+	output := runCommand(t, tempDir, "status")
+	if !strings.Contains(output[strings.Index(output, "UNSTAGED"):], "run.sh") {
+		t.Error("status should report the lost exec bit on run.sh")
+	}
+	runCommand(t, tempDir, "restore", "run.sh")
+	if info, _ := os.Stat(filepath.Join(tempDir, "run.sh")); info.Mode().Perm()&0100 == 0 {
+		t.Error("restore should bring back the exec bit on run.sh")
+	}
+}
+
+func Test_StatusFormats(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-porcelain-*")
+	defer os.RemoveAll(tempDir)
+
+	setupInit(t, tempDir)
+	setupAdd(t, tempDir, "modified.txt", "deleted.txt", ".gitreignore")
+	runCommand(t, tempDir, "commit", "Initial commit")
+
+	os.WriteFile(filepath.Join(tempDir, "modified.txt"), []byte("v2"), 0644)
+	os.Remove(filepath.Join(tempDir, "deleted.txt"))
+	setupAdd(t, tempDir, "staged.txt")
+	os.WriteFile(filepath.Join(tempDir, "untracked.txt"), []byte("?"), 0644)
+
+	output := runCommand(t, tempDir, "status", "--porcelain", "-b")
+	expected := "## main\n D deleted.txt\n M modified.txt\nA  staged.txt\n?? untracked.txt\n"
+	if output != expected {
+		t.Errorf("Unexpected porcelain output.\nGot:\n%s\nWant:\n%s", output, expected)
+	}
+
+	output = runCommand(t, tempDir, "status", "-z")
+	if output != strings.ReplaceAll(strings.TrimPrefix(expected, "## main\n"), "\n", "\x00") {
+		t.Errorf("Unexpected -z output: %q", output)
+	}
+
+	// v2 lines carry the modes in HEAD, index and working tree and the hashes in HEAD and index
+	output = runCommand(t, tempDir, "status", "--porcelain=v2")
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	wantPrefixes := []string{"1 .D N... 100644 100644 000000 ", "1 .M N... 100644 100644 100644 ", "1 A. N... 000000 100644 100644 " + strings.Repeat("0", 64), "? untracked.txt"}
+	if len(lines) != len(wantPrefixes) {
+		t.Fatalf("Unexpected v2 output:\n%s", output)
+	}
+	for i, prefix := range wantPrefixes {
+		if !strings.HasPrefix(lines[i], prefix) || prefix[0] == '1' && len(strings.Fields(lines[i])) != 9 {
+			t.Errorf("Unexpected v2 line %q, want it to start with %q", lines[i], prefix)
+		}
+	}
+	if fields := strings.Fields(lines[0]); fields[6] != fields[7] || fields[8] != "deleted.txt" {
+		t.Errorf("an unstaged deletion keeps the same hash in HEAD and index. Got: %s", lines[0])
+	}
+
+	output = runCommand(t, tempDir, "status", "--json")
+	var result struct {
+		Version int    `json:"version"`
+		Branch  string `json:"branch"`
+		Entries []struct {
+			Path         string `json:"path"`
+			Index        string `json:"index"`
+			Worktree     string `json:"worktree"`
+			HeadMode     string `json:"head_mode"`
+			WorktreeMode string `json:"worktree_mode"`
+			HeadHash     string `json:"head_hash"`
+			IndexHash    string `json:"index_hash"`
+		} `json:"entries"`
+	}
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		t.Fatalf("status --json is not valid JSON: %v\n%s", err, output)
+	}
+	if result.Version != 1 || result.Branch != "main" || len(result.Entries) != 4 {
+		t.Fatalf("Unexpected JSON status: %+v", result)
+	}
+	if deleted := result.Entries[0]; deleted.WorktreeMode != "000000" || deleted.HeadHash != deleted.IndexHash || deleted.HeadHash == strings.Repeat("0", 64) {
+		t.Errorf("the JSON entries should carry the same modes and hashes as v2. Got: %+v", deleted)
+	}
+	if staged := result.Entries[2]; staged.HeadMode != "000000" || staged.HeadHash != strings.Repeat("0", 64) {
+		t.Errorf("a path missing from HEAD should have an empty HEAD side. Got: %+v", staged)
+	}
+	if untracked := result.Entries[3]; untracked.HeadMode != "" || untracked.IndexHash != "" {
+		t.Errorf("untracked files should have no modes or hashes. Got: %+v", untracked)
+	}
+}
+
+func Test_Renames(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-renames-*")
+	defer os.RemoveAll(tempDir)
+
+	setupInit(t, tempDir)
+	content := "line 1\nline 2\nline 3\nline 4\nline 5\n"
+	os.WriteFile(filepath.Join(tempDir, "old.txt"), []byte(content), 0644)
+	runCommand(t, tempDir, "add", "old.txt")
+	runCommand(t, tempDir, "commit", "Create old.txt")
+	hash1, _ := os.ReadFile(filepath.Join(tempDir, ".gitre", "refs", "heads", "main"))
+
+	runCommand(t, tempDir, "mv", "old.txt", "new.txt")
+	os.WriteFile(filepath.Join(tempDir, "new.txt"), []byte(content+"line 6\n"), 0644)
+	runCommand(t, tempDir, "add", "new.txt")
+
+	output := runCommand(t, tempDir, "status", "--porcelain")
+	if !strings.Contains(output, "R  old.txt -> new.txt") {
+		t.Errorf("status should report the staged rename. Got:\n%s", output)
+	}
+	output = runCommand(t, tempDir, "diff", "--cached", "--name-status")
+	if !strings.HasPrefix(output, "R090\told.txt\tnew.txt") {
+		t.Errorf("diff --name-status should report a 90%% rename. Got:\n%s", output)
+	}
+	output = runCommand(t, tempDir, "diff", "--cached", "-M95", "--name-status")
+	if !strings.Contains(output, "A\tnew.txt") || !strings.Contains(output, "D\told.txt") {
+		t.Errorf("a 95%% threshold should not pair the files. Got:\n%s", output)
+	}
+	output = runCommand(t, tempDir, "diff", "--cached")
+	if !strings.Contains(output, "rename from old.txt") || !strings.Contains(output, "+line 6") {
+		t.Errorf("diff should show the rename and the added line. Got:\n%s", output)
+	}
+
+	runCommand(t, tempDir, "commit", "Rename to new.txt")
+	output = runCommand(t, tempDir, "log", "--follow", "new.txt")
+	if !strings.Contains(output, "commit "+string(hash1)) {
+		t.Errorf("log --follow should reach the commit that created old.txt. Got:\n%s", output)
+	}
+	output = runCommand(t, tempDir, "log", "new.txt")
+	if strings.Contains(output, "commit "+string(hash1)) {
+		t.Errorf("log without --follow should stop at the rename. Got:\n%s", output)
+	}
+}
+
+func Test_Stash(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-stash-*")
+	defer os.RemoveAll(tempDir)
+
+	setupInit(t, tempDir)
+	setupAdd(t, tempDir, "tracked.txt")
+	runCommand(t, tempDir, "commit", "Initial commit")
+
+	os.WriteFile(filepath.Join(tempDir, "tracked.txt"), []byte("work in progress"), 0644)
+	os.WriteFile(filepath.Join(tempDir, "scratch.txt"), []byte("notes"), 0644)
+	runCommand(t, tempDir, "stash", "push", "-m", "halfway", "--include-untracked")
+
+	if data, _ := os.ReadFile(filepath.Join(tempDir, "tracked.txt")); string(data) != "content" {
+		t.Errorf("stash push should reset tracked.txt. Got: %s", data)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "scratch.txt")); !os.IsNotExist(err) {
+		t.Error("stash push -u should remove untracked scratch.txt")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, ".gitre", "refs", "stash")); err != nil {
+		t.Error("stash push should create refs/stash")
+	}
+
+	output := runCommand(t, tempDir, "stash", "list")
+	if !strings.Contains(output, "stash@{0}: On main: halfway") {
+		t.Errorf("stash list missing entry. Got: %s", output)
+	}
+	output = runCommand(t, tempDir, "stash", "show")
+	if !strings.Contains(output, "M\ttracked.txt") {
+		t.Errorf("stash show should list tracked.txt. Got: %s", output)
+	}
+
+	runCommand(t, tempDir, "stash", "pop")
+	if data, _ := os.ReadFile(filepath.Join(tempDir, "tracked.txt")); string(data) != "work in progress" {
+		t.Errorf("stash pop should restore tracked.txt. Got: %s", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(tempDir, "scratch.txt")); string(data) != "notes" {
+		t.Errorf("stash pop should restore scratch.txt. Got: %s", data)
+	}
+	if output := runCommand(t, tempDir, "stash", "list"); output != "" {
+		t.Errorf("stash pop should drop the entry. Got: %s", output)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, ".gitre", "refs", "stash")); !os.IsNotExist(err) {
+		t.Error("dropping the last stash should remove refs/stash")
+	}
+
+	// the stash is merged into commits made since it was taken
+	file := filepath.Join(tempDir, "lines.txt")
+	os.WriteFile(file, []byte("one\ntwo\nthree\n"), 0644)
+	runCommand(t, tempDir, "add", "lines.txt", "scratch.txt", ".gitreignore")
+	runCommand(t, tempDir, "commit", "-a", "-m", "lines")
+	os.WriteFile(file, []byte("ONE\ntwo\nthree\n"), 0644)
+	runCommand(t, tempDir, "stash")
+	os.WriteFile(file, []byte("one\ntwo\nTHREE\n"), 0644)
+	runCommand(t, tempDir, "commit", "-a", "-m", "shout three")
+	runCommand(t, tempDir, "stash", "apply", "stash@{0}")
+	if data, _ := os.ReadFile(file); string(data) != "ONE\ntwo\nTHREE\n" {
+		t.Errorf("stash apply should merge the stashed change. Got: %q", data)
+	}
+	if output := runCommand(t, tempDir, "status", "--short"); output != " M lines.txt\n" {
+		t.Errorf("the applied change should stay unstaged. Got: %s", output)
+	}
+
+	// a conflicting change is left with markers and the stash is kept
+	runCommand(t, tempDir, "reset", "--hard", "HEAD")
+	os.WriteFile(file, []byte("1\ntwo\nTHREE\n"), 0644)
+	runCommand(t, tempDir, "commit", "-a", "-m", "number one")
+	cmd := exec.Command(binPath, "stash", "pop")
+	cmd.Dir = tempDir
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "CONFLICT: merge conflict in lines.txt") {
+		t.Fatalf("a conflicting stash pop should stop. Got: %s", out)
+	}
+	if data, _ := os.ReadFile(file); !strings.Contains(string(data), "<<<<<<< Updated upstream\n1\n=======\nONE\n>>>>>>> Stashed changes\n") {
+		t.Errorf("the conflicted file should hold conflict markers. Got: %q", data)
+	}
+	if output := runCommand(t, tempDir, "status", "--short"); output != "UU lines.txt\n" {
+		t.Errorf("status should report the unmerged path. Got: %s", output)
+	}
+	if output := runCommand(t, tempDir, "stash", "list"); !strings.Contains(output, "stash@{0}") {
+		t.Errorf("a conflicting pop should keep the stash. Got: %s", output)
+	}
+	for _, bad := range []string{"stash@{0x}", "0x", "-"} {
+		cmd = exec.Command(binPath, "stash", "drop", bad)
+		cmd.Dir = tempDir
+		if out, err := cmd.CombinedOutput(); err == nil {
+			t.Errorf("stash drop should reject %q. Got: %s", bad, out)
+		}
+	}
+}
+
+func Test_CherryPickRevert(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-pick-*")
+	defer os.RemoveAll(tempDir)
+
+	setupInit(t, tempDir)
+	file := filepath.Join(tempDir, "lines.txt")
+	os.WriteFile(file, []byte("one\ntwo\nthree\n"), 0644)
+	runCommand(t, tempDir, "add", "lines.txt")
+	runCommand(t, tempDir, "commit", "Initial commit")
+
+	runCommand(t, tempDir, "switch", "-c", "feature")
+	os.WriteFile(file, []byte("one\ntwo\nTHREE\n"), 0644)
+	runCommand(t, tempDir, "add", "lines.txt")
+	runCommand(t, tempDir, "commit", "Shout three")
+
+	runCommand(t, tempDir, "switch", "main")
+	os.WriteFile(file, []byte("ONE\ntwo\nthree\n"), 0644)
+	runCommand(t, tempDir, "add", "lines.txt")
+	runCommand(t, tempDir, "commit", "Shout one")
+
+	runCommand(t, tempDir, "cherry-pick", "feature")
+	if data, _ := os.ReadFile(file); string(data) != "ONE\ntwo\nTHREE\n" {
+		t.Errorf("cherry-pick should merge both changes. Got: %q", data)
+	}
+	if output := runCommand(t, tempDir, "log"); !strings.Contains(output, "Shout three") {
+		t.Errorf("cherry-pick should commit with the original message. Got: %s", output)
+	}
+
+	runCommand(t, tempDir, "revert", "HEAD~1")
+	if data, _ := os.ReadFile(file); string(data) != "one\ntwo\nTHREE\n" {
+		t.Errorf("revert should undo only the reverted commit. Got: %q", data)
+	}
+	if output := runCommand(t, tempDir, "log"); !strings.Contains(output, `Revert "Shout one"`) {
+		t.Errorf("revert should describe the reverted commit. Got: %s", output)
+	}
+
+	// both sides change the same line
+	os.WriteFile(file, []byte("one\ntwo\n3\n"), 0644)
+	runCommand(t, tempDir, "add", "lines.txt")
+	runCommand(t, tempDir, "commit", "Number three")
+	cmd := exec.Command(binPath, "revert", "HEAD~2")
+	cmd.Dir = tempDir
+	if output, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(output), "CONFLICT") {
+		t.Fatalf("conflicting revert should stop. Got: %s", output)
+	}
+	if output := runCommand(t, tempDir, "revert", "--abort"); output != "" {
+		t.Errorf("abort should be silent. Got: %s", output)
+	}
+	if data, _ := os.ReadFile(file); string(data) != "one\ntwo\n3\n" {
+		t.Errorf("abort should restore the working tree. Got: %q", data)
+	}
+
+	cmd = exec.Command(binPath, "cherry-pick", "feature")
+	cmd.Dir = tempDir
+	if output, err := cmd.CombinedOutput(); err == nil {
+		t.Fatalf("conflicting cherry-pick should stop. Got: %s", output)
+	}
+	if data, _ := os.ReadFile(file); !strings.Contains(string(data), "<<<<<<< HEAD\n3\n=======\nTHREE\n>>>>>>>") {
+		t.Errorf("conflicted file should hold conflict markers. Got: %q", data)
+	}
+	if output := runCommand(t, tempDir, "status", "--short"); !strings.Contains(output, "UU lines.txt") {
+		t.Errorf("status should report the unmerged path. Got: %s", output)
+	}
+	cmd = exec.Command(binPath, "cherry-pick", "--continue")
+	cmd.Dir = tempDir
+	if output, err := cmd.CombinedOutput(); err == nil {
+		t.Fatalf("continue should refuse unresolved conflicts. Got: %s", output)
+	}
+
+	os.WriteFile(file, []byte("one\ntwo\nTHREE (3)\n"), 0644)
+	runCommand(t, tempDir, "add", "lines.txt")
+	runCommand(t, tempDir, "cherry-pick", "--continue")
+	if output := runCommand(t, tempDir, "log"); strings.Count(output, "Shout three") != 2 {
+		t.Errorf("continue should commit the resolution. Got: %s", output)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, ".gitre", "sequencer")); !os.IsNotExist(err) {
+		t.Error("finished cherry-pick should remove the sequencer state")
+	}
+}
+
+func Test_Rebase(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the editor script needs a POSIX shell")
+	}
+	tempDir, _ := os.MkdirTemp("", "gitre-rebase-*")
+	defer os.RemoveAll(tempDir)
+
+	commitFile := func(name string, content string, message string) {
+		os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644)
+		runCommand(t, tempDir, "add", name)
+		runCommand(t, tempDir, "commit", message)
+	}
+	setupInit(t, tempDir)
+	commitFile("base.txt", "base\n", "Initial commit")
+	runCommand(t, tempDir, "switch", "-c", "topic")
+	commitFile("one.txt", "one\n", "Add one")
+	commitFile("two.txt", "two\n", "Add two")
+	commitFile("three.txt", "three\n", "Add three")
+	runCommand(t, tempDir, "switch", "main")
+	commitFile("base.txt", "base on main\n", "Change base")
+
+	runCommand(t, tempDir, "switch", "topic")
+	output := runCommand(t, tempDir, "rebase", "main")
+	if !strings.Contains(output, "Successfully rebased and updated refs/heads/topic") {
+		t.Errorf("rebase should report success. Got: %s", output)
+	}
+	if data, _ := os.ReadFile(filepath.Join(tempDir, "base.txt")); string(data) != "base on main\n" {
+		t.Errorf("rebased branch should contain the upstream change. Got: %q", data)
+	}
+	if head, _ := os.ReadFile(filepath.Join(tempDir, ".gitre", "HEAD")); string(head) != "ref: refs/heads/topic\n" {
+		t.Errorf("rebase should reattach HEAD to the branch. Got: %q", head)
+	}
+
+	// squash two into one with fixup, drop three
+	editor := filepath.Join(tempDir, ".gitre", "editor.sh")
+	os.WriteFile(editor, []byte("#!/bin/sh\nsed -i.bak -e '2s/^pick/fixup/' -e '3s/^pick/drop/' \"$1\"\n"), 0755)
+	cmd := exec.Command(binPath, "rebase", "-i", "main")
+	cmd.Dir = tempDir
+	cmd.Env = append(os.Environ(), "GITRE_EDITOR="+editor)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("interactive rebase failed: %v\n%s", err, out)
+	}
+	output = runCommand(t, tempDir, "log")
+	if strings.Count(output, "commit ") != 3 || strings.Contains(output, "Add two") {
+		t.Errorf("fixup should fold 'Add two' into 'Add one'. Got: %s", output)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "three.txt")); !os.IsNotExist(err) {
+		t.Error("dropped commit should not be replayed")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "two.txt")); err != nil {
+		t.Error("fixup should keep the changes of the folded commit")
+	}
+
+	// conflicting change: abort restores the branch, continue commits the resolution
+	commitFile("base.txt", "base on topic\n", "Change base on topic")
+	origHead, _ := os.ReadFile(filepath.Join(tempDir, ".gitre", "refs", "heads", "topic"))
+	runCommand(t, tempDir, "switch", "main")
+	commitFile("base.txt", "base on main again\n", "Change base again")
+	runCommand(t, tempDir, "switch", "topic")
+
+	cmd = exec.Command(binPath, "rebase", "main")
+	cmd.Dir = tempDir
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "CONFLICT") {
+		t.Fatalf("conflicting rebase should stop. Got: %s", out)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, ".gitre", "rebase-merge")); err != nil {
+		t.Fatal("stopped rebase should keep its state in .gitre/rebase-merge")
+	}
+	runCommand(t, tempDir, "rebase", "--abort")
+	if head, _ := os.ReadFile(filepath.Join(tempDir, ".gitre", "refs", "heads", "topic")); string(head) != string(origHead) {
+		t.Errorf("abort should restore the branch. Got: %s", head)
+	}
+	if data, _ := os.ReadFile(filepath.Join(tempDir, "base.txt")); string(data) != "base on topic\n" {
+		t.Errorf("abort should restore the working tree. Got: %q", data)
+	}
+
+	cmd = exec.Command(binPath, "rebase", "main")
+	cmd.Dir = tempDir
+	cmd.CombinedOutput()
+	os.WriteFile(filepath.Join(tempDir, "base.txt"), []byte("base on both\n"), 0644)
+	runCommand(t, tempDir, "add", "base.txt")
+	runCommand(t, tempDir, "rebase", "--continue")
+	output = runCommand(t, tempDir, "log")
+	if !strings.Contains(output, "Change base on topic") || !strings.Contains(output, "Change base again") {
+		t.Errorf("continue should finish the rebase. Got: %s", output)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, ".gitre", "rebase-merge")); !os.IsNotExist(err) {
+		t.Error("finished rebase should remove its state")
+	}
+}
+
+func Test_Merge(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-merge-*")
+	defer os.RemoveAll(tempDir)
+	setupInit(t, tempDir)
+	write := func(name string, content string) {
+		os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644)
+		runCommand(t, tempDir, "add", name)
+	}
+	runFails := func(args ...string) string {
+		cmd := exec.Command(binPath, args...)
+		cmd.Dir = tempDir
+		out, err := cmd.CombinedOutput()
+		if err == nil {
+			t.Errorf("'%v' should fail. Got: %s", args, out)
+		}
+		return string(out)
+	}
+	write("a.txt", "one\ntwo\nthree\n")
+	runCommand(t, tempDir, "commit", "-m", "base")
+
+	// a branch ahead of HEAD is fast-forwarded
+	runCommand(t, tempDir, "switch", "-c", "feature")
+	write("b.txt", "feature\n")
+	runCommand(t, tempDir, "commit", "-m", "feature")
+	runCommand(t, tempDir, "switch", "main")
+	if output := runCommand(t, tempDir, "merge", "feature"); !strings.Contains(output, "Fast-forward") {
+		t.Errorf("merge should fast-forward. Got: %s", output)
+	}
+
+	// diverged branches get a merge commit with both parents
+	runCommand(t, tempDir, "switch", "-c", "side")
+	write("a.txt", "one\ntwo\nthree\nfour\n")
+	runCommand(t, tempDir, "commit", "-m", "side")
+	runCommand(t, tempDir, "switch", "main")
+	write("c.txt", "main\n")
+	runCommand(t, tempDir, "commit", "-m", "main")
+	if out := runFails("merge", "--ff-only", "side"); !strings.Contains(out, "not possible to fast-forward") {
+		t.Errorf("--ff-only should refuse a merge commit. Got: %s", out)
+	}
+	runCommand(t, tempDir, "merge", "side")
+	output := runCommand(t, tempDir, "log")
+	tip, _, _ := strings.Cut(output, "  |")
+	if !strings.Contains(tip, "Merge branch 'side'") || strings.Count(tip, "parent ") != 2 {
+		t.Errorf("merge should record both parents. Got: %s", tip)
+	}
+	if data, _ := os.ReadFile(filepath.Join(tempDir, "a.txt")); string(data) != "one\ntwo\nthree\nfour\n" {
+		t.Errorf("merge should bring in the side change. Got: %q", data)
+	}
+
+	// conflicts stop the merge until they are resolved or the merge is aborted
+	runCommand(t, tempDir, "switch", "-c", "other")
+	write("a.txt", "one\nTWO\nthree\nfour\n")
+	runCommand(t, tempDir, "commit", "-m", "other")
+	runCommand(t, tempDir, "switch", "main")
+	write("a.txt", "one\n2\nthree\nfour\n")
+	runCommand(t, tempDir, "commit", "-m", "mine")
+	if out := runFails("merge", "other"); !strings.Contains(out, "CONFLICT: merge conflict in a.txt") {
+		t.Errorf("merge should report the conflict. Got: %s", out)
+	}
+	runFails("commit", "-m", "too early")
+	runCommand(t, tempDir, "merge", "--abort")
+	if data, _ := os.ReadFile(filepath.Join(tempDir, "a.txt")); string(data) != "one\n2\nthree\nfour\n" {
+		t.Errorf("merge --abort should restore HEAD. Got: %q", data)
+	}
+	runFails("merge", "other")
+	write("a.txt", "one\n2 and TWO\nthree\nfour\n")
+	runCommand(t, tempDir, "merge", "--continue")
+	if output := runCommand(t, tempDir, "status", "--short"); output != "?? .gitreignore\n" && output != "" {
+		t.Errorf("the concluded merge should leave a clean tree. Got: %s", output)
+	}
+	if output := runCommand(t, tempDir, "log"); !strings.Contains(output, "Merge branch 'other'") {
+		t.Errorf("merge --continue should make the merge commit. Got: %s", output)
+	}
+}
+
+func Test_CommitOptions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the editor script needs a POSIX shell")
+	}
+	tempDir, _ := os.MkdirTemp("", "gitre-commit-*")
+	defer os.RemoveAll(tempDir)
+
+	setupInit(t, tempDir)
+	setupAdd(t, tempDir, "file.txt")
+	output := runCommand(t, tempDir, "commit", "-m", "Subject", "-m", "Body paragraph")
+	if !strings.Contains(output, "] Subject") {
+		t.Errorf("commit should print the subject. Got: %s", output)
+	}
+	if output = runCommand(t, tempDir, "log"); !strings.Contains(output, "Subject\n\nBody paragraph") {
+		t.Errorf("repeated -m should become paragraphs. Got: %s", output)
+	}
+
+	cmd := exec.Command(binPath, "commit", "-m", "Again")
+	cmd.Dir = tempDir
+	if out, err := cmd.CombinedOutput(); err == nil {
+		t.Errorf("commit of an unchanged index should fail. Got: %s", out)
+	}
+	runCommand(t, tempDir, "commit", "--allow-empty", "-m", "Empty on purpose")
+
+	os.WriteFile(filepath.Join(tempDir, "file.txt"), []byte("changed"), 0644)
+	runCommand(t, tempDir, "commit", "-a", "-m", "Stage everything")
+	if output = runCommand(t, tempDir, "status", "--short"); strings.Contains(output, "file.txt") {
+		t.Errorf("commit -a should stage tracked modifications. Got: %s", output)
+	}
+
+	runCommand(t, tempDir, "commit", "--amend", "-m", "Stage all tracked files")
+	output = runCommand(t, tempDir, "log")
+	if strings.Contains(output, "Stage everything") || strings.Count(output, "commit ") != 3 {
+		t.Errorf("--amend should replace the tip commit. Got: %s", output)
+	}
+
+	// -m messages are taken as given, lines starting with # included
+	runCommand(t, tempDir, "commit", "--allow-empty", "-m", "Close the issue", "-m", "#12 is fixed")
+	if output = runCommand(t, tempDir, "log"); !strings.Contains(output, "#12 is fixed") {
+		t.Errorf("-m should keep lines starting with #. Got: %s", output)
+	}
+
+	os.WriteFile(filepath.Join(tempDir, "msg.txt"), []byte("\nFrom a file  \n# kept as written\n\n"), 0644)
+	os.WriteFile(filepath.Join(tempDir, "file.txt"), []byte("changed again"), 0644)
+	runCommand(t, tempDir, "add", "file.txt")
+	runCommand(t, tempDir, "commit", "-F", "msg.txt")
+	if output = runCommand(t, tempDir, "log"); !strings.Contains(output, "\n\nFrom a file\n# kept as written\n") {
+		t.Errorf("-F should take the message from the file, only cleaning up whitespace. Got: %q", output)
+	}
+
+	// without a message the editor gets a template listing the staged changes
+	editor := filepath.Join(tempDir, ".gitre", "editor.sh")
+	os.WriteFile(editor, []byte("#!/bin/sh\ncp \"$1\" .gitre/template\necho 'Written in the editor' > \"$1\"\n"), 0755)
+	os.WriteFile(filepath.Join(tempDir, "file.txt"), []byte("edited"), 0644)
+	runCommand(t, tempDir, "add", "file.txt")
+	cmd = exec.Command(binPath, "commit")
+	cmd.Dir = tempDir
+	cmd.Env = append(os.Environ(), "GITRE_EDITOR="+editor)
+	if out, err := cmd.CombinedOutput(); err != nil || !strings.Contains(string(out), "Written in the editor") {
+		t.Errorf("commit should take the message from the editor: %v\n%s", err, out)
+	}
+	if template, _ := os.ReadFile(filepath.Join(tempDir, ".gitre", "template")); !strings.Contains(string(template), "modified:   file.txt") {
+		t.Errorf("editor template should list the staged changes. Got: %s", template)
+	}
+}
+
+func Test_Hooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook scripts need a POSIX shell")
+	}
+	tempDir, _ := os.MkdirTemp("", "gitre-hooks-*")
+	defer os.RemoveAll(tempDir)
+
+	setupInit(t, tempDir)
+	hooks := filepath.Join(tempDir, ".gitre", "hooks")
+	os.MkdirAll(hooks, 0755)
+	writeHook := func(name string, script string) {
+		os.WriteFile(filepath.Join(hooks, name), []byte("#!/bin/sh\n"+script), 0755)
+	}
+	runFails := func(args ...string) string {
+		cmd := exec.Command(binPath, args...)
+		cmd.Dir = tempDir
+		out, err := cmd.CombinedOutput()
+		if err == nil {
+			t.Errorf("'%v' should fail. Got: %s", args, out)
+		}
+		return string(out)
+	}
+
+	writeHook("commit-msg", "grep -q '^[A-Z]\\+-[0-9]\\+' \"$1\" || { echo 'missing ticket id'; exit 1; }\n")
+	writeHook("post-commit", "echo committed > \"$GITRE_DIR/post-commit-ran\"\n")
+	setupAdd(t, tempDir, "file.txt")
+	if out := runFails("commit", "-m", "no ticket"); !strings.Contains(out, "missing ticket id") {
+		t.Errorf("commit-msg hook output should be shown. Got: %s", out)
+	}
+	runCommand(t, tempDir, "commit", "-m", "no ticket", "--no-verify")
+	os.WriteFile(filepath.Join(tempDir, "file.txt"), []byte("second"), 0644)
+	runCommand(t, tempDir, "commit", "-a", "-m", "ABC-12 with ticket")
+	if _, err := os.Stat(filepath.Join(tempDir, ".gitre", "post-commit-ran")); err != nil {
+		t.Error("post-commit hook should run after a commit")
+	}
+
+	writeHook("pre-commit", "exit 3\n")
+	os.WriteFile(filepath.Join(tempDir, "file.txt"), []byte("third"), 0644)
+	if out := runFails("commit", "-a", "-m", "ABC-13 blocked"); !strings.Contains(out, "pre-commit hook failed with exit status 3") {
+		t.Errorf("pre-commit hook should abort the commit. Got: %s", out)
+	}
+	os.Remove(filepath.Join(hooks, "pre-commit"))
+	runCommand(t, tempDir, "commit", "-a", "-m", "ABC-13 allowed")
+
+	// post-checkout gets old and new HEAD and the branch flag
+	writeHook("post-checkout", "echo \"$1 $2 $3\" > \"$GITRE_DIR/checkout-args\"\n")
+	runCommand(t, tempDir, "switch", "-c", "feature")
+	head, _ := os.ReadFile(filepath.Join(tempDir, ".gitre", "refs", "heads", "feature"))
+	args, _ := os.ReadFile(filepath.Join(tempDir, ".gitre", "checkout-args"))
+	if want := strings.TrimSpace(string(head)) + " " + strings.TrimSpace(string(head)) + " 1\n"; string(args) != want {
+		t.Errorf("post-checkout arguments. Got: %q, want %q", args, want)
+	}
+
+	// pre-merge blocks a merge commit, --no-verify bypasses it
+	os.WriteFile(filepath.Join(tempDir, "feature.txt"), []byte("feature"), 0644)
+	runCommand(t, tempDir, "add", "feature.txt")
+	runCommand(t, tempDir, "commit", "-m", "ABC-14 feature")
+	runCommand(t, tempDir, "switch", "main")
+	os.WriteFile(filepath.Join(tempDir, "main.txt"), []byte("main"), 0644)
+	runCommand(t, tempDir, "add", "main.txt")
+	runCommand(t, tempDir, "commit", "-m", "ABC-15 main")
+	writeHook("pre-merge", "exit 1\n")
+	runFails("merge", "-m", "ABC-16 merge feature", "feature")
+	runCommand(t, tempDir, "merge", "--abort")
+	runCommand(t, tempDir, "merge", "--no-verify", "-m", "ABC-16 merge feature", "feature")
+	if _, err := os.Stat(filepath.Join(tempDir, "feature.txt")); err != nil {
+		t.Error("merge should bring in feature.txt")
+	}
+	output := runCommand(t, tempDir, "log")
+	if !strings.Contains(output, "ABC-16 merge feature") {
+		t.Errorf("merge commit missing from log. Got: %s", output)
+	}
+
+	// merge --continue passes --no-verify on to the commit
+	runCommand(t, tempDir, "switch", "feature")
+	os.WriteFile(filepath.Join(tempDir, "feature.txt"), []byte("feature again"), 0644)
+	runCommand(t, tempDir, "commit", "-a", "-m", "ABC-18 feature again")
+	runCommand(t, tempDir, "switch", "main")
+	runFails("merge", "-m", "ABC-19 merge feature again", "feature")
+	writeHook("pre-commit", "exit 1\n")
+	runFails("merge", "--continue")
+	runCommand(t, tempDir, "merge", "--continue", "--no-verify")
+	os.Remove(filepath.Join(hooks, "pre-commit"))
+	if output := runCommand(t, tempDir, "log"); !strings.Contains(output, "ABC-19 merge feature again") {
+		t.Errorf("merge --continue --no-verify should make the merge commit. Got: %s", output)
+	}
+
+	// core.hooksPath moves the hooks elsewhere
+	os.MkdirAll(filepath.Join(tempDir, "ci-hooks"), 0755)
+	os.WriteFile(filepath.Join(tempDir, "ci-hooks", "pre-commit"), []byte("#!/bin/sh\nexit 1\n"), 0755)
+	runCommand(t, tempDir, "config", "core.hooksPath", "ci-hooks")
+	os.WriteFile(filepath.Join(tempDir, "file.txt"), []byte("fourth"), 0644)
+	runFails("commit", "-a", "-m", "ABC-17 blocked by ci hooks")
+}
+
+func Test_RepoDiscovery(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-discovery-*")
+	defer os.RemoveAll(tempDir)
+
+	setupInit(t, tempDir)
+	sub := filepath.Join(tempDir, "src", "pkg")
+	os.MkdirAll(sub, 0755)
+	os.WriteFile(filepath.Join(sub, "code.txt"), []byte("code"), 0644)
+	os.WriteFile(filepath.Join(tempDir, "top.txt"), []byte("top"), 0644)
+
+	// paths are taken relative to the directory gitre runs in
+	runCommand(t, sub, "add", "code.txt", "../../top.txt")
+	runCommand(t, sub, "commit", "-m", "From a subdirectory")
+	if _, err := os.Stat(filepath.Join(sub, ".gitre")); !os.IsNotExist(err) {
+		t.Error("commands in a subdirectory should not create a repository there")
+	}
+	index := readIndex(t, tempDir)
+	if !strings.Contains(index, "src/pkg/code.txt") || !strings.Contains(index, "top.txt") {
+		t.Errorf("index should hold paths from the top of the working tree. Got: %s", index)
+	}
+
+	os.WriteFile(filepath.Join(sub, "code.txt"), []byte("changed"), 0644)
+	if output := runCommand(t, sub, "status", "--short"); !strings.Contains(output, " M src/pkg/code.txt") {
+		t.Errorf("status should work from a subdirectory. Got: %s", output)
+	}
+	runCommand(t, sub, "restore", ".")
+	if data, _ := os.ReadFile(filepath.Join(sub, "code.txt")); string(data) != "code" {
+		t.Errorf("restore . in a subdirectory should restore it. Got: %s", data)
+	}
+
+	// -C behaves as if gitre was started in the given directory
+	if output := runCommand(t, os.TempDir(), "-C", sub, "log"); !strings.Contains(output, "From a subdirectory") {
+		t.Errorf("-C should find the repository. Got: %s", output)
+	}
+
+	outside, _ := os.MkdirTemp("", "gitre-outside-*")
+	defer os.RemoveAll(outside)
+	cmd := exec.Command(binPath, "status")
+	cmd.Dir = outside
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "not a gitre repository") {
+		t.Errorf("status outside a repository should fail. Got: %s", out)
+	}
+
+	// GITRE_DIR and GITRE_WORK_TREE point at a repository and working tree anywhere
+	os.WriteFile(filepath.Join(outside, "other.txt"), []byte("other"), 0644)
+	cmd = exec.Command(binPath, "add", "other.txt")
+	cmd.Dir = outside
+	cmd.Env = append(os.Environ(), "GITRE_DIR="+filepath.Join(tempDir, ".gitre"), "GITRE_WORK_TREE="+outside)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("add with GITRE_DIR failed: %v\n%s", err, out)
+	}
+	if index := readIndex(t, tempDir); !strings.Contains(index, "other.txt") {
+		t.Errorf("GITRE_DIR should select the index. Got: %s", index)
+	}
+	if _, err := os.Stat(filepath.Join(outside, ".gitre")); !os.IsNotExist(err) {
+		t.Error("GITRE_DIR should not create a repository in the working tree")
+	}
+}
+
+func Test_Library(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-library-*")
+	defer os.RemoveAll(tempDir)
+
+	repo, err := gitre.Init(filepath.Join(tempDir, ".gitre"), tempDir)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	os.WriteFile(filepath.Join(tempDir, "a.txt"), []byte("hello\n"), 0644)
+
+	idx, err := repo.ReadIndex()
+	if err != nil {
+		t.Fatalf("ReadIndex failed: %v", err)
+	}
+	entry, err := repo.IndexObject(idx, "a.txt", repo.IndexMtime())
+	if err != nil || entry == nil {
+		t.Fatalf("IndexObject failed: %v", err)
+	}
+	idx.Set(*entry)
+	if err := idx.Write(); err != nil {
+		t.Fatalf("index write failed: %v", err)
+	}
+	hash, err := repo.Commit("first\n")
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	if head, err := repo.ResolveRev("HEAD"); err != nil || head != hash {
+		t.Errorf("HEAD should resolve to %s, got %s (%v)", hash, head, err)
+	}
+	result, err := repo.Status(false)
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if result.Branch != "main" || len(result.Entries) != 0 {
+		t.Errorf("unexpected status: %+v", result)
+	}
+	var messages []string
+	repo.Log(hash, func(h string, c *gitre.Commit, raw []byte) error {
+		messages = append(messages, c.Message)
+		return nil
+	})
+	if len(messages) != 1 || messages[0] != "first\n" {
+		t.Errorf("unexpected log: %q", messages)
+	}
+
+	// the command line sees what the library wrote
+	if out := runCommand(t, tempDir, "log"); !strings.Contains(out, "commit "+hash) {
+		t.Errorf("log should show the commit made through the library, got: %s", out)
+	}
+	if _, err := gitre.Open(filepath.Join(tempDir, "missing")); err != nil {
+		t.Errorf("Open should find the repository above a path: %v", err)
+	}
+}
+
+func Test_ObjectStore(t *testing.T) {
+	// objects, trees and commits need no files at all with the in-memory backend
+	repo := &gitre.Repository{Objects: gitre.NewMemoryStore()}
+	blob, err := repo.HashStore([]byte("hello\n"), "blob")
+	if err != nil {
+		t.Fatalf("HashStore failed: %v", err)
+	}
+	tree, err := repo.WriteTree(gitre.BuildTree([]gitre.IndexEntry{{Path: "dir/a.txt", Hash: blob, Mode: gitre.ModeRegular}}))
+	if err != nil {
+		t.Fatalf("WriteTree failed: %v", err)
+	}
+	commit, err := repo.WriteCommit(tree, nil, "first\n")
+	if err != nil {
+		t.Fatalf("WriteCommit failed: %v", err)
+	}
+	entries, err := repo.ReadCommitTree(commit)
+	if err != nil || entries["dir/a.txt"].Hash != blob {
+		t.Errorf("commit tree should hold dir/a.txt, got %v (%v)", entries, err)
+	}
+	if data, err := repo.ExtractObject([]byte(blob)); err != nil || string(data) != "hello\n" {
+		t.Errorf("unexpected blob content %q (%v)", data, err)
+	}
+	if resolved, err := repo.ResolveRev(commit[:8]); err != nil || resolved != commit {
+		t.Errorf("abbreviated hash should resolve to %s, got %s (%v)", commit, resolved, err)
+	}
+	count := 0
+	repo.Objects.Iterate(func(string) error { count++; return nil })
+	if count != 4 {
+		t.Errorf("expected 4 objects (blob, 2 trees, commit), got %d", count)
+	}
+
+	// a message that looks like headers stays the message
+	second, err := repo.WriteCommit(tree, []string{commit}, "parent of nothing\ntree planting\n")
+	if err != nil {
+		t.Fatalf("WriteCommit failed: %v", err)
+	}
+	if c, err := repo.ReadCommit(second); err != nil || c.Message != "parent of nothing\ntree planting\n" || len(c.Parents) != 1 {
+		t.Errorf("unexpected commit %+v (%v)", c, err)
+	}
+	// commits written without the empty line after the headers still parse
+	old := gitre.ParseCommit([]byte("tree " + tree + "\nparent " + commit + "\nsubject\n\nbody\n"))
+	if old.Tree != tree || len(old.Parents) != 1 || old.Message != "subject\n\nbody\n" {
+		t.Errorf("unexpected commit %+v", old)
+	}
+
+	// the loose backend sees what the command line wrote
+	tempDir, _ := os.MkdirTemp("", "gitre-store-*")
+	defer os.RemoveAll(tempDir)
+	setupInit(t, tempDir)
+	os.WriteFile(filepath.Join(tempDir, "a.txt"), []byte("hello\n"), 0644)
+	runCommand(t, tempDir, "add", "a.txt")
+	store := gitre.NewLooseStore(filepath.Join(tempDir, ".gitre", "objects"))
+	if !store.Has(blob) {
+		t.Errorf("loose store should have the added blob %s", blob)
+	}
+	if content, err := store.Get(blob); err != nil || string(content) != "blob 6\x00hello\n" {
+		t.Errorf("unexpected loose object %q (%v)", content, err)
+	}
+	// abbreviated hashes are looked up in their own objects directory
+	if hashes, err := store.HashesWithPrefix(blob[:6]); err != nil || len(hashes) != 1 || hashes[0] != blob {
+		t.Errorf("prefix lookup should find %s, got %v (%v)", blob, hashes, err)
+	}
+	if hashes, err := store.HashesWithPrefix(blob[:2] + "zz"); err == nil || hashes != nil {
+		t.Errorf("prefix lookup should reject non-hex prefixes, got %v", hashes)
+	}
+}
+
+func Test_CloneFetchPush(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-remote-*")
+	defer os.RemoveAll(tempDir)
+	origin := filepath.Join(tempDir, "origin")
+	os.Mkdir(origin, 0755)
+	setupInit(t, origin)
+	os.MkdirAll(filepath.Join(origin, "dir"), 0755)
+	os.WriteFile(filepath.Join(origin, "dir", "a.txt"), []byte("one\n"), 0644)
+	runCommand(t, origin, "add", "dir")
+	runCommand(t, origin, "commit", "-m", "first")
+
+	runCommand(t, tempDir, "clone", "origin", "work")
+	work := filepath.Join(tempDir, "work")
+	if data, _ := os.ReadFile(filepath.Join(work, "dir", "a.txt")); string(data) != "one\n" {
+		t.Errorf("clone should check out the files. Got: %q", data)
+	}
+	if output := runCommand(t, work, "status", "--short"); output != "" {
+		t.Errorf("a fresh clone should be clean. Got: %s", output)
+	}
+	if output := runCommand(t, work, "config", "remote.origin.url"); strings.TrimSpace(output) != origin {
+		t.Errorf("clone should record the origin. Got: %s", output)
+	}
+
+	// a bare clone is the shared repository both sides push to
+	runCommand(t, tempDir, "clone", "--bare", "origin", "shared.gitre")
+	shared := filepath.Join(tempDir, "shared.gitre")
+	if _, err := os.Stat(filepath.Join(shared, "HEAD")); err != nil {
+		t.Fatalf("bare clone should be a repository directory: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(work, "dir", "a.txt"), []byte("two\n"), 0644)
+	runCommand(t, work, "commit", "-a", "-m", "second")
+	output := runCommand(t, work, "push", shared, "main")
+	if !strings.Contains(output, "main -> main") {
+		t.Errorf("push should report the updated branch. Got: %s", output)
+	}
+	if output := runCommand(t, shared, "log"); !strings.Contains(output, "second") {
+		t.Errorf("the pushed commit should be in the shared repository. Got: %s", output)
+	}
+
+	// a push that would lose the remote's commits is refused unless forced
+	os.WriteFile(filepath.Join(origin, "b.txt"), []byte("b"), 0644)
+	runCommand(t, origin, "add", "b.txt")
+	runCommand(t, origin, "commit", "-m", "diverged")
+	cmd := exec.Command(binPath, "push", shared, "main")
+	cmd.Dir = origin
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "non-fast-forward") {
+		t.Errorf("a non-fast-forward push should be rejected. Got: %s", out)
+	}
+	runCommand(t, origin, "push", "--force", shared, "main")
+	if output := runCommand(t, shared, "log"); !strings.Contains(output, "diverged") || strings.Contains(output, "second") {
+		t.Errorf("a forced push should replace the branch. Got: %s", output)
+	}
+
+	// the checked out branch of a working repository is not pushed to
+	cmd = exec.Command(binPath, "push", "--force", work, "main")
+	cmd.Dir = origin
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "checked out branch") {
+		t.Errorf("pushing to a checked out branch should be refused. Got: %s", out)
+	}
+
+	// fetch copies the missing objects and records the branches in FETCH_HEAD
+	output = runCommand(t, work, "fetch", origin)
+	if !strings.Contains(output, "main -> FETCH_HEAD") {
+		t.Errorf("fetch should list the fetched branch. Got: %s", output)
+	}
+	if output := runCommand(t, work, "log", "FETCH_HEAD"); !strings.Contains(output, "diverged") {
+		t.Errorf("FETCH_HEAD should point at the fetched commit. Got: %s", output)
+	}
+
+	// a repository directory given directly clones into a directory named after its working tree
+	copies := filepath.Join(tempDir, "copies")
+	os.Mkdir(copies, 0755)
+	if output := runCommand(t, copies, "clone", filepath.Join(origin, ".gitre")); !strings.Contains(output, "Cloning into 'origin'") {
+		t.Errorf("clone should name the directory after the working tree. Got: %s", output)
+	}
+	if _, err := os.Stat(filepath.Join(copies, "origin", "dir", "a.txt")); err != nil {
+		t.Errorf("clone should check out into the named directory: %v", err)
+	}
+
+	// a remote advertising refs outside refs/ is refused before anything is written
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "HEAD refs/heads/main\n%s refs/../../escaped\n", strings.Repeat("a", 64))
+	}))
+	defer server.Close()
+	cmd = exec.Command(binPath, "clone", server.URL+"/evil", "evil")
+	cmd.Dir = tempDir
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "invalid ref") {
+		t.Errorf("clone should refuse invalid remote refs. Got: %s", out)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "evil")); !os.IsNotExist(err) {
+		t.Errorf("a refused clone should leave no directory behind")
+	}
+}
+
+func Test_Remotes(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-remotes-*")
+	defer os.RemoveAll(tempDir)
+	upstream := filepath.Join(tempDir, "upstream")
+	os.Mkdir(upstream, 0755)
+	setupInit(t, upstream)
+	os.WriteFile(filepath.Join(upstream, "a.txt"), []byte("one\n"), 0644)
+	runCommand(t, upstream, "add", "a.txt")
+	runCommand(t, upstream, "commit", "-m", "first")
+
+	work := filepath.Join(tempDir, "work")
+	os.Mkdir(work, 0755)
+	setupInit(t, work)
+	runCommand(t, work, "remote", "add", "up", "../upstream")
+	if output := runCommand(t, work, "remote", "-v"); !strings.Contains(output, "up\t"+upstream+" (fetch)") {
+		t.Errorf("remote -v should list the remote with its absolute path. Got: %s", output)
+	}
+
+	output := runCommand(t, work, "fetch", "up")
+	if !strings.Contains(output, "[new branch]") || !strings.Contains(output, "main -> up/main") {
+		t.Errorf("fetch should create the remote-tracking branch. Got: %s", output)
+	}
+	runCommand(t, work, "reset", "--hard", "up/main")
+	runCommand(t, work, "branch", "--set-upstream-to=up/main")
+	if output := runCommand(t, work, "config", "branch.main.merge"); strings.TrimSpace(output) != "refs/heads/main" {
+		t.Errorf("set-upstream-to should configure the merge branch. Got: %s", output)
+	}
+
+	// ahead and behind are counted against the remote-tracking branch
+	os.WriteFile(filepath.Join(upstream, "a.txt"), []byte("two\n"), 0644)
+	runCommand(t, upstream, "commit", "-a", "-m", "upstream change")
+	os.WriteFile(filepath.Join(work, "b.txt"), []byte("b"), 0644)
+	runCommand(t, work, "add", "b.txt")
+	runCommand(t, work, "commit", "-m", "local change")
+	runCommand(t, work, "fetch", "up")
+	if output := runCommand(t, work, "status", "-s", "-b"); !strings.Contains(output, "## main...up/main [ahead 1, behind 1]") {
+		t.Errorf("status should report ahead and behind. Got: %s", output)
+	}
+	if output := runCommand(t, work, "branch", "-vv"); !strings.Contains(output, "[up/main: ahead 1, behind 1]") {
+		t.Errorf("branch -vv should show the upstream. Got: %s", output)
+	}
+	if output := runCommand(t, work, "remote", "show", "up"); !strings.Contains(output, "main tracked") || !strings.Contains(output, "main merges with remote main") {
+		t.Errorf("remote show should describe branches. Got: %s", output)
+	}
+
+	// renaming moves the remote-tracking branches and the upstream configuration along
+	runCommand(t, work, "remote", "rename", "up", "origin")
+	if output := runCommand(t, work, "status", "-s", "-b"); !strings.Contains(output, "## main...origin/main") {
+		t.Errorf("the upstream should follow the renamed remote. Got: %s", output)
+	}
+	runCommand(t, work, "remote", "remove", "origin")
+	if output := runCommand(t, work, "remote"); output != "" {
+		t.Errorf("no remote should be left. Got: %s", output)
+	}
+	if _, err := os.Stat(filepath.Join(work, ".gitre", "refs", "remotes", "origin")); !os.IsNotExist(err) {
+		t.Error("removing a remote should delete its remote-tracking branches")
+	}
+	if output := runCommand(t, work, "status", "-s", "-b"); strings.Contains(output, "...") {
+		t.Errorf("removing a remote should drop the upstream. Got: %s", output)
+	}
+
+	// remote and branch names cannot reach outside their refs directory
+	for _, args := range [][]string{
+		{"remote", "add", "..", "/tmp"},
+		{"remote", "remove", ".."},
+		{"branch", "-D", "../../HEAD"},
+		{"branch", "../../../escaped"},
+		{"branch", "x.lock"},
+		{"switch", "-c", "../escaped"},
+	} {
+		cmd := exec.Command(binPath, args...)
+		cmd.Dir = work
+		if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "not a valid") {
+			t.Errorf("%v should be refused. Got: %s", args, out)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(work, ".gitre", "HEAD")); err != nil {
+		t.Errorf("HEAD should survive an invalid branch deletion")
+	}
+}
+
+func Test_HTTPTransport(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-http-*")
+	defer os.RemoveAll(tempDir)
+	origin := filepath.Join(tempDir, "origin")
+	os.Mkdir(origin, 0755)
+	setupInit(t, origin)
+	os.WriteFile(filepath.Join(origin, "a.txt"), []byte("one\n"), 0644)
+	runCommand(t, origin, "add", "a.txt")
+	runCommand(t, origin, "commit", "-m", "first")
+	runCommand(t, tempDir, "clone", "--bare", "origin", "shared.gitre")
+
+	server := exec.Command(binPath, "serve", "--http", "127.0.0.1:0", "--enable-push")
+	server.Dir = tempDir
+	stdout, _ := server.StdoutPipe()
+	if err := server.Start(); err != nil {
+		t.Fatalf("failed to start the server: %v", err)
+	}
+	defer server.Process.Kill()
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("the server should report its address: %v", err)
+	}
+	url := strings.TrimSpace(line[strings.Index(line, "http://"):]) + "/shared.gitre"
+
+	runCommand(t, tempDir, "clone", url, "work")
+	work := filepath.Join(tempDir, "work")
+	if data, _ := os.ReadFile(filepath.Join(work, "a.txt")); string(data) != "one\n" {
+		t.Errorf("clone over http should check out the files. Got: %q", data)
+	}
+
+	// only the objects the server lacks are sent
+	os.WriteFile(filepath.Join(work, "b.txt"), []byte("two\n"), 0644)
+	runCommand(t, work, "add", "b.txt")
+	runCommand(t, work, "commit", "-m", "second")
+	if output := runCommand(t, work, "push", "origin"); !strings.Contains(output, "main -> main") {
+		t.Errorf("push over http should update the branch. Got: %s", output)
+	}
+	if output := runCommand(t, filepath.Join(tempDir, "shared.gitre"), "log"); !strings.Contains(output, "second") {
+		t.Errorf("the pushed commit should be in the served repository. Got: %s", output)
+	}
+
+	runCommand(t, origin, "remote", "add", "web", url)
+	output := runCommand(t, origin, "fetch", "web")
+	if !strings.Contains(output, "main -> web/main") || !strings.Contains(output, "fetched 3 objects") {
+		t.Errorf("fetch over http should only transfer the new commit, tree and blob. Got: %s", output)
+	}
+
+	// the server applies the same checks as a local push
+	cmd := exec.Command(binPath, "push", "--force", url+"/../origin", "main")
+	cmd.Dir = work
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "checked out branch") {
+		t.Errorf("pushing to a checked out branch over http should be refused. Got: %s", out)
+	}
+
+	// ref names from the request cannot leave the refs directory
+	hash := strings.Repeat("0", 64)
+	for _, name := range []string{"refs/../../../escaped", "refs/heads/x.lock", "/tmp/escaped"} {
+		body := "update " + hash + " " + hash + " " + name + "\n\n"
+		resp, err := http.Post(url+"/receive-pack", "application/x-gitre-pack", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to post to the server: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Errorf("pushing to %s should be refused", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "escaped")); err == nil {
+		t.Errorf("a push should not write outside the repository")
+	}
+
+	// a branch is only moved onto history the repository holds completely
+	bare, err := gitre.OpenRemote(filepath.Join(tempDir, "shared.gitre"))
+	if err != nil {
+		t.Fatalf("OpenRemote failed: %v", err)
+	}
+	dangling, err := bare.WriteCommit(strings.Repeat("1", 64), nil, "tree never sent\n")
+	if err != nil {
+		t.Fatalf("WriteCommit failed: %v", err)
+	}
+	if err := bare.ApplyRefUpdates([]gitre.RefUpdate{{Name: "refs/heads/broken", New: dangling}}); err == nil || !strings.Contains(err.Error(), "incomplete") {
+		t.Errorf("an update onto incomplete history should be refused, got %v", err)
+	}
+
+	// without push enabled the server is read only, and it only hands out the history of its refs
+	readOnly := httptest.NewServer(&gitre.HTTPServer{Root: tempDir})
+	defer readOnly.Close()
+	os.WriteFile(filepath.Join(work, "c.txt"), []byte("three\n"), 0644)
+	runCommand(t, work, "add", "c.txt")
+	runCommand(t, work, "commit", "-m", "third")
+	cmd = exec.Command(binPath, "push", readOnly.URL+"/shared.gitre", "main")
+	cmd.Dir = work
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "pushing is disabled") {
+		t.Errorf("push to a read only server should be refused. Got: %s", out)
+	}
+	resp, err := http.Post(readOnly.URL+"/shared.gitre/upload-pack", "text/plain", strings.NewReader("want "+dangling+"\n"))
+	if err != nil {
+		t.Fatalf("failed to post to the server: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("wanting an object no ref points at should be refused, got status %d", resp.StatusCode)
+	}
+}
+
+func Test_Pull(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-pull-*")
+	defer os.RemoveAll(tempDir)
+	upstream := filepath.Join(tempDir, "upstream")
+	os.Mkdir(upstream, 0755)
+	setupInit(t, upstream)
+	os.WriteFile(filepath.Join(upstream, "a.txt"), []byte("one\n"), 0644)
+	os.WriteFile(filepath.Join(upstream, "b.txt"), []byte("one\n"), 0644)
+	runCommand(t, upstream, "add", "a.txt", "b.txt")
+	runCommand(t, upstream, "commit", "-m", "first")
+	runCommand(t, tempDir, "clone", "upstream", "work")
+	work := filepath.Join(tempDir, "work")
+
+	// a fast-forward keeps local changes to paths the pulled commits do not touch
+	os.WriteFile(filepath.Join(upstream, "a.txt"), []byte("two\n"), 0644)
+	runCommand(t, upstream, "commit", "-a", "-m", "second")
+	os.WriteFile(filepath.Join(work, "b.txt"), []byte("local\n"), 0644)
+	if output := runCommand(t, work, "pull"); !strings.Contains(output, "Fast-forward") {
+		t.Errorf("pull should fast-forward to the upstream. Got: %s", output)
+	}
+	if data, _ := os.ReadFile(filepath.Join(work, "a.txt")); string(data) != "two\n" {
+		t.Errorf("pull should update the working tree. Got: %q", data)
+	}
+	if output := runCommand(t, work, "status", "--short"); output != " M b.txt\n" {
+		t.Errorf("the unrelated local change should be kept. Got: %s", output)
+	}
+
+	// local changes to a path the pulled commits change are refused
+	os.WriteFile(filepath.Join(upstream, "b.txt"), []byte("two\n"), 0644)
+	runCommand(t, upstream, "commit", "-a", "-m", "third")
+	cmd := exec.Command(binPath, "pull")
+	cmd.Dir = work
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "would be overwritten by pull:\n\tb.txt") {
+		t.Errorf("pull should refuse to overwrite local changes. Got: %s", out)
+	}
+	runCommand(t, work, "restore", "b.txt")
+
+	// diverged branches are merged, or refused with --ff-only
+	os.WriteFile(filepath.Join(work, "c.txt"), []byte("c\n"), 0644)
+	runCommand(t, work, "add", "c.txt")
+	runCommand(t, work, "commit", "-m", "local")
+	cmd = exec.Command(binPath, "pull", "--ff-only")
+	cmd.Dir = work
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "not possible to fast-forward") {
+		t.Errorf("pull --ff-only should refuse diverged branches. Got: %s", out)
+	}
+	os.WriteFile(filepath.Join(work, "a.txt"), []byte("local\n"), 0644)
+	cmd = exec.Command(binPath, "pull")
+	cmd.Dir = work
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "local changes would be overwritten by pull") {
+		t.Errorf("pull should refuse to merge diverged branches over local changes. Got: %s", out)
+	}
+	if data, _ := os.ReadFile(filepath.Join(work, "a.txt")); string(data) != "local\n" {
+		t.Errorf("a refused pull should keep the local change. Got: %q", data)
+	}
+	runCommand(t, work, "restore", "a.txt")
+	runCommand(t, work, "pull", "origin", "main")
+	if output := runCommand(t, work, "log"); !strings.Contains(output, "Merge branch 'main' of "+upstream) {
+		t.Errorf("pull should merge the upstream. Got: %s", output)
+	}
+	if data, _ := os.ReadFile(filepath.Join(work, "b.txt")); string(data) != "two\n" {
+		t.Errorf("the merge should bring in the upstream changes. Got: %q", data)
+	}
+
+	// pull.rebase replays the local commits on the upstream instead
+	os.WriteFile(filepath.Join(upstream, "d.txt"), []byte("d\n"), 0644)
+	runCommand(t, upstream, "add", "d.txt")
+	runCommand(t, upstream, "commit", "-m", "fourth")
+	os.WriteFile(filepath.Join(work, "e.txt"), []byte("e\n"), 0644)
+	runCommand(t, work, "add", "e.txt")
+	runCommand(t, work, "commit", "-m", "local2")
+	runCommand(t, work, "config", "pull.rebase", "true")
+	runCommand(t, work, "pull")
+	output := runCommand(t, work, "log")
+	if strings.Contains(output, "Merge branch") || strings.Index(output, "local2") > strings.Index(output, "fourth") {
+		t.Errorf("pull with pull.rebase should rebase the local commits onto the upstream. Got: %s", output)
+	}
+	if output := runCommand(t, work, "status", "--short"); output != "" {
+		t.Errorf("the working tree should be clean after the rebase. Got: %s", output)
+	}
+}
+
+func Test_Bundle(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-bundle-*")
+	defer os.RemoveAll(tempDir)
+	src := filepath.Join(tempDir, "src")
+	os.Mkdir(src, 0755)
+	setupInit(t, src)
+	os.WriteFile(filepath.Join(src, "a.txt"), []byte("one\n"), 0644)
+	runCommand(t, src, "add", "a.txt")
+	runCommand(t, src, "commit", "-m", "first")
+	runCommand(t, src, "bundle", "create", "../full.bundle", "main")
+
+	runCommand(t, tempDir, "clone", "full.bundle")
+	work := filepath.Join(tempDir, "full")
+	if data, _ := os.ReadFile(filepath.Join(work, "a.txt")); string(data) != "one\n" {
+		t.Errorf("clone from a bundle should check out its default branch. Got: %q", data)
+	}
+
+	// an incremental bundle only carries what its prerequisites do not reach
+	os.WriteFile(filepath.Join(src, "b.txt"), []byte("two\n"), 0644)
+	runCommand(t, src, "add", "b.txt")
+	runCommand(t, src, "commit", "-m", "second")
+	runCommand(t, src, "bundle", "create", "../inc.bundle", "main~1..main")
+	if output := runCommand(t, work, "bundle", "verify", "../inc.bundle"); !strings.Contains(output, "requires these 1 commits") || !strings.Contains(output, "is okay") {
+		t.Errorf("verify should accept a bundle whose prerequisites are present. Got: %s", output)
+	}
+	if output := runCommand(t, work, "fetch", "../inc.bundle"); !strings.Contains(output, "main -> FETCH_HEAD") || !strings.Contains(output, "fetched 3 objects") {
+		t.Errorf("fetch from a bundle should add the new objects. Got: %s", output)
+	}
+	runCommand(t, work, "reset", "--hard", "FETCH_HEAD")
+	if data, _ := os.ReadFile(filepath.Join(work, "b.txt")); string(data) != "two\n" {
+		t.Errorf("the fetched commit should be usable. Got: %q", data)
+	}
+
+	empty := filepath.Join(tempDir, "empty")
+	os.Mkdir(empty, 0755)
+	setupInit(t, empty)
+	cmd := exec.Command(binPath, "bundle", "verify", "../inc.bundle")
+	cmd.Dir = empty
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "lacks these prerequisite commits") {
+		t.Errorf("verify should report missing prerequisites. Got: %s", out)
+	}
+	cmd = exec.Command(binPath, "clone", "inc.bundle", "partial")
+	cmd.Dir = tempDir
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "lacks these prerequisite commits") {
+		t.Errorf("clone should refuse an incremental bundle. Got: %s", out)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "partial")); !os.IsNotExist(err) {
+		t.Errorf("a failed clone should not leave a directory behind")
+	}
+
+	// the refs a bundle names are checked before anything is written
+	header, _ := os.ReadFile(filepath.Join(tempDir, "inc.bundle"))
+	signature, _, _ := strings.Cut(string(header), "\n")
+	evil := signature + "\n" + strings.Repeat("a", 64) + " refs/../../escaped\n\n"
+	os.WriteFile(filepath.Join(tempDir, "evil.bundle"), []byte(evil), 0644)
+	cmd = exec.Command(binPath, "clone", "evil.bundle")
+	cmd.Dir = tempDir
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "invalid ref") {
+		t.Errorf("clone should reject a bundle with invalid ref names. Got: %s", out)
+	}
+}
+
+func Test_GitInterop(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	tempDir, _ := os.MkdirTemp("", "gitre-git-*")
+	defer os.RemoveAll(tempDir)
+	gitRepo := filepath.Join(tempDir, "g")
+	runGit := func(dir string, args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=Ann Author", "GIT_AUTHOR_EMAIL=ann@example.com",
+			"GIT_COMMITTER_NAME=Cy Committer", "GIT_COMMITTER_EMAIL=cy@example.com",
+			"GIT_AUTHOR_DATE=1700000000 +0100", "GIT_COMMITTER_DATE=1700000100 +0100")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+		return string(out)
+	}
+	os.MkdirAll(gitRepo, 0755)
+	runGit(gitRepo, "init", "-q", "-b", "main")
+	os.MkdirAll(filepath.Join(gitRepo, "dir"), 0755)
+	os.WriteFile(filepath.Join(gitRepo, "dir", "a.txt"), []byte("one\n"), 0644)
+	os.WriteFile(filepath.Join(gitRepo, "dir.txt"), []byte("two\n"), 0755)
+	runGit(gitRepo, "add", "-A")
+	runGit(gitRepo, "commit", "-q", "-m", "first")
+	runGit(gitRepo, "checkout", "-q", "-b", "feature")
+	os.WriteFile(filepath.Join(gitRepo, "b.txt"), []byte("b\n"), 0644)
+	runGit(gitRepo, "add", "b.txt")
+	runGit(gitRepo, "commit", "-q", "-m", "second")
+	runGit(gitRepo, "checkout", "-q", "main")
+	runGit(gitRepo, "tag", "v1", "feature")
+	original := runGit(gitRepo, "for-each-ref", "--format=%(objectname) %(refname)")
+
+	work := filepath.Join(tempDir, "work")
+	os.Mkdir(work, 0755)
+	setupInit(t, work)
+	output := runCommand(t, work, "import-git", filepath.Join(gitRepo, ".git"))
+	if !strings.Contains(output, "refs/heads/feature") || !strings.Contains(output, "refs/tags/v1") {
+		t.Errorf("import-git should import branches and tags. Got: %s", output)
+	}
+	if data, _ := os.ReadFile(filepath.Join(work, "dir", "a.txt")); string(data) != "one\n" {
+		t.Errorf("importing into an empty repository should check out git's branch. Got: %q", data)
+	}
+	if info, err := os.Stat(filepath.Join(work, "dir.txt")); err != nil || info.Mode().Perm()&0100 == 0 {
+		t.Errorf("the executable bit should survive the import")
+	}
+	if output := runCommand(t, work, "log", "feature"); !strings.Contains(output, "author Ann Author <ann@example.com> 1700000000 +0100") || !strings.Contains(output, "first") {
+		t.Errorf("the history and authors should be kept. Got: %s", output)
+	}
+
+	// exporting the imported history reproduces the original git objects
+	exported := filepath.Join(tempDir, "out.git")
+	runCommand(t, work, "export-git", exported)
+	runGit(exported, "fsck", "--strict")
+	if refs := runGit(exported, "for-each-ref", "--format=%(objectname) %(refname)"); refs != original {
+		t.Errorf("export-git should reproduce the git hashes.\nExpected: %s\nGot: %s", original, refs)
+	}
+	if data, _ := os.ReadFile(filepath.Join(work, ".gitre", "git-map")); len(strings.Split(strings.TrimSpace(string(data)), "\n")) != 8 {
+		t.Errorf("the git-map should pair every converted object once. Got: %s", data)
+	}
+
+	// commits made in gitre get the configured identity
+	os.WriteFile(filepath.Join(work, "c.txt"), []byte("c\n"), 0644)
+	runCommand(t, work, "add", "c.txt")
+	runCommand(t, work, "commit", "-m", "native")
+	runCommand(t, work, "config", "user.name", "Nat Ive")
+	runCommand(t, work, "export-git", exported)
+	runGit(exported, "fsck", "--strict")
+	if output := runGit(exported, "log", "--format=%an %s", "main"); !strings.HasPrefix(output, "Nat Ive native\nAnn Author first") {
+		t.Errorf("the native commit should be exported on top of the imported history. Got: %s", output)
+	}
+
+	// rewritten git history does not overwrite existing branches and tags unless forced
+	runGit(gitRepo, "checkout", "-q", "feature")
+	runGit(gitRepo, "commit", "-q", "--amend", "-m", "second, reworded")
+	runGit(gitRepo, "checkout", "-q", "main")
+	runGit(gitRepo, "tag", "-f", "v1", "main")
+	cmd := exec.Command(binPath, "import-git", filepath.Join(gitRepo, ".git"))
+	cmd.Dir = work
+	out, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "[rejected] refs/heads/feature (non-fast-forward)") || !strings.Contains(string(out), "[rejected] refs/tags/v1 (would clobber existing tag)") {
+		t.Errorf("import-git should refuse to rewrite existing refs. Got: %s", out)
+	}
+	if output := runCommand(t, work, "log", "feature"); strings.Contains(output, "reworded") {
+		t.Errorf("the refused branch should keep its history. Got: %s", output)
+	}
+	runCommand(t, work, "switch", "-c", "local")
+	runCommand(t, work, "import-git", "--force", filepath.Join(gitRepo, ".git"))
+	if output := runCommand(t, work, "log", "feature"); !strings.Contains(output, "reworded") {
+		t.Errorf("import-git --force should overwrite the branch. Got: %s", output)
+	}
+	tag, _ := os.ReadFile(filepath.Join(work, ".gitre", "refs", "tags", "v1"))
+	if main, _ := os.ReadFile(filepath.Join(work, ".gitre", "refs", "heads", "main")); len(main) == 0 || string(tag) != string(main) {
+		t.Errorf("import-git --force should move the tag to %s. Got: %s", main, tag)
+	}
+
+	// a damaged git-map is reported rather than trusted
+	mapPath := filepath.Join(work, ".gitre", "git-map")
+	mapData, _ := os.ReadFile(mapPath)
+	os.WriteFile(mapPath, append(mapData, "deadbeef x\n"...), 0644)
+	cmd = exec.Command(binPath, "export-git", exported)
+	cmd.Dir = work
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "invalid git-map line") {
+		t.Errorf("export-git should reject a malformed git-map. Got: %s", out)
+	}
+	os.WriteFile(mapPath, mapData, 0644)
+
+	// tree entries that would escape the working tree are refused
+	bad := filepath.Join(tempDir, "bad")
+	os.MkdirAll(bad, 0755)
+	runGit(bad, "init", "-q", "-b", "main")
+	os.WriteFile(filepath.Join(bad, "x.txt"), []byte("x\n"), 0644)
+	blob := strings.TrimSpace(runGit(bad, "hash-object", "-w", "x.txt"))
+	for _, name := range []string{"..", ".gitre"} {
+		cmd := exec.Command("git", "mktree")
+		cmd.Dir = bad
+		cmd.Stdin = strings.NewReader("100644 blob " + blob + "\t" + name + "\n")
+		tree, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("git mktree failed: %v", err)
+		}
+		commit := runGit(bad, "commit-tree", "-m", "bad", strings.TrimSpace(string(tree)))
+		runGit(bad, "update-ref", "refs/heads/main", strings.TrimSpace(commit))
+		target := filepath.Join(tempDir, "target-"+strings.Trim(name, "."))
+		os.Mkdir(target, 0755)
+		setupInit(t, target)
+		cmd = exec.Command(binPath, "import-git", filepath.Join(bad, ".git"))
+		cmd.Dir = target
+		if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "invalid entry name") {
+			t.Errorf("import-git should refuse a tree entry named %s. Got: %s", name, out)
+		}
+	}
+}
+
+func Test_FastExportImport(t *testing.T) {
+	tempDir, _ := os.MkdirTemp("", "gitre-fast-*")
+	defer os.RemoveAll(tempDir)
+	src := filepath.Join(tempDir, "src")
+	os.Mkdir(src, 0755)
+	setupInit(t, src)
+	os.MkdirAll(filepath.Join(src, "dir"), 0755)
+	os.WriteFile(filepath.Join(src, "dir", "a.txt"), []byte("one\n"), 0644)
+	os.WriteFile(filepath.Join(src, "run.sh"), []byte("#!/bin/sh\n"), 0755)
+	runCommand(t, src, "add", "dir", "run.sh")
+	runCommand(t, src, "commit", "-m", "first")
+	runCommand(t, src, "rm", "dir/a.txt")
+	os.WriteFile(filepath.Join(src, "b.txt"), []byte("two\n"), 0644)
+	os.WriteFile(filepath.Join(src, "café \"1\".txt"), []byte("three\n"), 0644)
+	runCommand(t, src, "add", "b.txt", "café \"1\".txt")
+	runCommand(t, src, "commit", "-m", "second")
+	runCommand(t, src, "config", "user.name", "Ann Author")
+	cmd := exec.Command(binPath, "fast-import")
+	cmd.Dir = src
+	cmd.Stdin = strings.NewReader("reset refs/tags/v0\nfrom main~1\n")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("fast-import should create the tag. Got: %s", out)
+	}
+
+	stream := runCommand(t, src, "fast-export", "--all")
+	if !strings.Contains(stream, "commit refs/heads/main") || !strings.Contains(stream, "author Ann Author <gitre@localhost> 0 +0000") || !strings.Contains(stream, "D dir/a.txt") {
+		t.Errorf("fast-export should write commits with their changes. Got: %s", stream)
+	}
+	if !strings.Contains(stream, `M 100644 :5 "caf\303\251 \"1\".txt"`) {
+		t.Errorf("fast-export should quote paths the way git does. Got: %s", stream)
+	}
+	if !strings.HasSuffix(stream, "reset refs/tags/v0\nfrom :3\n\n") {
+		t.Errorf("fast-export should write the tags after the branches. Got: %s", stream)
+	}
+
+	dst := filepath.Join(tempDir, "dst")
+	os.Mkdir(dst, 0755)
+	setupInit(t, dst)
+	cmd = exec.Command(binPath, "fast-import")
+	cmd.Dir = dst
+	cmd.Stdin = strings.NewReader(stream)
+	if out, err := cmd.CombinedOutput(); err != nil || !strings.Contains(string(out), "imported 4 blobs and 2 commits") || !strings.Contains(string(out), "refs/tags/v0") {
+		t.Fatalf("fast-import should read the stream. Got: %s", out)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "b.txt")); string(data) != "two\n" {
+		t.Errorf("fast-import should check out the imported branch of an empty repository. Got: %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "café \"1\".txt")); string(data) != "three\n" {
+		t.Errorf("quoted paths should be read back. Got: %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "dir", "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("the deleted file should not be checked out")
+	}
+	if output := runCommand(t, dst, "fast-export", "--all"); output != stream {
+		t.Errorf("exporting the imported history should give the same stream.\nExpected: %s\nGot: %s", stream, output)
+	}
+
+	// scripts can append to the current branch with inline and delimited data
+	script := `commit refs/heads/main
+committer Bot <bot@example.com> 1700000000 +0000
+data <<END
+scripted
+END
+M 100644 inline notes/a file.txt
+data 6
+hello
+R b.txt c.txt
+
+tag v1
+from refs/heads/main
+tagger Bot <bot@example.com> 1700000000 +0000
+data 0
+`
+	cmd = exec.Command(binPath, "fast-import")
+	cmd.Dir = dst
+	cmd.Stdin = strings.NewReader(script)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("fast-import should accept a scripted stream. Got: %s", out)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "notes", "a file.txt")); string(data) != "hello\n" {
+		t.Errorf("the scripted commit should update the working tree. Got: %q", data)
+	}
+	if output := runCommand(t, dst, "status", "--short"); output != "?? .gitreignore\n" {
+		t.Errorf("the working tree should match the new commit. Got: %s", output)
+	}
+	if output := runCommand(t, dst, "log", "v1"); !strings.Contains(output, "author Bot <bot@example.com>") || !strings.Contains(output, "scripted") {
+		t.Errorf("the tag should point at the scripted commit. Got: %s", output)
+	}
+
+	// a ref is not moved backwards unless forced
+	cmd = exec.Command(binPath, "fast-import")
+	cmd.Dir = dst
+	cmd.Stdin = strings.NewReader("reset refs/heads/main\nfrom main~2\n")
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "not updating refs/heads/main") {
+		t.Errorf("fast-import should refuse to rewind a branch. Got: %s", out)
+	}
+
+	// paths and refs from the stream stay inside the repository
+	for _, bad := range []string{
+		"commit refs/heads/main\ncommitter Bot <bot@example.com> 1700000000 +0000\ndata 0\nM 100644 inline ../escaped\ndata 0\n",
+		"commit refs/heads/main\ncommitter Bot <bot@example.com> 1700000000 +0000\ndata 0\nM 100644 inline .gitre/HEAD\ndata 0\n",
+		"reset refs/../../escaped\nfrom main\n",
+	} {
+		cmd = exec.Command(binPath, "fast-import")
+		cmd.Dir = dst
+		cmd.Stdin = strings.NewReader(bad)
+		if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "invalid") {
+			t.Errorf("fast-import should reject %q. Got: %s", bad, out)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dst), "escaped")); err == nil {
+		t.Errorf("fast-import should not write outside the repository")
+	}
+}
+
 func runCommand(t *testing.T, dir string, name string, args ...string) string {
 	cmd := exec.Command(binPath, append([]string{name}, args...)...)
 	cmd.Dir = dir
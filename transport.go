@@ -0,0 +1,528 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gitre/gitre"
+)
+
+// resolves a remote name from the config (remote.<name>.url), a path, an http url or a bundle file; returns the transport and its url
+func openRemote(remote string) (gitre.Transport, string, error) {
+	url := remoteURL(remote)
+	if url == "" {
+		url = remote
+		if repo != nil && !repo.IsBare() && !gitre.IsURL(remote) {
+			url = userPath(remote)
+		}
+	}
+	if gitre.IsURL(url) {
+		return &gitre.HTTPTransport{URL: url}, url, nil
+	}
+	if gitre.IsBundle(url) {
+		b, err := gitre.OpenBundle(url)
+		if err != nil {
+			return nil, "", err
+		}
+		return &gitre.BundleTransport{Bundle: b}, url, nil
+	}
+	remoteRepo, err := gitre.OpenRemote(url)
+	if err != nil {
+		return nil, "", err
+	}
+	return &gitre.LocalTransport{Repo: remoteRepo}, url, nil
+}
+
+// url of a configured remote, empty when name is not one
+func remoteURL(name string) string {
+	if repo == nil {
+		return ""
+	}
+	cfg, err := repo.ReadConfig()
+	if err != nil {
+		return ""
+	}
+	return cfg.Get("remote." + name + ".url")
+}
+
+// clone [--bare] <path, url or bundle> [<dir>]
+func clone(args []string) error {
+	bare := false
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case arg == "--bare":
+			bare = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option for clone: %s", arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) == 0 || len(positional) > 2 {
+		return fmt.Errorf("usage: gitre clone [--bare] <path, url or bundle> [<dir>]")
+	}
+	source := positional[0]
+	if !gitre.IsURL(source) {
+		abs, err := filepath.Abs(source)
+		if err != nil {
+			return fmt.Errorf("invalid path %s: %w", source, err)
+		}
+		source = abs
+	}
+	// a repository directory given directly is named after the working tree holding it
+	base := path.Base(filepath.ToSlash(source))
+	if base == gitre.DirName {
+		base = path.Base(path.Dir(filepath.ToSlash(source)))
+	}
+	dir := strings.TrimSuffix(strings.TrimSuffix(base, gitre.DirName), ".bundle")
+	if len(positional) == 2 {
+		dir = positional[1]
+	}
+	if dir == "" || dir == "." || dir == "/" {
+		return fmt.Errorf("cannot guess a directory name from %s, name the directory to clone into", positional[0])
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("destination path '%s' already exists and is not an empty directory", dir)
+	}
+
+	t, _, err := openRemote(source)
+	if err != nil {
+		return err
+	}
+	refs, head, err := t.Refs()
+	if err != nil {
+		return err
+	}
+	if err := gitre.CheckRemoteRefs(refs, head); err != nil {
+		return err
+	}
+
+	_, statErr := os.Stat(dir)
+	created := os.IsNotExist(statErr)
+	fmt.Printf("Cloning into '%s'...\n", dir)
+	if bare {
+		repo, err = gitre.Init(dir, "")
+	} else {
+		repo, err = gitre.Init(filepath.Join(dir, gitre.DirName), dir)
+	}
+	if err != nil {
+		return err
+	}
+	var wants []string
+	for _, name := range gitre.SortedRefs(refs) {
+		wants = append(wants, refs[name])
+	}
+	if _, err := t.Fetch(repo, wants); err != nil {
+		// nothing was checked out yet, a failed clone leaves no repository behind
+		if created {
+			os.RemoveAll(dir)
+		} else {
+			os.RemoveAll(repo.GitDir)
+		}
+		return err
+	}
+
+	cfg, err := repo.ReadConfig()
+	if err != nil {
+		return err
+	}
+	cfg.Set("remote.origin.url", source)
+	if !bare && strings.HasPrefix(head, "refs/heads/") {
+		branch := strings.TrimPrefix(head, "refs/heads/")
+		cfg.Set("branch."+branch+".remote", "origin")
+		cfg.Set("branch."+branch+".merge", head)
+	}
+	if err := cfg.Write(); err != nil {
+		return err
+	}
+
+	if head != "" {
+		if err := os.WriteFile(repo.Path("HEAD"), []byte("ref: "+head+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to update HEAD: %w", err)
+		}
+	}
+	// a bare clone mirrors every branch and tag, a working clone starts with the branch the remote has checked out
+	for _, name := range gitre.SortedRefs(refs) {
+		if bare || name == head || strings.HasPrefix(name, "refs/tags/") {
+			if err := repo.UpdateRef(name, refs[name]); err != nil {
+				return fmt.Errorf("failed to create %s: %w", name, err)
+			}
+		}
+		if branch, ok := strings.CutPrefix(name, "refs/heads/"); ok && !bare {
+			if err := repo.UpdateRef("refs/remotes/origin/"+branch, refs[name]); err != nil {
+				return fmt.Errorf("failed to create origin/%s: %w", branch, err)
+			}
+		}
+	}
+	if refs[head] == "" {
+		fmt.Println("warning: You appear to have cloned an empty repository.")
+		return nil
+	}
+	if bare {
+		return nil
+	}
+	target, err := repo.ReadCommitTree(refs[head])
+	if err != nil {
+		return err
+	}
+	return resetHard("", target)
+}
+
+// fetch <remote> [<branch>...], a configured remote updates refs/remotes/<remote>/<branch>; the fetched
+// branches are also recorded in FETCH_HEAD
+func fetch(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: gitre fetch <remote> [<branch>...]")
+	}
+	remote := args[0]
+	t, url, err := openRemote(remote)
+	if err != nil {
+		return err
+	}
+	tracking := remoteURL(remote) != ""
+	if tracking {
+		if err := checkRemoteName(remote); err != nil {
+			return err
+		}
+	}
+	refs, _, err := t.Refs()
+	if err != nil {
+		return err
+	}
+	if err := gitre.CheckRemoteRefs(refs, ""); err != nil {
+		return err
+	}
+
+	var names []string
+	if len(args) > 1 {
+		for _, branch := range args[1:] {
+			name := "refs/heads/" + strings.TrimPrefix(branch, "refs/heads/")
+			if refs[name] == "" {
+				return fmt.Errorf("couldn't find remote ref %s", branch)
+			}
+			names = append(names, name)
+		}
+	} else {
+		for _, name := range gitre.SortedRefs(refs) {
+			if strings.HasPrefix(name, "refs/heads/") {
+				names = append(names, name)
+			}
+		}
+	}
+
+	var wants []string
+	var fetchHead strings.Builder
+	for _, name := range names {
+		wants = append(wants, refs[name])
+		fmt.Fprintf(&fetchHead, "%s\tbranch '%s' of %s\n", refs[name], strings.TrimPrefix(name, "refs/heads/"), url)
+	}
+	count, err := t.Fetch(repo, wants)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(repo.Path("FETCH_HEAD"), []byte(fetchHead.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write FETCH_HEAD: %w", err)
+	}
+
+	fmt.Printf("From %s\n", url)
+	for _, name := range names {
+		branch := strings.TrimPrefix(name, "refs/heads/")
+		if !tracking {
+			fmt.Printf(" * %-17s %s -> FETCH_HEAD\n", shortHash(refs[name]), branch)
+			continue
+		}
+		trackingRef := "refs/remotes/" + remote + "/" + branch
+		old, err := repo.ReadRef(trackingRef)
+		if err != nil {
+			return err
+		}
+		if old == refs[name] {
+			continue
+		}
+		if err := repo.UpdateRef(trackingRef, refs[name]); err != nil {
+			return fmt.Errorf("failed to update %s: %w", trackingRef, err)
+		}
+		target := remote + "/" + branch
+		fastForward, _ := repo.IsAncestor(old, refs[name])
+		switch {
+		case old == "":
+			fmt.Printf(" * %-17s %s -> %s\n", "[new branch]", branch, target)
+		case fastForward:
+			fmt.Printf("   %-17s %s -> %s\n", shortHash(old)+".."+shortHash(refs[name]), branch, target)
+		default:
+			fmt.Printf(" + %-17s %s -> %s (forced update)\n", shortHash(old)+"..."+shortHash(refs[name]), branch, target)
+		}
+	}
+	fmt.Printf("fetched %d objects\n", count)
+	return nil
+}
+
+// push [-f] [--no-verify] <remote> [<branch>], the current branch when none is given
+func push(args []string) error {
+	force, noVerify := false, false
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case arg == "-f" || arg == "--force":
+			force = true
+		case arg == "--no-verify":
+			noVerify = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option for push: %s", arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) == 0 || len(positional) > 2 {
+		return fmt.Errorf("usage: gitre push [-f] [--no-verify] <remote> [<branch>]")
+	}
+	remote := positional[0]
+	var refName string
+	if len(positional) == 2 {
+		refName = "refs/heads/" + strings.TrimPrefix(positional[1], "refs/heads/")
+	} else {
+		head, _, err := repo.ReadHead()
+		if err != nil {
+			return err
+		}
+		if head == "" {
+			return fmt.Errorf("you are not currently on a branch, name the branch to push")
+		}
+		refName = head
+	}
+	branch := strings.TrimPrefix(refName, "refs/heads/")
+	local, err := repo.ReadRef(refName)
+	if err != nil {
+		return err
+	}
+	if local == "" {
+		return fmt.Errorf("src refspec %s does not match any", branch)
+	}
+
+	t, url, err := openRemote(remote)
+	if err != nil {
+		return err
+	}
+	refs, _, err := t.Refs()
+	if err != nil {
+		return err
+	}
+	old := refs[refName]
+	if old == local {
+		fmt.Println("Everything up-to-date")
+		return nil
+	}
+	forced := false
+	if fastForward, err := repo.IsAncestor(old, local); err != nil {
+		return err
+	} else if !fastForward {
+		if !force {
+			return fmt.Errorf("rejected %s -> %s (non-fast-forward): the remote has commits you do not have, fetch and integrate them or push with --force", branch, branch)
+		}
+		forced = true
+	}
+
+	if !noVerify {
+		remoteHash := old
+		if remoteHash == "" {
+			remoteHash = gitre.ZeroHash
+		}
+		stdin := fmt.Sprintf("%s %s %s %s\n", refName, local, refName, remoteHash)
+		if err := runHook("pre-push", []string{remote, url}, stdin); err != nil {
+			return err
+		}
+	}
+
+	if err := t.Push(repo, []gitre.RefUpdate{{Name: refName, Old: old, New: local}}); err != nil {
+		return err
+	}
+	if remoteURL(remote) != "" {
+		if err := repo.UpdateRef("refs/remotes/"+remote+"/"+branch, local); err != nil {
+			return fmt.Errorf("failed to update %s/%s: %w", remote, branch, err)
+		}
+	}
+	fmt.Printf("To %s\n", url)
+	switch {
+	case old == "":
+		fmt.Printf(" * [new branch]      %s -> %s\n", branch, branch)
+	case forced:
+		fmt.Printf(" + %s...%s %s -> %s (forced update)\n", shortHash(old), shortHash(local), branch, branch)
+	default:
+		fmt.Printf("   %s..%s  %s -> %s\n", shortHash(old), shortHash(local), branch, branch)
+	}
+	return nil
+}
+
+// pull [--ff-only|--no-ff|--rebase|--no-rebase] [<remote> [<branch>]], fetches a branch and integrates it into the
+// current one, by default its upstream; the integration mode falls back to pull.rebase and pull.ff from the config.
+// a fast-forward keeps unrelated local changes, merging or rebasing diverging branches needs a clean working tree
+func pull(args []string) error {
+	mode, noFF := "", false
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case arg == "--ff-only":
+			mode = "ff-only"
+		case arg == "--no-ff":
+			mode, noFF = "merge", true
+		case arg == "-r" || arg == "--rebase":
+			mode = "rebase"
+		case arg == "--no-rebase":
+			mode = "merge"
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option for pull: %s", arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) > 2 {
+		return fmt.Errorf("usage: gitre pull [--ff-only|--no-ff|--rebase|--no-rebase] [<remote> [<branch>]]")
+	}
+	head, headHash, err := repo.ReadHead()
+	if err != nil {
+		return err
+	}
+	if head == "" {
+		return fmt.Errorf("you are not currently on a branch, name the remote and branch to pull")
+	}
+	cfg, err := repo.ReadConfig()
+	if err != nil {
+		return err
+	}
+	current := strings.TrimPrefix(head, "refs/heads/")
+	remote := cfg.Get("branch." + current + ".remote")
+	remoteBranch := strings.TrimPrefix(cfg.Get("branch."+current+".merge"), "refs/heads/")
+	switch len(positional) {
+	case 0:
+		if remote == "" {
+			return fmt.Errorf("there is no tracking information for the current branch, use 'gitre pull <remote> <branch>' or set one with 'gitre branch --set-upstream-to'")
+		}
+	case 1:
+		if positional[0] != remote {
+			return fmt.Errorf("name the branch to pull from %s", positional[0])
+		}
+	default:
+		remote, remoteBranch = positional[0], positional[1]
+	}
+	if mode == "" {
+		mode = "merge"
+		if cfg.Get("pull.ff") == "only" {
+			mode = "ff-only"
+		}
+		if cfg.Get("pull.rebase") == "true" {
+			mode = "rebase"
+		}
+	}
+
+	if err := fetch([]string{remote, remoteBranch}); err != nil {
+		return err
+	}
+	target, err := repo.ResolveRev("FETCH_HEAD")
+	if err != nil {
+		return err
+	}
+	if headHash == "" {
+		return resetToCommit(target)
+	}
+
+	base, err := repo.MergeBase(headHash, target)
+	if err != nil {
+		return err
+	}
+	switch {
+	case base == target:
+		fmt.Println("Already up to date.")
+		return nil
+	case base == headHash && !noFF:
+		// a fast-forward keeps local changes unless the pulled commits touch the same paths
+		from, err := repo.ReadCommitTree(headHash)
+		if err != nil {
+			return err
+		}
+		to, err := repo.ReadCommitTree(target)
+		if err != nil {
+			return err
+		}
+		oldChanged, newChanged := changedEntries(from, to)
+		idx, err := repo.ReadIndex()
+		if err != nil {
+			return fmt.Errorf("failed to load index: %w", err)
+		}
+		if conflicts := localChanges(idx, from, oldChanged, newChanged); len(conflicts) > 0 {
+			return fmt.Errorf("your local changes to the following files would be overwritten by pull:\n\t%s\ncommit or stash them first", strings.Join(conflicts, "\n\t"))
+		}
+		fmt.Printf("Updating %s..%s\nFast-forward\n", shortHash(headHash), shortHash(target))
+		if err := moveWorkTree(headHash, target, false); err != nil {
+			return err
+		}
+		return repo.UpdateHead(target)
+	case mode == "ff-only":
+		return fmt.Errorf("not possible to fast-forward, aborting; pull with --rebase or --no-rebase to integrate the diverging branches")
+	}
+
+	// merging and rebasing need a clean working tree, so check it before either starts
+	if _, err := requireCleanHead("pull"); err != nil {
+		return err
+	}
+	if mode == "rebase" {
+		return rebase([]string{"FETCH_HEAD"})
+	}
+	url := remoteURL(remote)
+	if url == "" {
+		url = remote
+	}
+	mergeArgs := []string{"-m", fmt.Sprintf("Merge branch '%s' of %s\n", remoteBranch, url)}
+	if noFF {
+		mergeArgs = append(mergeArgs, "--no-ff")
+	}
+	return merge(append(mergeArgs, "FETCH_HEAD"))
+}
+
+// serve --http <addr> [--enable-push] [<dir>], serves the repository at dir, or every repository below it, until
+// killed; pushes are only accepted with --enable-push as anyone reaching the address may make them
+func serve(args []string) error {
+	addr, dir, allowPush := "", ".", false
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--http":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--http requires an address")
+			}
+			i++
+			addr = args[i]
+		case strings.HasPrefix(arg, "--http="):
+			addr = strings.TrimPrefix(arg, "--http=")
+		case arg == "--enable-push":
+			allowPush = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option for serve: %s", arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if addr == "" || len(positional) > 1 {
+		return fmt.Errorf("usage: gitre serve --http <addr> [--enable-push] [<dir>]")
+	}
+	if len(positional) == 1 {
+		dir = positional[0]
+	}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("invalid path %s: %w", dir, err)
+	}
+
+	// listening first lets ":0" pick a free port that is then reported
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	fmt.Printf("Serving %s on http://%s\n", root, listener.Addr())
+	return http.Serve(listener, &gitre.HTTPServer{Root: root, AllowPush: allowPush})
+}